@@ -6,11 +6,11 @@ require (
 	github.com/eigenhombre/lexutil v0.0.0-20220312025354-3532b4d3d27f
 	github.com/gdamore/tcell v1.4.0
 	github.com/mattn/go-runewidth v0.0.9
+	golang.org/x/sys v0.0.0-20190626150813-e07cf5db2756
 )
 
 require (
 	github.com/gdamore/encoding v1.0.0 // indirect
 	github.com/lucasb-eyer/go-colorful v1.0.3 // indirect
-	golang.org/x/sys v0.0.0-20190626150813-e07cf5db2756 // indirect
 	golang.org/x/text v0.3.0 // indirect
 )
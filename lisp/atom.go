@@ -21,3 +21,9 @@ func (a Atom) Equal(b Sexpr) bool {
 // True is the generic truthy item.  Everything but Nil is true.  See also Nil
 // in cons.go.
 var True Atom = Atom{"t"}
+
+// isKeyword returns true if a is a keyword, such as :sep, which evaluates to
+// itself.
+func isKeyword(a Atom) bool {
+	return len(a.s) > 1 && a.s[0] == ':'
+}
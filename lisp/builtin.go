@@ -34,17 +34,13 @@ func InitGlobals() Env {
 	return globals
 }
 
+// stdinReader is shared by all readers of stdin, so that input buffered for
+// one line is not lost to the next.
+var stdinReader = bufio.NewReader(os.Stdin)
+
 // ReadLine reads a line from stdin "robustly".
 func ReadLine() (string, error) {
-	bio := bufio.NewReader(os.Stdin)
-	// FIXME: don't discard hasMoreInLine
-	line, _, err := bio.ReadLine()
-	switch err {
-	case nil:
-		return string(line), nil
-	default:
-		return "", err
-	}
+	return readLineFrom(stdinReader)
 }
 
 // Builtin represents a function with a native (Go) implementation.
@@ -58,6 +54,9 @@ type Builtin struct {
 	Doc      *ConsCell
 	Args     *ConsCell
 	Examples *ConsCell
+	// The access to the host Fn needs, which an interpreter's Policy may
+	// deny:
+	Capabilities []Capability
 }
 
 func (b Builtin) String() string {
@@ -78,19 +77,99 @@ func (b Builtin) Equal(o Sexpr) bool {
 	return false
 }
 
+// DefineBuiltin makes a native function available under its Name in the
+// top-level environment of e.  Fn is only called with a number of arguments
+// compatible with FixedArity and NAry.  Once defined, the function is listed
+// by `forms`, `help`, `doc` and the generated documentation just like the
+// functions which come with l1.
+func (e *Env) DefineBuiltin(b *Builtin) error {
+	if b == nil || b.Name == "" {
+		return baseError("builtin must have a name")
+	}
+	if b.Fn == nil {
+		return baseErrorf("builtin %s has no function", b.Name)
+	}
+	if b.FixedArity < 0 {
+		return baseErrorf("builtin %s has negative arity", b.Name)
+	}
+	for _, form := range specialForms {
+		if form.name == b.Name {
+			return baseErrorf("cannot redefine special form %s", b.Name)
+		}
+	}
+	bi := *b
+	if bi.Doc == Nil {
+		bi.Doc = convertStringToDoc("Undocumented")
+	}
+	fn := b.Fn
+	bi.Fn = func(args []Sexpr, env *Env) (Sexpr, error) {
+		if len(args) < bi.FixedArity && bi.NAry {
+			return nil, typedErrorf("arity-error", Nil, "%s expects at least %s, got %d",
+				bi.Name, countArgs(bi.FixedArity), len(args))
+		}
+		if !bi.NAry && len(args) != bi.FixedArity {
+			expected := countArgs(bi.FixedArity)
+			if bi.FixedArity == 1 {
+				expected = "a single argument"
+			}
+			return nil, typedErrorf("arity-error", Nil, "%s expects %s, got %d",
+				bi.Name, expected, len(args))
+		}
+		return fn(args, env)
+	}
+	bi.Fn = guarded(&bi, bi.Fn)
+	err := e.SetTopLevel(bi.Name, &bi)
+	if err != nil {
+		return extendError("defining builtin", err)
+	}
+	return nil
+}
+
+// countArgs returns n arguments, in words, for arity errors.
+func countArgs(n int) string {
+	switch n {
+	case 0:
+		return "no arguments"
+	case 1:
+		return "one argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
+
+// definedBuiltins returns the builtins added to e (or its parents) with
+// DefineBuiltin, as opposed to those native to l1 or merely bound to another
+// name with `def`.
+func definedBuiltins(e *Env) []*Builtin {
+	ret := []*Builtin{}
+	seen := map[string]bool{}
+	for _, name := range EnvKeys(e) {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		v, _ := e.Lookup(name)
+		b, ok := v.(*Builtin)
+		if !ok || b.Name != name || builtins[name] == b {
+			continue
+		}
+		ret = append(ret, b)
+	}
+	return ret
+}
+
 func compareMultipleNums(cmp func(a, b Number) bool, args []Sexpr) (Sexpr, error) {
 	if len(args) < 1 {
-		return nil, baseError("missing argument")
+		return nil, typedError("arity-error", Nil, "missing argument")
 	}
 	first, ok := args[0].(Number)
 	if !ok {
-		return nil, baseError(fmt.Sprintf("'%s' is not a number", args[0]))
+		return nil, typedErrorf("type-error", args[0], "'%s' is not a number", args[0])
 	}
 	last := first
 	for i := 1; i < len(args); i++ {
 		num, ok := args[i].(Number)
 		if !ok {
-			return nil, baseError(fmt.Sprintf("'%s' is not a number", args[i]))
+			return nil, typedErrorf("type-error", args[i], "'%s' is not a number", args[i])
 		}
 		if !cmp(num, last) {
 			return Nil, nil
@@ -100,60 +179,66 @@ func compareMultipleNums(cmp func(a, b Number) bool, args []Sexpr) (Sexpr, error
 	return True, nil
 }
 
+// numberArg returns the single numeric argument of the named builtin.
+func numberArg(name string, args []Sexpr) (Number, error) {
+	if len(args) != 1 {
+		return Number{}, typedErrorf("arity-error", Nil, "%s expects a single argument", name)
+	}
+	n, ok := args[0].(Number)
+	if !ok {
+		return Number{}, typedErrorf("type-error", args[0], "'%s' is not a number", args[0])
+	}
+	return n, nil
+}
+
 func applyFn(args []Sexpr, env *Env) (Sexpr, error) {
 	if len(args) < 2 {
-		return nil, baseError("apply: not enough arguments")
+		return nil, typedError("arity-error", Nil, "apply: not enough arguments")
 	}
 	l := len(args)
 	var fnArgs []Sexpr
 	// Support (apply f a b l) where l is a list and a, b are scalars:
 	singleArgs := args[1 : l-1]
-	c, ok := args[l-1].(*ConsCell)
-	if !ok {
-		return nil, baseError(fmt.Sprintf("'%s' is not a list", args[l-1]))
-	}
-	asCons, err := consToExprs(c)
-	if err != nil {
-		return nil, extendError("apply", err)
-	}
-	fnArgs = append(singleArgs, asCons...)
-
-	// Note: what follows is very similar to the function evaluation
-	// logic in eval(), but TCO (goto) there makes it hard to DRY out with
-	// respect to what follows.
-
-	evalCar := args[0]
-	// User-defined functions:
-	lambda, ok := evalCar.(*lambdaFn)
-	if ok {
-		newEnv := mkEnv(lambda.env)
-		err := setLambdaArgsInEnv(&newEnv, lambda, fnArgs)
+	var lastArgs []Sexpr
+	switch t := args[l-1].(type) {
+	case *Vector:
+		lastArgs = t.items
+	case *ConsCell:
+		var err error
+		lastArgs, err = consToExprs(t)
 		if err != nil {
 			return nil, extendError("apply", err)
 		}
-		var ret Sexpr = Nil
-		bodyExpr := lambda.body
-		for {
-			if bodyExpr == Nil {
-				return ret, nil
-			}
-			ret, err = eval(bodyExpr.car, &newEnv)
-			if err != nil {
-				return nil, extendError("apply", err)
-			}
-			bodyExpr = bodyExpr.cdr.(*ConsCell)
-		}
+	default:
+		return nil, typedErrorf("type-error", args[l-1], "'%s' is not a list", args[l-1])
 	}
-	// Built-in functions:
-	builtin, ok := evalCar.(*Builtin)
-	if !ok {
-		return nil, baseError(fmt.Sprintf("%s is not a function", evalCar))
+	fnArgs = append(append([]Sexpr{}, singleArgs...), lastArgs...)
+
+	switch args[0].(type) {
+	case *lambdaFn, *Builtin:
+	default:
+		return nil, typedErrorf("type-error", args[0], "%s is not a function", args[0])
 	}
-	biResult, err := builtin.Fn(fnArgs, env)
+	ret, err := funcall(args[0], fnArgs, env)
 	if err != nil {
 		return nil, extendError("apply", err)
 	}
-	return biResult, nil
+	return ret, nil
+}
+
+// funcall calls the function f with args.
+func funcall(f Sexpr, args []Sexpr, env *Env) (Sexpr, error) {
+	switch t := f.(type) {
+	case *lambdaFn:
+		newEnv, err := callEnv(t, args, env)
+		if err != nil {
+			return nil, err
+		}
+		return evalBody(t, newEnv)
+	case *Builtin:
+		return t.Fn(args, env)
+	}
+	return nil, typedErrorf("type-error", f, "%s is not a function", f)
 }
 
 func LoadFile(e *Env, filename string) error {
@@ -161,14 +246,15 @@ func LoadFile(e *Env, filename string) error {
 	if err != nil {
 		return err
 	}
-	err = LexParseEval(string(bytes), e)
+	err = lexParseEvalFile(filename, string(bytes), e)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// moving `builtins` into `init` avoids initialization loop for doHelp:
+// moving `builtins` into `init` avoids initialization loop for doHelp.  It
+// isn't changed after init, so tasks may read it at once without locking:
 var builtins map[string]*Builtin
 
 func init() {
@@ -200,6 +286,16 @@ func init() {
 	RO := func(s string) *ConsCell {
 		return Cons(Nil, Atom{s})
 	}
+	S := func(s string) String { return String{s} }
+	F := func(f float64) Number { return Num(f) }
+	H := func(xs ...Sexpr) *HashMap {
+		h := mkHashMap()
+		for i := 0; i+1 < len(xs); i += 2 {
+			h.set(xs[i], xs[i+1])
+		}
+		return h
+	}
+	V := func(xs ...Sexpr) *Vector { return &Vector{xs} }
 	DOC := func(s string) *ConsCell {
 		return convertStringToDoc(capitalize(s))
 	}
@@ -222,7 +318,7 @@ func init() {
 				for _, arg := range args {
 					n, ok := arg.(Number)
 					if !ok {
-						return nil, baseError(fmt.Sprintf("expected number, got '%s'", arg))
+						return nil, typedErrorf("type-error", arg, "expected number, got '%s'", arg)
 					}
 					sum = sum.Add(n)
 				}
@@ -242,11 +338,11 @@ func init() {
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) == 0 {
-					return nil, baseError("missing argument")
+					return nil, typedError("arity-error", Nil, "missing argument")
 				}
 				sum, ok := args[0].(Number)
 				if !ok {
-					return nil, baseError(fmt.Sprintf("expected number, got '%s'", args[0]))
+					return nil, typedErrorf("type-error", args[0], "expected number, got '%s'", args[0])
 				}
 				if len(args) == 1 {
 					return args[0].(Number).Neg(), nil
@@ -254,7 +350,7 @@ func init() {
 				for _, arg := range args[1:] {
 					n, ok := arg.(Number)
 					if !ok {
-						return nil, baseError(fmt.Sprintf("expected number, got '%s'", arg))
+						return nil, typedErrorf("type-error", arg, "expected number, got '%s'", arg)
 					}
 					sum = sum.Sub(n)
 				}
@@ -279,7 +375,7 @@ func init() {
 				for _, arg := range args {
 					n, ok := arg.(Number)
 					if !ok {
-						return nil, baseError(fmt.Sprintf("expected number, got '%s'", arg))
+						return nil, typedErrorf("type-error", arg, "expected number, got '%s'", arg)
 					}
 					prod = prod.Mul(n)
 				}
@@ -288,30 +384,31 @@ func init() {
 		},
 		"/": {
 			Name:       "/",
-			Doc:        DOC("Divide the first argument by the rest"),
+			Doc:        DOC("Divide the first argument by the rest; the result is exact unless an argument is a float"),
 			FixedArity: 2,
 			NAry:       true,
 			Args:       C(A("numerator"), C(A("denominator1"), A("more"))),
 			Examples: E(
 				LE(A("/"), N(1), N(2)),
 				LE(A("/"), N(12), N(2), N(3)),
+				LE(A("/"), N(1), F(4)),
 				LE(A("/"), N(1), N(0)),
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) < 1 {
-					return nil, baseError("missing argument")
+					return nil, typedError("arity-error", Nil, "missing argument")
 				}
 				quot, ok := args[0].(Number)
 				if !ok {
-					return nil, baseError(fmt.Sprintf("expected number, got '%s'", args[0]))
+					return nil, typedErrorf("type-error", args[0], "expected number, got '%s'", args[0])
 				}
 				for _, arg := range args[1:] {
 					if arg.Equal(Num(0)) {
-						return nil, baseError("division by zero")
+						return nil, typedError("division-by-zero", Nil, "division by zero")
 					}
 					n, ok := arg.(Number)
 					if !ok {
-						return nil, baseError(fmt.Sprintf("expected number, got '%s'", arg))
+						return nil, typedErrorf("type-error", arg, "expected number, got '%s'", arg)
 					}
 					quot = quot.Div(n)
 				}
@@ -331,7 +428,7 @@ func init() {
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) < 1 {
-					return nil, baseError("missing argument")
+					return nil, typedError("arity-error", Nil, "missing argument")
 				}
 				for _, arg := range args[1:] {
 					if !args[0].Equal(arg) {
@@ -354,18 +451,21 @@ func init() {
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 2 {
-					return nil, baseError("rem requires two arguments")
+					return nil, typedError("arity-error", Nil, "rem requires two arguments")
 				}
 				n1, ok := args[0].(Number)
 				if !ok {
-					return nil, baseError(fmt.Sprintf("expected number, got '%s'", args[0]))
+					return nil, typedErrorf("type-error", args[0], "expected number, got '%s'", args[0])
 				}
 				n2, ok := args[1].(Number)
 				if !ok {
-					return nil, baseError(fmt.Sprintf("expected number, got '%s'", args[1]))
+					return nil, typedErrorf("type-error", args[1], "expected number, got '%s'", args[1])
+				}
+				if !n1.isInt() || !n2.isInt() {
+					return nil, baseError("rem expects integers")
 				}
 				if n2.Equal(Num(0)) {
-					return nil, baseError("division by zero")
+					return nil, typedError("division-by-zero", Nil, "division by zero")
 				}
 				return n1.Rem(n2), nil
 			},
@@ -462,7 +562,7 @@ func init() {
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("atom? expects a single argument")
+					return nil, typedError("arity-error", Nil, "atom? expects a single argument")
 				}
 				if _, ok := args[0].(Atom); ok {
 					return True, nil
@@ -470,6 +570,26 @@ func init() {
 				return Nil, nil
 			},
 		},
+		"await": {
+			Name:       "await",
+			Doc:        DOC("Wait for a task to finish, and return the value its function returned, or raise the error it raised"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("task")),
+			Examples: E(
+				LE(A("await"), LE(A("spawn"), A("+"), N(1), N(2))),
+			),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "await expects a single argument")
+				}
+				t, err := taskArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return t.wait(e)
+			},
+		},
 		"body": {
 			Name:       "body",
 			Doc:        DOC("Return the body of a lambda function"),
@@ -481,15 +601,30 @@ func init() {
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("missing argument")
+					return nil, typedError("arity-error", Nil, "missing argument")
 				}
 				l, ok := args[0].(*lambdaFn)
 				if !ok {
-					return nil, baseErrorf("expected lambda function, got '%s'", args[0])
+					return nil, typedErrorf("type-error", args[0], "expected lambda function, got '%s'", args[0])
 				}
 				return l.body, nil
 			},
 		},
+		"call/cc": {
+			Name: "call/cc",
+			Doc: DOC("Call f with the current continuation: a function " +
+				"which, called with a value (or with none, for ()), " +
+				"returns it from call/cc at once.  The continuation " +
+				"can only be called until call/cc returns"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("f")),
+			Examples: E(
+				LE(A("call/cc"), LE(A("lambda"), LC(A("k")), LE(A("+"), N(1), LE(A("k"), N(2))))),
+				LE(A("call/cc"), LE(A("lambda"), LC(A("k")), N(3))),
+			),
+			Fn: callCC,
+		},
 		"car": {
 			Name:       "car",
 			Doc:        DOC("Return the first element of a list"),
@@ -502,11 +637,11 @@ func init() {
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("missing argument")
+					return nil, typedError("arity-error", Nil, "missing argument")
 				}
 				carCons, ok := args[0].(*ConsCell)
 				if !ok {
-					return nil, baseErrorf("'%s' is not a list", args[0])
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a list", args[0])
 				}
 				if carCons == Nil {
 					return Nil, nil
@@ -527,11 +662,11 @@ func init() {
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("missing argument")
+					return nil, typedError("arity-error", Nil, "missing argument")
 				}
 				cdrCons, ok := args[0].(*ConsCell)
 				if !ok {
-					return nil, baseErrorf("'%s' is not a list", args[0])
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a list", args[0])
 				}
 				if cdrCons == Nil {
 					return Nil, nil
@@ -550,13 +685,164 @@ func init() {
 				LE(A("cons"), N(1), LE()),
 				LE(A("cons"), N(1), N(2)),
 			),
-			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
 				if len(args) != 2 {
-					return nil, baseError("missing argument")
+					return nil, typedError("arity-error", Nil, "missing argument")
 				}
+				e.countConses(1)
 				return Cons(args[0], args[1]), nil
 			},
 		},
+		"chan": {
+			Name: "chan",
+			Doc: DOC("Make a channel, for tasks to send values to each other.  " +
+				"Sending on a channel waits until the value is received, unless " +
+				"the channel is given a buffer of n values which isn't full"),
+			FixedArity: 0,
+			NAry:       true,
+			Args:       RO("n"),
+			Examples: E(
+				LE(A("chan")),
+				LE(A("chan"), N(10)),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				switch len(args) {
+				case 0:
+					return &Channel{make(chan Sexpr)}, nil
+				case 1:
+					n, ok := args[0].(Number)
+					if !ok || !n.isInt() || n.Less(Num(0)) || !n.bi.IsInt64() {
+						return nil, typedErrorf("type-error", args[0], "'%s' is not a buffer size", args[0])
+					}
+					return &Channel{make(chan Sexpr, n.bi.Int64())}, nil
+				}
+				return nil, typedError("arity-error", Nil, "chan expects at most one argument")
+			},
+		},
+		"condition?": {
+			Name:       "condition?",
+			Doc:        DOC("Return t if the argument is a condition (an error caught with catch), () otherwise"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("condition?"), QA("boom")),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "condition? expects a single argument")
+				}
+				if _, ok := args[0].(*Condition); ok {
+					return True, nil
+				}
+				return Nil, nil
+			},
+		},
+		"condition-cause": {
+			Name:       "condition-cause",
+			Doc:        DOC("Return the condition being handled when condition c was raised, or ()"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("c")),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "condition-cause expects a single argument")
+				}
+				c, err := conditionArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if c.cause == nil {
+					return Nil, nil
+				}
+				return c.cause, nil
+			},
+		},
+		"condition-data": {
+			Name:       "condition-data",
+			Doc:        DOC("Return the data given when condition c was raised"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("c")),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "condition-data expects a single argument")
+				}
+				c, err := conditionArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return c.Data(), nil
+			},
+		},
+		"condition-form": {
+			Name:       "condition-form",
+			Doc:        DOC("Return the innermost form which raised condition c, or () if unknown"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("c")),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "condition-form expects a single argument")
+				}
+				c, err := conditionArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return c.Form(), nil
+			},
+		},
+		"condition-message": {
+			Name:       "condition-message",
+			Doc:        DOC("Return the message of condition c"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("c")),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "condition-message expects a single argument")
+				}
+				c, err := conditionArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return c.Message(), nil
+			},
+		},
+		"condition-trace": {
+			Name:       "condition-trace",
+			Doc:        DOC("Return the trace of condition c, as a list of lists"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("c")),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "condition-trace expects a single argument")
+				}
+				c, err := conditionArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return c.Trace(), nil
+			},
+		},
+		"condition-type": {
+			Name:       "condition-type",
+			Doc:        DOC("Return the type of condition c, such as division-by-zero"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("c")),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "condition-type expects a single argument")
+				}
+				c, err := conditionArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return c.Type(), nil
+			},
+		},
 		"doc": {
 			Name:       "doc",
 			Doc:        DOC("Return the doclist for a function"),
@@ -572,7 +858,7 @@ func init() {
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("missing argument")
+					return nil, typedError("arity-error", Nil, "missing argument")
 				}
 				switch t := args[0].(type) {
 				case *lambdaFn:
@@ -580,29 +866,56 @@ func init() {
 				case *Builtin:
 					return t.Doc.car, nil
 				default:
-					return nil, baseErrorf("'%s' is not a function", args[0])
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a function", args[0])
+				}
+			},
+		},
+		"denominator": {
+			Name:       "denominator",
+			Doc:        DOC("Return the denominator of a rational number in lowest terms"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("denominator"), LE(A("/"), N(6), N(4))),
+				LE(A("denominator"), N(5)),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				n, err := numberArg("denominator", args)
+				if err != nil {
+					return nil, err
 				}
+				if n.kind == floatNum {
+					return nil, typedErrorf("type-error", n, "'%s' is not an exact number", n)
+				}
+				var ret Number
+				ret.bi.Set(n.toRat().Denom())
+				return ret, nil
 			},
 		},
 		"downcase": {
 			Name:       "downcase",
-			Doc:        DOC("Return a new atom with all characters in lower case"),
+			Doc:        DOC("Return a new atom or string with all characters in lower case"),
 			FixedArity: 1,
 			NAry:       false,
 			Args:       LC(A("x")),
 			Examples: E(
 				LE(A("downcase"), QA("Hello")),
 				LE(A("downcase"), QA("HELLO")),
+				LE(A("downcase"), S("Hello, World!")),
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("downcase requires one argument")
+					return nil, typedError("arity-error", Nil, "downcase requires one argument")
 				}
-				a, ok := args[0].(Atom)
-				if !ok {
-					return nil, baseErrorf("expected atom, got '%s'", args[0])
+				switch t := args[0].(type) {
+				case Atom:
+					return Atom{strings.ToLower(t.s)}, nil
+				case String:
+					return String{strings.ToLower(t.s)}, nil
+				default:
+					return nil, typedErrorf("type-error", args[0], "expected atom or string, got '%s'", args[0])
 				}
-				return Atom{strings.ToLower(a.s)}, nil
 			},
 		},
 		"eval": {
@@ -617,17 +930,18 @@ func init() {
 			),
 			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("missing argument")
+					return nil, typedError("arity-error", Nil, "missing argument")
 				}
 				return eval(args[0], e)
 			},
 		},
 		"exit": {
-			Name:       "exit",
-			Doc:        DOC("Exit the program"),
-			FixedArity: 0,
-			NAry:       false,
-			Args:       Nil,
+			Name:         "exit",
+			Doc:          DOC("Exit the program"),
+			FixedArity:   0,
+			NAry:         false,
+			Capabilities: []Capability{Process},
+			Args:         Nil,
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				os.Exit(0)
 				return nil, nil
@@ -647,19 +961,76 @@ func init() {
 				return mkListAsConsWithCdr(forms, Nil), nil
 			},
 		},
+		"float": {
+			Name:       "float",
+			Doc:        DOC("Return the floating-point value nearest the argument"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("float"), N(1)),
+				LE(A("float"), LE(A("/"), N(1), N(3))),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				n, err := numberArg("float", args)
+				if err != nil {
+					return nil, err
+				}
+				return Num(n.toFloat()), nil
+			},
+		},
+		"float?": {
+			Name:       "float?",
+			Doc:        DOC("Return t if the argument is a floating-point number, () otherwise"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("float?"), F(1.5)),
+				LE(A("float?"), N(1)),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "float? expects a single argument")
+				}
+				if n, ok := args[0].(Number); ok && n.kind == floatNum {
+					return True, nil
+				}
+				return Nil, nil
+			},
+		},
+		"floor": {
+			Name:       "floor",
+			Doc:        DOC("Return the greatest integer not greater than the argument"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("floor"), LE(A("/"), N(7), N(2))),
+				LE(A("floor"), F(-2.5)),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				n, err := numberArg("floor", args)
+				if err != nil {
+					return nil, err
+				}
+				return n.Floor()
+			},
+		},
 		"fuse": {
 			Name:       "fuse",
-			Doc:        DOC("Fuse a list of numbers or atoms into a single atom"),
+			Doc:        DOC("Fuse a list of numbers, atoms or strings into a single atom"),
 			FixedArity: 1,
 			NAry:       false,
 			Args:       LC(A("x")),
 			Examples: E(
 				LE(A("fuse"), QL(A("A"), A("B"), A("C"))),
 				LE(A("fuse"), LE(A("reverse"), LE(A("range"), N(10)))),
+				LE(A("fuse"), LE(A("list"), S("ab"), N(2))),
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("fuse expects a single argument")
+					return nil, typedError("arity-error", Nil, "fuse expects a single argument")
 				}
 				if args[0] == Nil {
 					return Nil, nil
@@ -669,8 +1040,7 @@ func init() {
 					cons := s
 					var str string
 					for cons != nil {
-						this := cons.car.String()
-						str += this
+						str += display(cons.car)
 						if cons.cdr == nil {
 							break
 						}
@@ -695,7 +1065,7 @@ func init() {
 			Args:       RO("more"),
 			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
 				if len(args) != 0 && len(args) != 1 {
-					return nil, baseError("gensym expects 0 or 1 arguments")
+					return nil, typedError("arity-error", Nil, "gensym expects 0 or 1 arguments")
 				}
 				if len(args) == 0 {
 					return Atom{gensym("")}, nil
@@ -707,77 +1077,322 @@ func init() {
 				return Atom{gensym("-" + prefix.s)}, nil
 			},
 		},
-		"help": {
-			Name:       "help",
-			Doc:        DOC("Print a help message"),
-			FixedArity: 0,
-			NAry:       false,
-			Args:       Nil,
-			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
-				fmt.Println(ShortDocStr(e))
-				return Nil, nil
-			},
-		},
-		"isqrt": {
-			Name:       "isqrt",
-			Doc:        DOC("Integer square root"),
+		"hash?": {
+			Name:       "hash?",
+			Doc:        DOC("Return t if the argument is a hash map, () otherwise"),
 			FixedArity: 1,
 			NAry:       false,
 			Args:       LC(A("x")),
 			Examples: E(
-				LE(A("isqrt"), N(4)),
-				LE(A("isqrt"), N(5)),
-				// Breaks on several platforms!
-				// L(A("isqrt"), N(9139571243709)),
+				LE(A("hash?"), H(A("a"), N(1))),
+				LE(A("hash?"), QL(A("a"), N(1))),
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("isqrt expects a single argument")
+					return nil, typedError("arity-error", Nil, "hash? expects a single argument")
 				}
-				n, ok := args[0].(Number)
-				if !ok {
-					return nil, baseError("isqrt expects a number")
+				if _, ok := args[0].(*HashMap); ok {
+					return True, nil
 				}
-				sqrt := n.bi.Sqrt(&n.bi)
-				return Num(sqrt.String()), nil
+				return Nil, nil
 			},
 		},
-		"len": {
-			Name:       "len",
-			Doc:        DOC("Return the length of a list"),
-			FixedArity: 1,
+		"hash-contains?": {
+			Name:       "hash-contains?",
+			Doc:        DOC("Return t if the hash map has an entry for the key, () otherwise"),
+			FixedArity: 2,
 			NAry:       false,
-			Args:       LC(A("x")),
+			Args:       LC(A("m"), A("k")),
 			Examples: E(
-				LE(A("len"), LE(A("range"), N(10))),
+				LE(A("hash-contains?"), H(QA("a"), LE()), QA("a")),
+				LE(A("hash-contains?"), H(QA("a"), LE()), QA("b")),
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
-				if len(args) != 1 {
-					return nil, baseError("len expects a single argument")
+				if len(args) != 2 {
+					return nil, typedError("arity-error", Nil, "hash-contains? expects two arguments")
 				}
-				list, ok := args[0].(*ConsCell)
-				if !ok {
-					return nil, baseErrorf("'%s' is not a list", args[0])
+				h, err := hashArg(args[0])
+				if err != nil {
+					return nil, err
 				}
-				count := 0
-				for list != nil {
-					count++
-					list = list.cdr.(*ConsCell)
+				if _, ok := h.Get(args[1]); ok {
+					return True, nil
 				}
-				return Num(count), nil
+				return Nil, nil
 			},
 		},
-		"list": {
-			Name:       "list",
-			Doc:        DOC("Return a list of the given arguments"),
-			FixedArity: 0,
-			NAry:       true,
-			Args:       RO("xs"),
+		"hash-count": {
+			Name:       "hash-count",
+			Doc:        DOC("Return the number of entries in a hash map"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("m")),
 			Examples: E(
-				LE(A("list"), N(1), N(2), N(3)),
-				LE(A("list")),
+				LE(A("hash-count"), H()),
+				LE(A("hash-count"), H(QA("a"), N(1), QA("b"), N(2))),
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "hash-count expects a single argument")
+				}
+				h, err := hashArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return Num(h.Len()), nil
+			},
+		},
+		"hash-del": {
+			Name:       "hash-del",
+			Doc:        DOC("Return a copy of a hash map without the entry for the given key"),
+			FixedArity: 2,
+			NAry:       false,
+			Args:       LC(A("m"), A("k")),
+			Examples: E(
+				LE(A("hash-del"), H(QA("a"), N(1), QA("b"), N(2)), QA("a")),
+				LE(A("hash-del"), H(QA("a"), N(1)), QA("z")),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 2 {
+					return nil, typedError("arity-error", Nil, "hash-del expects two arguments")
+				}
+				h, err := hashArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return h.Del(args[1]), nil
+			},
+		},
+		"hash-get": {
+			Name:       "hash-get",
+			Doc:        DOC("Return the value for a key in a hash map, or the default (or ()) if there is none"),
+			FixedArity: 2,
+			NAry:       true,
+			Args:       C(A("m"), C(A("k"), A("default"))),
+			Examples: E(
+				LE(A("hash-get"), H(QA("a"), N(1), QA("b"), N(2)), QA("b")),
+				LE(A("hash-get"), H(QA("a"), N(1)), QA("z")),
+				LE(A("hash-get"), H(QA("a"), N(1)), QA("z"), N(0)),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 2 && len(args) != 3 {
+					return nil, typedError("arity-error", Nil, "hash-get expects two or three arguments")
+				}
+				h, err := hashArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if v, ok := h.Get(args[1]); ok {
+					return v, nil
+				}
+				if len(args) == 3 {
+					return args[2], nil
+				}
+				return Nil, nil
+			},
+		},
+		"hash-keys": {
+			Name:       "hash-keys",
+			Doc:        DOC("Return a list of the keys of a hash map, in the order they were added"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("m")),
+			Examples: E(
+				LE(A("hash-keys"), H(QA("a"), N(1), QA("b"), N(2))),
+			),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "hash-keys expects a single argument")
+				}
+				h, err := hashArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				e.countConses(h.Len())
+				return mkListAsConsWithCdr(h.Keys(), Nil), nil
+			},
+		},
+		"hash-put": {
+			Name:       "hash-put",
+			Doc:        DOC("Return a copy of a hash map with the key set to the value"),
+			FixedArity: 3,
+			NAry:       false,
+			Args:       LC(A("m"), A("k"), A("v")),
+			Examples: E(
+				LE(A("hash-put"), H(), QA("a"), N(1)),
+				LE(A("hash-put"), H(QA("a"), N(1)), QA("a"), N(2)),
+				LE(A("hash-put"), H(), QL(N(1), N(2)), QA("pair")),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 3 {
+					return nil, typedError("arity-error", Nil, "hash-put expects three arguments")
+				}
+				h, err := hashArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return h.Put(args[1], args[2])
+			},
+		},
+		"hash-vals": {
+			Name:       "hash-vals",
+			Doc:        DOC("Return a list of the values of a hash map, in the same order as hash-keys"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("m")),
+			Examples: E(
+				LE(A("hash-vals"), H(QA("a"), N(1), QA("b"), N(2))),
+			),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "hash-vals expects a single argument")
+				}
+				h, err := hashArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				e.countConses(h.Len())
+				return mkListAsConsWithCdr(h.Vals(), Nil), nil
+			},
+		},
+		"help": {
+			Name:       "help",
+			Doc:        DOC("Print a help message"),
+			FixedArity: 0,
+			NAry:       false,
+			Args:       Nil,
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				doc, err := ShortDocStr(e)
+				if err != nil {
+					return nil, extendError("help", err)
+				}
+				fmt.Fprintln(e.stdout(), doc)
+				return Nil, nil
+			},
+		},
+		"integer?": {
+			Name:       "integer?",
+			Doc:        DOC("Return t if the argument is an integer, () otherwise"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("integer?"), N(1)),
+				LE(A("integer?"), LE(A("/"), N(1), N(2))),
+				LE(A("integer?"), F(1)),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "integer? expects a single argument")
+				}
+				if n, ok := args[0].(Number); ok && n.isInt() {
+					return True, nil
+				}
+				return Nil, nil
+			},
+		},
+		"isqrt": {
+			Name:       "isqrt",
+			Doc:        DOC("Integer square root"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("isqrt"), N(4)),
+				LE(A("isqrt"), N(5)),
+				// Breaks on several platforms!
+				// L(A("isqrt"), N(9139571243709)),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "isqrt expects a single argument")
+				}
+				n, ok := args[0].(Number)
+				if !ok {
+					return nil, baseError("isqrt expects a number")
+				}
+				if !n.isInt() || n.bi.Sign() < 0 {
+					return nil, baseError("isqrt expects a non-negative integer")
+				}
+				var sqrt Number
+				sqrt.bi.Sqrt(&n.bi)
+				return sqrt, nil
+			},
+		},
+		"join": {
+			Name:       "join",
+			Doc:        DOC("Wait for tasks to finish, and return a list of the values their functions returned, or raise the first error raised, in the order of the tasks given"),
+			FixedArity: 0,
+			NAry:       true,
+			Args:       RO("tasks"),
+			Examples: E(
+				LE(A("join"), LE(A("spawn"), A("+"), N(1), N(2)), LE(A("spawn"), A("*"), N(3), N(4))),
+			),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				tasks := make([]*Task, len(args))
+				for i, arg := range args {
+					t, err := taskArg(arg)
+					if err != nil {
+						return nil, err
+					}
+					tasks[i] = t
+				}
+				vals := make([]Sexpr, len(tasks))
+				for i, t := range tasks {
+					val, err := t.wait(e)
+					if err != nil {
+						return nil, err
+					}
+					vals[i] = val
+				}
+				e.countConses(len(vals))
+				return mkListAsConsWithCdr(vals, Nil), nil
+			},
+		},
+		"len": {
+			Name:       "len",
+			Doc:        DOC("Return the length of a list, vector or string"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("len"), LE(A("range"), N(10))),
+				LE(A("len"), S("水果")),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "len expects a single argument")
+				}
+				switch t := args[0].(type) {
+				case String:
+					return Num(utf8.RuneCountInString(t.s)), nil
+				case *Vector:
+					return Num(len(t.items)), nil
+				}
+				list, ok := args[0].(*ConsCell)
+				if !ok {
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a list", args[0])
+				}
+				count := 0
+				for list != nil {
+					count++
+					list = list.cdr.(*ConsCell)
+				}
+				return Num(count), nil
+			},
+		},
+		"list": {
+			Name:       "list",
+			Doc:        DOC("Return a list of the given arguments"),
+			FixedArity: 0,
+			NAry:       true,
+			Args:       RO("xs"),
+			Examples: E(
+				LE(A("list"), N(1), N(2), N(3)),
+				LE(A("list")),
+			),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				e.countConses(len(args))
 				return mkListAsConsWithCdr(args, Nil), nil
 			},
 		},
@@ -793,7 +1408,7 @@ func init() {
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("list? expects a single argument")
+					return nil, typedError("arity-error", Nil, "list? expects a single argument")
 				}
 				if _, ok := args[0].(*ConsCell); ok {
 					return True, nil
@@ -802,20 +1417,26 @@ func init() {
 			},
 		},
 		"load": {
-			Name:       "load",
-			Doc:        DOC("Load and execute a file"),
-			FixedArity: 1,
-			NAry:       false,
-			Args:       LC(A("filename")),
+			Name:         "load",
+			Doc:          DOC("Load and execute a file, unless it has been loaded already"),
+			FixedArity:   1,
+			NAry:         false,
+			Capabilities: []Capability{Filesystem},
+			Args:         LC(A("filename")),
 			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("load expects a single argument")
+					return nil, typedError("arity-error", Nil, "load expects a single argument")
 				}
-				filename, ok := args[0].(Atom)
-				if !ok {
+				var filename string
+				switch t := args[0].(type) {
+				case Atom:
+					filename = t.s
+				case String:
+					filename = t.s
+				default:
 					return nil, baseError("load expects a filename")
 				}
-				err := LoadFile(e, filename.s)
+				err := e.globals.modules.load(filename, e)
 				if err != nil {
 					return nil, extendError("load file", err)
 				}
@@ -834,11 +1455,45 @@ func init() {
 			),
 			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("macroexpand-1 expects a single argument")
+					return nil, typedError("arity-error", Nil, "macroexpand-1 expects a single argument")
 				}
 				return macroexpand1(args[0], e)
 			},
 		},
+		"macroexpand": {
+			Name:       "macroexpand",
+			Doc:        DOC("Expand a macro call, and the macro call it expands to, if any, and so on; subforms are not expanded"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("macroexpand"), QL(A("when"), A("x"), A("y"))),
+				LE(A("macroexpand"), QL(A("+"), A("x"), N(1))),
+			),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "macroexpand expects a single argument")
+				}
+				return macroexpand(args[0], e)
+			},
+		},
+		"macroexpand-all": {
+			Name:       "macroexpand-all",
+			Doc:        DOC("Expand all the macro calls in a form, including those in its subforms"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("macroexpand-all"), QL(A("when"), A("x"), LC(A("when-not"), A("y"), A("z")))),
+				LE(A("macroexpand-all"), QL(A("quote"), LC(A("when"), A("x"), A("y")))),
+			),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "macroexpand-all expects a single argument")
+				}
+				return macroexpandAll(args[0], e)
+			},
+		},
 		"not": {
 			Name:       "not",
 			Doc:        DOC("Return t if the argument is nil, () otherwise"),
@@ -852,7 +1507,7 @@ func init() {
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("not expects a single argument")
+					return nil, typedError("arity-error", Nil, "not expects a single argument")
 				}
 				if args[0] == Nil {
 					return True, nil
@@ -860,6 +1515,29 @@ func init() {
 				return Nil, nil
 			},
 		},
+		"numerator": {
+			Name:       "numerator",
+			Doc:        DOC("Return the numerator of a rational number in lowest terms"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("numerator"), LE(A("/"), N(6), N(4))),
+				LE(A("numerator"), N(5)),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				n, err := numberArg("numerator", args)
+				if err != nil {
+					return nil, err
+				}
+				if n.kind == floatNum {
+					return nil, typedErrorf("type-error", n, "'%s' is not an exact number", n)
+				}
+				var ret Number
+				ret.bi.Set(n.toRat().Num())
+				return ret, nil
+			},
+		},
 		"number?": {
 			Name:       "number?",
 			Doc:        DOC("Return true if the argument is a number, else ()"),
@@ -873,7 +1551,7 @@ func init() {
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("number? expects a single argument")
+					return nil, typedError("arity-error", Nil, "number? expects a single argument")
 				}
 				_, ok := args[0].(Number)
 				if ok {
@@ -884,31 +1562,54 @@ func init() {
 		},
 		"print": {
 			Name:       "print",
-			Doc:        DOC("Print the arguments"),
+			Doc:        DOC("Print the arguments, strings without quotes"),
 			FixedArity: 0,
 			NAry:       true,
 			Args:       RO("xs"),
-			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
 				strArgs := []string{}
 				for _, arg := range args {
-					strArgs = append(strArgs, arg.String())
+					strArgs = append(strArgs, display(arg))
 				}
-				fmt.Print(strings.Join(strArgs, " "))
+				fmt.Fprint(e.stdout(), strings.Join(strArgs, " "))
+				return Nil, nil
+			},
+		},
+		"pprint": {
+			Name:       "pprint",
+			Doc:        DOC("Print the argument, laid out as code to fit in 80 columns (or the width given), and a newline"),
+			FixedArity: 1,
+			NAry:       true,
+			Args:       C(A("x"), A("width")),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				width := pprintWidth
+				switch len(args) {
+				case 1:
+				case 2:
+					n, ok := args[1].(Number)
+					if !ok || !n.isInt() || n.Less(Num(1)) || !n.bi.IsInt64() {
+						return nil, typedErrorf("type-error", args[1], "'%s' is not a width", args[1])
+					}
+					width = int(n.bi.Int64())
+				default:
+					return nil, typedError("arity-error", Nil, "pprint expects one or two arguments")
+				}
+				fmt.Fprintln(e.stdout(), pprint(args[0], width))
 				return Nil, nil
 			},
 		},
 		"println": {
 			Name:       "println",
-			Doc:        DOC("Print the arguments and a newline"),
+			Doc:        DOC("Print the arguments, strings without quotes, and a newline"),
 			FixedArity: 0,
 			NAry:       true,
 			Args:       RO("xs"),
-			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
 				strArgs := []string{}
 				for _, arg := range args {
-					strArgs = append(strArgs, arg.String())
+					strArgs = append(strArgs, display(arg))
 				}
-				fmt.Println(strings.Join(strArgs, " "))
+				fmt.Fprintln(e.stdout(), strings.Join(strArgs, " "))
 				return Nil, nil
 			},
 		},
@@ -918,31 +1619,35 @@ func init() {
 			FixedArity: 1,
 			NAry:       false,
 			Args:       LC(A("x")),
-			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("missing argument")
+					return nil, typedError("arity-error", Nil, "missing argument")
 				}
 				list, ok := args[0].(*ConsCell)
 				if !ok {
-					return nil, baseErrorf("expected list, got '%s'", args[0])
+					return nil, typedErrorf("type-error", args[0], "expected list, got '%s'", args[0])
 				}
-				fmt.Println(unwrapList(list))
+				fmt.Fprintln(e.stdout(), unwrapList(list))
 				return Nil, nil
 			},
 		},
 		"randint": {
-			Name:       "randint",
-			Doc:        DOC("Return a random integer between 0 and the argument minus 1"),
-			FixedArity: 1,
-			NAry:       false,
-			Args:       LC(A("x")),
+			Name:         "randint",
+			Doc:          DOC("Return a random integer between 0 and the argument minus 1"),
+			FixedArity:   1,
+			NAry:         false,
+			Capabilities: []Capability{Randomness},
+			Args:         LC(A("x")),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("randint expects a single argument")
+					return nil, typedError("arity-error", Nil, "randint expects a single argument")
 				}
 				num, ok := args[0].(Number)
 				if !ok {
-					return nil, baseErrorf("'%s' is not a number", args[0])
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a number", args[0])
+				}
+				if !num.isInt() {
+					return nil, typedErrorf("type-error", args[0], "'%s' is not an integer", args[0])
 				}
 				if num.Equal(N(0)) {
 					return nil, baseError("randint expects a non-zero argument")
@@ -957,8 +1662,8 @@ func init() {
 			FixedArity: 0,
 			NAry:       false,
 			Args:       Nil,
-			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
-				line, err := ReadLine()
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				line, err := e.readLine()
 				if err != nil {
 					return nil, extendError("reading readlist input", err)
 				}
@@ -966,15 +1671,57 @@ func init() {
 				if err != nil {
 					return nil, extendError("parsing readlist input", err)
 				}
+				e.countConses(len(parsed))
 				return mkListAsConsWithCdr(parsed, Nil), nil
 			},
 		},
-		"screen-start": {
-			Name:       "screen-start",
-			Doc:        DOC("Start screen for text UIs"),
-			FixedArity: 0,
+		"recv": {
+			Name:       "recv",
+			Doc:        DOC("Receive a value from a channel, waiting until one is sent"),
+			FixedArity: 1,
 			NAry:       false,
-			Args:       Nil,
+			Args:       LC(A("ch")),
+			Examples: E(
+				LE(A("let"), LC(LC(A("c"), LE(A("chan"), N(1)))),
+					LE(A("send"), A("c"), QA("hello")),
+					LE(A("recv"), A("c"))),
+			),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "recv expects a single argument")
+				}
+				c, err := channelArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return c.recv(e)
+			},
+		},
+		"round": {
+			Name:       "round",
+			Doc:        DOC("Return the integer nearest the argument, rounding halves away from zero"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("round"), LE(A("/"), N(5), N(2))),
+				LE(A("round"), F(-1.4)),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				n, err := numberArg("round", args)
+				if err != nil {
+					return nil, err
+				}
+				return n.Round()
+			},
+		},
+		"screen-start": {
+			Name:         "screen-start",
+			Doc:          DOC("Start screen for text UIs"),
+			FixedArity:   0,
+			NAry:         false,
+			Capabilities: []Capability{Terminal},
+			Args:         Nil,
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				err := termStart()
 				if err != nil {
@@ -984,11 +1731,12 @@ func init() {
 			},
 		},
 		"screen-end": {
-			Name:       "screen-end",
-			Doc:        DOC("Stop screen for text UIs, return to console mode"),
-			FixedArity: 0,
-			NAry:       false,
-			Args:       Nil,
+			Name:         "screen-end",
+			Doc:          DOC("Stop screen for text UIs, return to console mode"),
+			FixedArity:   0,
+			NAry:         false,
+			Capabilities: []Capability{Terminal},
+			Args:         Nil,
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				err := termEnd()
 				if err != nil {
@@ -998,11 +1746,12 @@ func init() {
 			},
 		},
 		"screen-size": {
-			Name:       "screen-size",
-			Doc:        DOC("Return the screen size: width, height"),
-			FixedArity: 0,
-			NAry:       false,
-			Args:       Nil,
+			Name:         "screen-size",
+			Doc:          DOC("Return the screen size: width, height"),
+			FixedArity:   0,
+			NAry:         false,
+			Capabilities: []Capability{Terminal},
+			Args:         Nil,
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				width, height, err := termSize()
 				if err != nil {
@@ -1012,11 +1761,12 @@ func init() {
 			},
 		},
 		"screen-clear": {
-			Name:       "screen-clear",
-			Doc:        DOC("Clear the screen"),
-			FixedArity: 0,
-			NAry:       false,
-			Args:       Nil,
+			Name:         "screen-clear",
+			Doc:          DOC("Clear the screen"),
+			FixedArity:   0,
+			NAry:         false,
+			Capabilities: []Capability{Terminal},
+			Args:         Nil,
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				err := termClear()
 				if err != nil {
@@ -1026,14 +1776,15 @@ func init() {
 			},
 		},
 		"screen-get-key": {
-			Name:       "screen-get-key",
-			Doc:        DOC("Return a keystroke as an atom"),
-			FixedArity: 0,
-			NAry:       false,
-			Args:       Nil,
+			Name:         "screen-get-key",
+			Doc:          DOC("Return a keystroke as an atom"),
+			FixedArity:   0,
+			NAry:         false,
+			Capabilities: []Capability{Terminal},
+			Args:         Nil,
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 0 {
-					return nil, baseError("getkey expects no arguments")
+					return nil, typedError("arity-error", Nil, "getkey expects no arguments")
 				}
 				key, err := termGetKey()
 				if err != nil {
@@ -1043,26 +1794,30 @@ func init() {
 			},
 		},
 		"screen-write": {
-			Name:       "screen-write",
-			Doc:        DOC("Write a string to the screen"),
-			FixedArity: 3,
-			NAry:       false,
-			Args:       LC(A("x"), A("y"), A("list")),
+			Name:         "screen-write",
+			Doc:          DOC("Write a string to the screen"),
+			FixedArity:   3,
+			NAry:         false,
+			Capabilities: []Capability{Terminal},
+			Args:         LC(A("x"), A("y"), A("list")),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 3 {
-					return nil, baseError("screen-write expects 3 arguments")
+					return nil, typedError("arity-error", Nil, "screen-write expects 3 arguments")
 				}
 				x, ok := args[0].(Number)
 				if !ok {
-					return nil, baseErrorf("'%s' is not a number", args[0])
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a number", args[0])
 				}
 				y, ok := args[1].(Number)
 				if !ok {
-					return nil, baseErrorf("'%s' is not a number", args[1])
+					return nil, typedErrorf("type-error", args[1], "'%s' is not a number", args[1])
 				}
 				s, ok := args[2].(*ConsCell)
 				if !ok {
-					return nil, baseErrorf("'%s' is not a list", args[2])
+					return nil, typedErrorf("type-error", args[2], "'%s' is not a list", args[2])
+				}
+				if !x.isInt() || !y.isInt() {
+					return nil, baseError("screen-write expects integer coordinates")
 				}
 				err := termDrawText(int(x.bi.Uint64()), int(y.bi.Uint64()), unwrapList(s))
 				if err != nil {
@@ -1071,32 +1826,76 @@ func init() {
 				return Nil, nil
 			},
 		},
-		"shell": {
-			Name:       "shell",
-			Doc:        DOC("Run a shell subprocess, and return stdout, stderr, and exit code"),
+		"select": {
+			Name: "select",
+			Doc: DOC("Wait until a value can be received from, or sent on, one of " +
+				"several channels, and do that.  Each argument is either a " +
+				"channel to receive from, or a list of a channel and a value " +
+				"to send on it.  Return a list of the channel used and the value " +
+				"received or sent"),
 			FixedArity: 1,
+			NAry:       true,
+			Args:       C(A("case"), A("cases")),
+			Examples: E(
+				LE(A("let"), LC(LC(A("a"), LE(A("chan"), N(1))), LC(A("b"), LE(A("chan"), N(1)))),
+					LE(A("send"), A("b"), N(2)),
+					LE(A("second"), LE(A("select"), A("a"), A("b")))),
+			),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				return selectChannels(args, e)
+			},
+		},
+		"send": {
+			Name:       "send",
+			Doc:        DOC("Send a value on a channel, waiting until it is received unless the channel's buffer has room for it; return ()"),
+			FixedArity: 2,
 			NAry:       false,
-			Args:       LC(A("cmd")),
+			Args:       LC(A("ch"), A("x")),
+			Examples: E(
+				LE(A("send"), LE(A("chan"), N(1)), QA("hello")),
+			),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				if len(args) != 2 {
+					return nil, typedError("arity-error", Nil, "send expects two arguments")
+				}
+				c, err := channelArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if err := c.send(args[1], e); err != nil {
+					return nil, err
+				}
+				return Nil, nil
+			},
+		},
+		"shell": {
+			Name:         "shell",
+			Doc:          DOC("Run a shell subprocess, and return the lines of its stdout and stderr, as strings, and its exit code"),
+			FixedArity:   1,
+			NAry:         false,
+			Capabilities: []Capability{Process},
+			Args:         LC(A("cmd")),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("shell expects a single argument")
+					return nil, typedError("arity-error", Nil, "shell expects a single argument")
 				}
 				return doShell(args[0])
 			},
 		},
 		"shuffle": {
-			Name:       "shuffle",
-			Doc:        DOC("Return a (quickly!) shuffled list"),
-			FixedArity: 1,
-			NAry:       false,
-			Args:       LC(A("xs")),
-			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+			Name:         "shuffle",
+			Doc:          DOC("Return a (quickly!) shuffled list"),
+			FixedArity:   1,
+			NAry:         false,
+			Capabilities: []Capability{Randomness},
+			Args:         LC(A("xs")),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("shuffle expects a single argument")
+					return nil, typedError("arity-error", Nil, "shuffle expects a single argument")
 				}
 				l, ok := args[0].(*ConsCell)
 				if !ok {
-					return nil, baseErrorf("'%s' is not a list", args[0])
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a list", args[0])
 				}
 				exprs, err := consToExprs(l)
 				if err != nil {
@@ -1105,30 +1904,242 @@ func init() {
 				rand.Shuffle(len(exprs), func(i, j int) {
 					exprs[i], exprs[j] = exprs[j], exprs[i]
 				})
+				e.countConses(len(exprs))
 				return mkListAsConsWithCdr(exprs, Nil), nil
 			},
 		},
 		"sleep": {
-			Name:       "sleep",
-			Doc:        DOC("Sleep for the given number of milliseconds"),
+			Name:         "sleep",
+			Doc:          DOC("Sleep for the given number of milliseconds"),
+			FixedArity:   1,
+			NAry:         false,
+			Capabilities: []Capability{Time},
+			Args:         LC(A("ms")),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "sleep expects a single argument")
+				}
+				num, ok := args[0].(Number)
+				if !ok {
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a number", args[0])
+				}
+				timer := time.NewTimer(time.Duration(num.toFloat() * float64(time.Millisecond)))
+				defer timer.Stop()
+				select {
+				case <-timer.C:
+					return Nil, nil
+				case <-e.done():
+					return nil, e.stopped()
+				}
+			},
+		},
+		"spawn": {
+			Name: "spawn",
+			Doc: DOC("Call a function with the given arguments in a new task, " +
+				"running at the same time as the caller; return the task, for " +
+				"`await` or `join`"),
+			FixedArity: 1,
+			NAry:       true,
+			Args:       C(A("f"), A("args")),
+			Examples: E(
+				LE(A("spawn"), A("+"), N(1), N(2)),
+				LE(A("await"), LE(A("spawn"), LE(A("lambda"), LE(), QA("done")))),
+			),
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+				if len(args) < 1 {
+					return nil, typedError("arity-error", Nil, "spawn expects at least one argument")
+				}
+				return spawn(args[0], args[1:], e)
+			},
+		},
+		"str": {
+			Name:       "str",
+			Doc:        DOC("Return a string joining the printed forms of the arguments, strings without quotes"),
+			FixedArity: 0,
+			NAry:       true,
+			Args:       RO("xs"),
+			Examples: E(
+				LE(A("str")),
+				LE(A("str"), S("abc"), S("def")),
+				LE(A("str"), QA("foo"), N(1), QL(N(2), N(3)), S("!")),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				var sb strings.Builder
+				for _, arg := range args {
+					sb.WriteString(display(arg))
+				}
+				return String{sb.String()}, nil
+			},
+		},
+		"string?": {
+			Name:       "string?",
+			Doc:        DOC("Return t if the argument is a string, () otherwise"),
 			FixedArity: 1,
 			NAry:       false,
-			Args:       LC(A("ms")),
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("string?"), S("one")),
+				LE(A("string?"), QA("one")),
+			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("sleep expects a single argument")
+					return nil, typedError("arity-error", Nil, "string? expects a single argument")
 				}
-				num, ok := args[0].(Number)
-				if !ok {
-					return nil, baseErrorf("'%s' is not a number", args[0])
+				if _, ok := args[0].(String); ok {
+					return True, nil
 				}
-				time.Sleep(time.Duration(num.bi.Uint64()) * time.Millisecond)
 				return Nil, nil
 			},
 		},
+		"string->atom": {
+			Name:       "string->atom",
+			Doc:        DOC("Return the atom whose name is the given string"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("s")),
+			Examples: E(
+				LE(A("string->atom"), S("abc")),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "string->atom expects a single argument")
+				}
+				s, ok := args[0].(String)
+				if !ok {
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a string", args[0])
+				}
+				if s.s == "" {
+					return nil, baseError("cannot make an atom from an empty string")
+				}
+				return Atom{s.s}, nil
+			},
+		},
+		"string->number": {
+			Name:       "string->number",
+			Doc:        DOC("Return the number written in the given string"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("s")),
+			Examples: E(
+				LE(A("string->number"), S("123")),
+				LE(A("string->number"), S("-99")),
+				LE(A("string->number"), S("2/3")),
+				LE(A("string->number"), S("1.5e3")),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "string->number expects a single argument")
+				}
+				s, ok := args[0].(String)
+				if !ok {
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a string", args[0])
+				}
+				n, ok := parseNumber(s.s)
+				if !ok {
+					return nil, typedErrorf("type-error", s, "%s is not a number", s)
+				}
+				return n, nil
+			},
+		},
+		"string-index": {
+			Name:       "string-index",
+			Doc:        DOC("Return the position of the first occurrence of sub in s, or () if there is none"),
+			FixedArity: 2,
+			NAry:       false,
+			Args:       LC(A("s"), A("sub")),
+			Examples: E(
+				LE(A("string-index"), S("hello world"), S("o")),
+				LE(A("string-index"), S("hello world"), S("z")),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 2 {
+					return nil, typedError("arity-error", Nil, "string-index expects two arguments")
+				}
+				s, ok := args[0].(String)
+				if !ok {
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a string", args[0])
+				}
+				sub, ok := args[1].(String)
+				if !ok {
+					return nil, typedErrorf("type-error", args[1], "'%s' is not a string", args[1])
+				}
+				i := strings.Index(s.s, sub.s)
+				if i < 0 {
+					return Nil, nil
+				}
+				return Num(utf8.RuneCountInString(s.s[:i])), nil
+			},
+		},
+		"subvec": {
+			Name:       "subvec",
+			Doc:        DOC("Return a new vector of the elements of v from start up to (but not including) end, or to the end of v"),
+			FixedArity: 2,
+			NAry:       true,
+			Args:       C(A("v"), C(A("start"), A("end"))),
+			Examples: E(
+				LE(A("subvec"), V(N(0), N(1), N(2), N(3)), N(1)),
+				LE(A("subvec"), V(N(0), N(1), N(2), N(3)), N(1), N(3)),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 2 && len(args) != 3 {
+					return nil, typedError("arity-error", Nil, "subvec expects two or three arguments")
+				}
+				v, err := vectorArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				bounds := []int{0, len(v.items)}
+				for i, arg := range args[1:] {
+					bounds[i], err = vectorIndex(v, arg, true)
+					if err != nil {
+						return nil, err
+					}
+				}
+				if bounds[0] > bounds[1] {
+					return nil, baseErrorf("start %d is after end %d", bounds[0], bounds[1])
+				}
+				return &Vector{append([]Sexpr{}, v.items[bounds[0]:bounds[1]]...)}, nil
+			},
+		},
+		"substring": {
+			Name:       "substring",
+			Doc:        DOC("Return the characters of s from start up to (but not including) end, or to the end of s"),
+			FixedArity: 2,
+			NAry:       true,
+			Args:       C(A("s"), C(A("start"), A("end"))),
+			Examples: E(
+				LE(A("substring"), S("hello world"), N(6)),
+				LE(A("substring"), S("hello world"), N(0), N(5)),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 2 && len(args) != 3 {
+					return nil, typedError("arity-error", Nil, "substring expects two or three arguments")
+				}
+				s, ok := args[0].(String)
+				if !ok {
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a string", args[0])
+				}
+				runes := []rune(s.s)
+				bounds := []int{0, len(runes)}
+				for i, arg := range args[1:] {
+					n, ok := arg.(Number)
+					if !ok {
+						return nil, typedErrorf("type-error", arg, "'%s' is not a number", arg)
+					}
+					if !n.isInt() || !n.bi.IsInt64() || n.bi.Int64() < 0 || n.bi.Int64() > int64(len(runes)) {
+						return nil, typedErrorf("index-error", n, "index %s out of range for %s", n, s)
+					}
+					bounds[i] = int(n.bi.Int64())
+				}
+				if bounds[0] > bounds[1] {
+					return nil, baseErrorf("start %d is after end %d", bounds[0], bounds[1])
+				}
+				return String{string(runes[bounds[0]:bounds[1]])}, nil
+			},
+		},
 		"sort": {
 			Name:       "sort",
-			Doc:        DOC("Sort a list"),
+			Doc:        DOC("Sort a list, or return a sorted copy of a vector"),
 			FixedArity: 1,
 			NAry:       false,
 			Args:       LC(A("xs")),
@@ -1136,21 +2147,28 @@ func init() {
 				LE(A("sort"), QL(N(3), N(2), N(1))),
 				LE(A("sort"), QL()),
 				LE(A("sort"), QL(A("c"), A("b"), A("a"))),
+				LE(A("sort"), V(N(2), N(3), N(1))),
 			),
-			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("sort expects a single argument")
+					return nil, typedError("arity-error", Nil, "sort expects a single argument")
 				}
-				l, ok := args[0].(*ConsCell)
-				if !ok {
-					return nil, baseErrorf("'%s' is not a list", args[0])
-				}
-				exprs, err := consToExprs(l)
-				if err != nil {
-					return nil, extendError("sort consToExprs", err)
+				var exprs []Sexpr
+				switch t := args[0].(type) {
+				case *Vector:
+					exprs = append([]Sexpr{}, t.items...)
+				case *ConsCell:
+					var err error
+					exprs, err = consToExprs(t)
+					if err != nil {
+						return nil, extendError("sort consToExprs", err)
+					}
+				default:
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a list", args[0])
 				}
+				_, isVector := args[0].(*Vector)
 				if len(exprs) == 0 {
-					return Nil, nil
+					return args[0], nil
 				}
 				for i := 1; i < len(exprs); i++ {
 					if reflect.TypeOf(exprs[i]) != reflect.TypeOf(exprs[0]) {
@@ -1166,9 +2184,17 @@ func init() {
 					sort.Slice(exprs, func(i, j int) bool {
 						return exprs[i].(Atom).s < exprs[j].(Atom).s
 					})
+				case String:
+					sort.Slice(exprs, func(i, j int) bool {
+						return exprs[i].(String).s < exprs[j].(String).s
+					})
 				default:
-					return nil, baseErrorf("'%s' is not a sortable type", exprs[0])
+					return nil, typedErrorf("type-error", exprs[0], "'%s' is not a sortable type", exprs[0])
 				}
+				if isVector {
+					return &Vector{exprs}, nil
+				}
+				e.countConses(len(exprs))
 				return mkListAsConsWithCdr(exprs, Nil), nil
 			},
 		},
@@ -1185,11 +2211,11 @@ func init() {
 			),
 			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
 				if len(args) != 2 {
-					return nil, baseError("sort-by expects two arguments")
+					return nil, typedError("arity-error", Nil, "sort-by expects two arguments")
 				}
 				l, ok := args[1].(*ConsCell)
 				if !ok {
-					return nil, baseErrorf("'%s' is not a list", args[1])
+					return nil, typedErrorf("type-error", args[1], "'%s' is not a list", args[1])
 				}
 				exprs, err := consToExprs(l)
 				if err != nil {
@@ -1220,11 +2246,14 @@ func init() {
 						return apply1.(Number).Less(apply2.(Number))
 					case Atom:
 						return apply1.(Atom).s < apply2.(Atom).s
+					case String:
+						return apply1.(String).s < apply2.(String).s
 					default:
-						sortHadErr = baseErrorf("'%s' is not a sortable type", apply1)
+						sortHadErr = typedErrorf("type-error", apply1, "'%s' is not a sortable type", apply1)
 					}
 					return false
 				})
+				e.countConses(len(exprs))
 				return mkListAsConsWithCdr(exprs, Nil), sortHadErr
 			},
 		},
@@ -1240,7 +2269,7 @@ func init() {
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("source expects a single argument")
+					return nil, typedError("arity-error", Nil, "source expects a single argument")
 				}
 				switch t := args[0].(type) {
 				case *Builtin:
@@ -1251,52 +2280,202 @@ func init() {
 					}
 					return Cons(A("lambda"), Cons(combineArgs(t.args, A(t.restArg)), t.body)), nil
 				default:
-					return nil, baseErrorf("'%s' is not a function", args[0])
+					return nil, typedErrorf("type-error", args[0], "'%s' is not a function", args[0])
 				}
 			},
 		},
 		"split": {
 			Name:       "split",
-			Doc:        DOC("Split an atom or number into a list of single-digit numbers or single-character atoms"),
+			Doc:        DOC("Split an atom, string or number into a list of single-digit numbers, single-character atoms or single-character strings"),
 			FixedArity: 1,
 			NAry:       false,
 			Args:       LC(A("x")),
 			Examples: E(
 				LE(A("split"), N(123)),
 				LE(A("split"), QA("abc")),
+				LE(A("split"), S("abc")),
 			),
-			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+			Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("split expects a single argument")
+					return nil, typedError("arity-error", Nil, "split expects a single argument")
 				}
 				switch s := args[0].(type) {
 				case Atom:
+					e.countConses(utf8.RuneCountInString(s.String()))
 					return listOfChars(s.String()), nil
+				case String:
+					chars := []Sexpr{}
+					for _, r := range s.s {
+						chars = append(chars, String{string(r)})
+					}
+					e.countConses(len(chars))
+					return mkListAsConsWithCdr(chars, Nil), nil
 				case Number:
+					if !s.isInt() {
+						return nil, baseErrorf("cannot split non-integer %s", s)
+					}
+					e.countConses(len(strings.TrimPrefix(s.String(), "-")))
 					return listOfNums(s.String())
 				default:
-					return nil, baseError("split expects an atom or a number")
+					return nil, baseError("split expects an atom, a string or a number")
 				}
 			},
 		},
 		"upcase": {
 			Name:       "upcase",
-			Doc:        DOC("Return the uppercase version of the given atom"),
+			Doc:        DOC("Return the uppercase version of the given atom or string"),
 			FixedArity: 1,
 			NAry:       false,
 			Args:       LC(A("x")),
 			Examples: E(
 				LE(A("upcase"), QA("abc")),
+				LE(A("upcase"), S("abc")),
 			),
 			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
 				if len(args) != 1 {
-					return nil, baseError("upcase expects a single argument")
+					return nil, typedError("arity-error", Nil, "upcase expects a single argument")
 				}
-				a, ok := args[0].(Atom)
-				if !ok {
-					return nil, baseError("upcase expects an atom")
+				switch t := args[0].(type) {
+				case Atom:
+					return Atom{strings.ToUpper(t.s)}, nil
+				case String:
+					return String{strings.ToUpper(t.s)}, nil
+				default:
+					return nil, baseError("upcase expects an atom or a string")
 				}
-				return Atom{strings.ToUpper(a.s)}, nil
+			},
+		},
+		"vec": {
+			Name:       "vec",
+			Doc:        DOC("Return a new vector with the elements of a list or vector"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("xs")),
+			Examples: E(
+				LE(A("vec"), LE(A("range"), N(5))),
+				LE(A("vec"), LE()),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "vec expects a single argument")
+				}
+				items, err := seqItems(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return &Vector{append([]Sexpr{}, items...)}, nil
+			},
+		},
+		"vector?": {
+			Name:       "vector?",
+			Doc:        DOC("Return t if the argument is a vector, () otherwise"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("x")),
+			Examples: E(
+				LE(A("vector?"), V(N(1), N(2))),
+				LE(A("vector?"), QL(N(1), N(2))),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "vector? expects a single argument")
+				}
+				if _, ok := args[0].(*Vector); ok {
+					return True, nil
+				}
+				return Nil, nil
+			},
+		},
+		"vget": {
+			Name:       "vget",
+			Doc:        DOC("Return the element of a vector at an index, starting from zero"),
+			FixedArity: 2,
+			NAry:       false,
+			Args:       LC(A("v"), A("i")),
+			Examples: E(
+				LE(A("vget"), V(QA("a"), QA("b"), QA("c")), N(1)),
+				LE(A("vget"), V(QA("a")), N(1)),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 2 {
+					return nil, typedError("arity-error", Nil, "vget expects two arguments")
+				}
+				v, err := vectorArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				i, err := vectorIndex(v, args[1], false)
+				if err != nil {
+					return nil, err
+				}
+				return v.items[i], nil
+			},
+		},
+		"vlen": {
+			Name:       "vlen",
+			Doc:        DOC("Return the number of elements in a vector"),
+			FixedArity: 1,
+			NAry:       false,
+			Args:       LC(A("v")),
+			Examples: E(
+				LE(A("vlen"), V(N(1), N(2), N(3))),
+				LE(A("vlen"), V()),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 1 {
+					return nil, typedError("arity-error", Nil, "vlen expects a single argument")
+				}
+				v, err := vectorArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return Num(len(v.items)), nil
+			},
+		},
+		"vpush!": {
+			Name:       "vpush!",
+			Doc:        DOC("Add a value to the end of a vector, changing it; return the vector"),
+			FixedArity: 2,
+			NAry:       false,
+			Args:       LC(A("v"), A("x")),
+			Examples: E(
+				LE(A("vpush!"), V(N(1), N(2)), N(3)),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 2 {
+					return nil, typedError("arity-error", Nil, "vpush! expects two arguments")
+				}
+				v, err := vectorArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				v.items = append(v.items, args[1])
+				return v, nil
+			},
+		},
+		"vset!": {
+			Name:       "vset!",
+			Doc:        DOC("Replace the element of a vector at an index, changing it; return the vector"),
+			FixedArity: 3,
+			NAry:       false,
+			Args:       LC(A("v"), A("i"), A("x")),
+			Examples: E(
+				LE(A("vset!"), V(N(1), N(2), N(3)), N(0), QA("one")),
+			),
+			Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+				if len(args) != 3 {
+					return nil, typedError("arity-error", Nil, "vset! expects three arguments")
+				}
+				v, err := vectorArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				i, err := vectorIndex(v, args[1], false)
+				if err != nil {
+					return nil, err
+				}
+				v.items[i] = args[2]
+				return v, nil
 			},
 		},
 		"version": {
@@ -1314,6 +2493,14 @@ func init() {
 			},
 		},
 	}
+	for name, b := range builtins {
+		b.Fn = guarded(b, b.Fn)
+		id := intern(name)
+		for int(id) >= len(builtinVals) {
+			builtinVals = append(builtinVals, nil)
+		}
+		builtinVals[id] = &globalVal{b}
+	}
 }
 
 // listOfChars returns a list of single-character atoms from another, presumably
@@ -0,0 +1,74 @@
+package lisp
+
+// Capability is a kind of access to the host outside the interpreter which a
+// builtin needs, such as to the filesystem.  An interpreter's Policy may
+// deny builtins the use of a capability; builtins which need none, like
+// `print` with the interpreter's own streams, are always allowed.
+type Capability string
+
+const (
+	Filesystem Capability = "filesystem" // reading files, as by `load`
+	Process    Capability = "process"    // running programs, or exiting
+	Terminal   Capability = "terminal"   // taking over the terminal, as by `screen-start`
+	Time       Capability = "time"       // waiting, as by `sleep`
+	Randomness Capability = "randomness" // random numbers
+)
+
+// AllCapabilities lists the capabilities builtins may need.
+var AllCapabilities = []Capability{Filesystem, Process, Terminal, Time, Randomness}
+
+// Policy says which capabilities builtins may use.  The zero Policy allows
+// them all.
+type Policy struct {
+	Deny []Capability // the capabilities builtins may not use
+}
+
+// StrictPolicy denies all capabilities, so code can't reach the host
+// except through the interpreter's streams.
+var StrictPolicy = Policy{Deny: AllCapabilities}
+
+// WithPolicy sets the policy for the builtins the interpreter runs.
+func WithPolicy(p Policy) Option {
+	return func(i *Interpreter) {
+		i.policy = p
+	}
+}
+
+// denies returns true if the policy denies the capability c.
+func (p Policy) denies(c Capability) bool {
+	for _, d := range p.Deny {
+		if d == c {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCapabilities returns an error if the policy for evaluation in e
+// denies any of the capabilities b needs.
+func (e *Env) checkCapabilities(b *Builtin) error {
+	if e.interp == nil {
+		return nil
+	}
+	for _, c := range b.Capabilities {
+		if e.interp.policy.denies(c) {
+			return typedErrorf("capability-denied", Atom{string(c)},
+				"%s needs the %s capability, which is denied", b.Name, c)
+		}
+	}
+	return nil
+}
+
+// guarded returns b's function, checking first, if b needs any
+// capabilities, that they aren't denied.
+func guarded(b *Builtin, fn func([]Sexpr, *Env) (Sexpr, error)) func([]Sexpr, *Env) (Sexpr, error) {
+	if len(b.Capabilities) == 0 {
+		return fn
+	}
+	return func(args []Sexpr, e *Env) (Sexpr, error) {
+		if err := e.checkCapabilities(b); err != nil {
+			return nil, err
+		}
+		return fn(args, e)
+	}
+}
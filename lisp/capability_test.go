@@ -0,0 +1,82 @@
+package lisp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStrictPolicy(t *testing.T) {
+	interp, err := NewInterpreter(WithPolicy(StrictPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{
+		"(shell '(ls))",
+		"(exit)",
+		"(load 'tests.l1)",
+		"(sleep 1)",
+		"(randint 10)",
+		"(shuffle '(1 2 3))",
+		"(screen-start)",
+		"(apply shell '((ls)))",
+		"(randchoice '(1 2 3))",
+	} {
+		_, err := interp.Eval(src)
+		c, ok := err.(*Condition)
+		if !ok || c.Type().s != "capability-denied" {
+			t.Errorf("%s: got %v, want capability-denied", src, err)
+		}
+	}
+	got, err := interp.Eval("(try (shell '(ls)) (catch (capability-denied e) (condition-data e)))")
+	if err != nil || got.String() != "process" {
+		t.Errorf("got %v, %v; want process", got, err)
+	}
+	if got, err := interp.Eval("(map inc (range 3))"); err != nil || got.String() != "(1 2 3)" {
+		t.Errorf("got %v, %v", got, err)
+	}
+}
+
+func TestPolicyDeniesOnlyWhatItLists(t *testing.T) {
+	interp, err := NewInterpreter(WithPolicy(Policy{Deny: []Capability{Time}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := interp.Eval("(randint 10)"); err != nil {
+		t.Errorf("randint: %v", err)
+	}
+	_, err = interp.Eval("(sleep 1)")
+	if err == nil || !strings.Contains(err.Error(), "sleep needs the time capability") {
+		t.Errorf("sleep: got %v", err)
+	}
+	// Other interpreters are unaffected:
+	other, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Eval("(sleep 1)"); err != nil {
+		t.Errorf("sleep: %v", err)
+	}
+}
+
+func TestPolicyForRegisteredBuiltins(t *testing.T) {
+	interp, err := NewInterpreter(WithPolicy(StrictPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	err = interp.RegisterBuiltin(&Builtin{
+		Name:         "read-secret",
+		Capabilities: []Capability{Filesystem},
+		Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+			called = true
+			return Nil, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = interp.Eval("(read-secret)")
+	if c, ok := err.(*Condition); !ok || c.Type().s != "capability-denied" || called {
+		t.Errorf("got %v (called: %v), want capability-denied", err, called)
+	}
+}
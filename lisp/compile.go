@@ -0,0 +1,923 @@
+package lisp
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The compiler turns forms, after macroexpansion, into bytecode for the VM
+// in vm.go.  A form is compiled when it is evaluated at the top level (or
+// with `eval`); the body of a lambda function is compiled the first time the
+// function is called, so that macros defined after the function was still
+// expand in it.
+//
+// Compiled code behaves as the tree-walker (walk, in lisp.go) does, down to
+// the error traces it produces.  The walker adds frames to an error as it
+// returns through nested calls to eval; the compiler records that nesting as
+// a tree of nodes, and for each instruction the node it was compiled in, so
+// that the VM can add the same frames to an error raised by the instruction.
+
+type opcode uint8
+
+const (
+	opConst         opcode = iota // push constant arg
+	opVar                         // push the value of the variable refs[arg]
+	opCxr                         // push a c[ad]+r function (template in constant arg)
+	opCallee                      // push the function for call site arg
+	opCall                        // call the function for call site arg
+	opTailCall                    // call the function for call site arg, in place of the current one
+	opReturn                      // return the top of the stack
+	opPop                         // discard arg values
+	opJump                        // jump to arg
+	opJumpIfNil                   // pop a value, jumping to arg if it's ()
+	opJumpUnlessNil               // jump to arg if the top value isn't (), else pop it
+	opLet                         // bind the names in lets[arg] to popped values, in a new environment
+	opPopEnv                      // return to the environment enclosing the current one
+	opLambda                      // push a lambda function made from lambdas[arg]
+	opDefn                        // define the function made from lambdas[arg]
+	opDef                         // define the symbol in constant arg as the top value
+	opSet                         // update the variable refs[arg] to the top value
+	opError                       // raise an error with the popped value as its message
+	opRaise                       // raise a condition made from arg values
+	opRethrow                     // raise the popped condition
+	opHash                        // make a hash map from arg popped keys and values
+	opVector                      // make a vector from arg popped values
+	opExpand                      // expand the macro call at call site arg, then evaluate it
+	opFail                        // raise a copy of fails[arg]
+	opNative                      // push the value of natives[arg]
+)
+
+// instr is an instruction: an opcode in the low byte, with an argument
+// (usually an index into one of the tables in code) in the rest.
+type instr uint32
+
+func (in instr) op() opcode { return opcode(in & 0xff) }
+
+func (in instr) arg() int { return int(in >> 8) }
+
+type nodeKind uint8
+
+const (
+	evalNode  nodeKind = iota // a form evaluated with a call to eval
+	tailNode                  // a form evaluated in place of its parent
+	frameNode                 // a frame added to errors raised below it
+)
+
+// node is a point in the nesting of evaluations in compiled code.
+type node struct {
+	kind   nodeKind
+	parent int32 // -1 for the root
+	form   Sexpr
+	frame  *ConsCell
+}
+
+// varRef is a variable referred to in compiled code.
+type varRef struct {
+	name Atom
+	sym  symbol
+	// How many environments out from the one the code runs in the variable
+	// is bound, and its place there; depth is -1 for global variables:
+	depth, slot int
+}
+
+// callSite is a function call in compiled code.
+type callSite struct {
+	form  *ConsCell // the call as written, for expanding it as a macro
+	ref   int       // the function's variable, if it's named by one
+	argc  int
+	tail  bool
+	after int // where execution continues after the call
+}
+
+// lambdaSite is a lambda, defn or defmacro form in compiled code.
+type lambdaSite struct {
+	args    *ConsCell // as passed to mkLambda
+	name    string    // for defn and defmacro
+	isMacro bool
+	proto   *lambdaProto
+}
+
+// letSite is a let form in compiled code.
+type letSite struct {
+	names []symbol // the names bound; those past the first n are bound later
+	n     int
+	// The names, or patterns, bound to each value, if any is a pattern:
+	patterns []Sexpr
+}
+
+// code is a compiled form, or the compiled body of a lambda function.
+type code struct {
+	ops     []instr
+	nodes   []int32 // the node each op was compiled in
+	tree    []node
+	consts  []Sexpr
+	refs    []varRef
+	calls   []callSite
+	lambdas []lambdaSite
+	lets    []letSite
+	fails   []error
+	natives []seqFn
+	// For the body of a lambda function, the names in the environment of
+	// a call, as the code expects them: the function's parameters, then
+	// the names of any lambdas it makes with names of their own:
+	names []symbol
+}
+
+// lambdaProto holds the code compiled for the body of a lambda function.
+// Tasks may call the function at once, so the code is compiled with the
+// lock held, and read without it.
+type lambdaProto struct {
+	mu   sync.Mutex
+	body atomic.Pointer[code] // for calls from compiled code, with the last form in tail position
+	seq  atomic.Pointer[code] // for calls from Go, as by apply, with no tail position
+}
+
+// bodyCode returns the code for f's body, compiling it if need be for a call
+// in e, the environment just made for the call.
+func (f *lambdaFn) bodyCode(e *Env) *code {
+	c := f.proto.body.Load()
+	if c == nil {
+		f.proto.mu.Lock()
+		if c = f.proto.body.Load(); c == nil {
+			c = compileBody(f.body, e)
+			c.names = e.names
+			f.proto.body.Store(c)
+		}
+		f.proto.mu.Unlock()
+	}
+	c.adoptNames(e)
+	return c
+}
+
+// seqCode is as bodyCode, for the code evalBody runs.
+func (f *lambdaFn) seqCode(e *Env) (*code, error) {
+	c := f.proto.seq.Load()
+	if c == nil {
+		forms, err := consToExprs(f.body)
+		if err != nil {
+			return nil, baseError("lambda body must be a list")
+		}
+		f.proto.mu.Lock()
+		if c = f.proto.seq.Load(); c == nil {
+			c = compileSeq(forms, e, envScope(e))
+			c.names = e.names
+			f.proto.seq.Store(c)
+		}
+		f.proto.mu.Unlock()
+	}
+	c.adoptNames(e)
+	return c, nil
+}
+
+// adoptNames gives e, made for a call of the function c was compiled for,
+// the names c expects, if it was made before they were all known.
+func (c *code) adoptNames(e *Env) {
+	if len(e.names) < len(c.names) {
+		e.names = c.names
+	}
+}
+
+// scope is the compiler's picture of an environment the code will run in:
+// the names bound there, in the order of their values.  A scope stands
+// either for an environment which exists already, or for those which will
+// be made for a let or catch form each time the code runs.
+type scope struct {
+	names  []symbol
+	parent *scope
+	env    *Env // the environment, if it exists
+}
+
+// envScope returns the scope for e and its parents.  The top-level
+// environment has none, since variables not bound in a scope are global.
+func envScope(e *Env) *scope {
+	if e == nil || e.parent == nil {
+		return nil
+	}
+	return &scope{names: e.names, parent: envScope(e.parent), env: e}
+}
+
+// declare adds name to those bound in s, unless it's there already.
+func (s *scope) declare(name symbol) {
+	for _, n := range s.names {
+		if n == name {
+			return
+		}
+	}
+	n := len(s.names)
+	s.names = append(s.names[:n:n], name)
+	if s.env != nil {
+		s.env.names = s.names
+	}
+}
+
+type compiler struct {
+	c     *code
+	env   *Env   // where macros are looked up and expanded
+	node  int32  // the node being compiled
+	scope *scope // where the code being compiled will run
+}
+
+func newCompiler(e *Env, s *scope) *compiler {
+	return &compiler{c: &code{}, env: e, node: -1, scope: s}
+}
+
+// compile compiles expr for evaluation in e.
+func compile(expr Sexpr, e *Env) *code {
+	cp := newCompiler(e, envScope(e))
+	cp.tail(expr, true)
+	return cp.c
+}
+
+// compileBody compiles the body of a lambda function, to be run in e.
+func compileBody(body *ConsCell, e *Env) *code {
+	cp := newCompiler(e, envScope(e))
+	if body == Nil {
+		cp.literal(Nil, true)
+		return cp.c
+	}
+	for {
+		next, ok := body.cdr.(*ConsCell)
+		if !ok || next == Nil {
+			cp.tail(body.car, true)
+			return cp.c
+		}
+		cp.sub(cp.frame(lambdaFrame(body.car)), body.car)
+		cp.emit(opPop, 1)
+		body = next
+	}
+}
+
+// compileSeq compiles forms to be evaluated in turn, each as if by eval, in
+// an environment pictured by s.
+func compileSeq(forms []Sexpr, e *Env, s *scope) *code {
+	cp := newCompiler(e, s)
+	if len(forms) == 0 {
+		cp.literal(Nil, true)
+		return cp.c
+	}
+	for i, form := range forms {
+		cp.eval(form)
+		if i < len(forms)-1 {
+			cp.emit(opPop, 1)
+		}
+	}
+	cp.emit(opReturn, 0)
+	return cp.c
+}
+
+// compileExpansion compiles the expansion of a macro call which is
+// evaluated in place of the call.
+func compileExpansion(expansion Sexpr, e *Env) *code {
+	cp := newCompiler(e, envScope(e))
+	cp.form(expansion, true)
+	return cp.c
+}
+
+func (cp *compiler) emit(op opcode, arg int) int {
+	cp.c.ops = append(cp.c.ops, instr(arg)<<8|instr(op))
+	cp.c.nodes = append(cp.c.nodes, cp.node)
+	return len(cp.c.ops) - 1
+}
+
+// patch sets the argument of the instruction at pc.
+func (cp *compiler) patch(pc, arg int) {
+	cp.c.ops[pc] = instr(arg)<<8 | instr(cp.c.ops[pc].op())
+}
+
+// here returns the pc of the next instruction.
+func (cp *compiler) here() int {
+	return len(cp.c.ops)
+}
+
+func (cp *compiler) constant(x Sexpr) int {
+	cp.c.consts = append(cp.c.consts, x)
+	return len(cp.c.consts) - 1
+}
+
+// literal pushes x, returning it if the code is in tail position.
+func (cp *compiler) literal(x Sexpr, tail bool) {
+	cp.emit(opConst, cp.constant(x))
+	cp.ret(tail)
+}
+
+func (cp *compiler) ret(tail bool) {
+	if tail {
+		cp.emit(opReturn, 0)
+	}
+}
+
+func (cp *compiler) fail(err error) {
+	cp.c.fails = append(cp.c.fails, err)
+	cp.emit(opFail, len(cp.c.fails)-1)
+}
+
+func (cp *compiler) native(f seqFn, tail bool) {
+	cp.c.natives = append(cp.c.natives, f)
+	cp.emit(opNative, len(cp.c.natives)-1)
+	cp.ret(tail)
+}
+
+// enter adds a node below the current one and makes it current, returning
+// the previous one.
+func (cp *compiler) enter(kind nodeKind, form Sexpr, frame *ConsCell) int32 {
+	prev := cp.node
+	cp.c.tree = append(cp.c.tree, node{kind, prev, form, frame})
+	cp.node = int32(len(cp.c.tree) - 1)
+	return prev
+}
+
+// frame adds a frame node below the current one, returning it.
+func (cp *compiler) frame(frame *ConsCell) int32 {
+	prev := cp.enter(frameNode, nil, frame)
+	ret := cp.node
+	cp.node = prev
+	return ret
+}
+
+func (cp *compiler) frameMsg(msg string) int32 {
+	return cp.frame(stringsToList(strings.Split(msg, " ")...))
+}
+
+// eval compiles form as if evaluated by a call to eval, pushing its value.
+func (cp *compiler) eval(form Sexpr) {
+	prev := cp.enter(evalNode, form, nil)
+	cp.form(form, false)
+	cp.node = prev
+}
+
+// sub compiles form as if evaluated by a call to eval, with errors getting the
+// given frame.
+func (cp *compiler) sub(frame int32, form Sexpr) {
+	prev := cp.node
+	cp.node = frame
+	cp.eval(form)
+	cp.node = prev
+}
+
+// tail compiles form as evaluated in place of the current one, as the
+// walker does for forms in tail position.  The code returns the value of
+// the form if tail is true.
+func (cp *compiler) tail(form Sexpr, tail bool) {
+	prev := cp.enter(tailNode, form, nil)
+	cp.form(form, tail)
+	cp.node = prev
+}
+
+// resolve returns the scope in which name is bound, if any, how many scopes
+// out from the current one that is, and the name's place in it.
+func (cp *compiler) resolve(name symbol) (s *scope, depth, slot int) {
+	for s = cp.scope; s != nil; s = s.parent {
+		for i := len(s.names) - 1; i >= 0; i-- {
+			if s.names[i] == name {
+				return s, depth, i
+			}
+		}
+		depth++
+	}
+	return nil, -1, -1
+}
+
+// ref adds a reference to the variable a, returning its index in refs.
+func (cp *compiler) ref(a Atom) int {
+	sym := intern(a.s)
+	_, depth, slot := cp.resolve(sym)
+	cp.c.refs = append(cp.c.refs, varRef{a, sym, depth, slot})
+	return len(cp.c.refs) - 1
+}
+
+// isMacroCall is as the function of the same name, except that it knows about
+// names bound in the code being compiled.
+func (cp *compiler) isMacroCall(x Sexpr) bool {
+	if c, ok := x.(*ConsCell); ok && c != Nil {
+		if a, ok := c.car.(Atom); ok {
+			if s, _, _ := cp.resolve(intern(a.s)); s != nil && s.env == nil {
+				return false
+			}
+		}
+	}
+	return isMacroCall(x, cp.env)
+}
+
+// declareName notes the name of a named lambda made by a lambda or defn form
+// with the given arguments, since mkLambda binds it where the lambda is made.
+func (cp *compiler) declareName(args *ConsCell) {
+	if cp.scope == nil || args == Nil {
+		return
+	}
+	if name, ok := args.car.(Atom); ok {
+		cp.scope.declare(intern(name.s))
+	}
+}
+
+func (cp *compiler) site(form *ConsCell, tail bool) int {
+	cp.c.calls = append(cp.c.calls, callSite{form: form, tail: tail})
+	return len(cp.c.calls) - 1
+}
+
+// form compiles x, leaving its value on the stack, or returning it if tail
+// is true.
+func (cp *compiler) form(x Sexpr, tail bool) {
+	if cp.isMacroCall(x) {
+		expansion, err := macroexpand(x, cp.env)
+		if err != nil {
+			// Try again when the call is evaluated, to raise the error
+			// then (if at all):
+			site := cp.site(x.(*ConsCell), tail)
+			cp.emit(opExpand, site)
+			cp.c.calls[site].after = cp.here()
+			return
+		}
+		x = expansion
+	}
+	switch t := x.(type) {
+	case Atom:
+		switch {
+		case t == True || isKeyword(t):
+			cp.literal(t, tail)
+		case isCxr(t):
+			f, _ := extractCxrLambda(t, nil)
+			cp.emit(opCxr, cp.constant(f))
+			cp.ret(tail)
+		default:
+			cp.emit(opVar, cp.ref(t))
+			cp.ret(tail)
+		}
+	case Number, String:
+		cp.literal(t, tail)
+	case *HashMap:
+		for _, entry := range t.entries() {
+			cp.eval(entry.key)
+			cp.eval(entry.val)
+		}
+		cp.emit(opHash, t.Len())
+		cp.ret(tail)
+	case *Vector:
+		for _, item := range t.items {
+			cp.eval(item)
+		}
+		cp.emit(opVector, len(t.items))
+		cp.ret(tail)
+	case *ConsCell:
+		if t == Nil {
+			cp.literal(Nil, tail)
+			return
+		}
+		args, ok := t.cdr.(*ConsCell)
+		if !ok {
+			cp.fail(baseError("malformed list for eval"))
+			return
+		}
+		if car, ok := t.car.(Atom); ok && cp.special(car.s, args, tail) {
+			return
+		}
+		cp.call(t, args, tail)
+	default:
+		cp.fail(baseErrorf("unknown expression type: %q", t))
+	}
+}
+
+// special compiles the special form with the given name and arguments,
+// returning false if there's no such special form.
+func (cp *compiler) special(name string, args *ConsCell, tail bool) bool {
+	switch name {
+	case "quote":
+		if args == Nil {
+			cp.fail(baseError("quote needs an argument"))
+			return true
+		}
+		cp.literal(args.car, tail)
+	case "syntax-quote":
+		if args == Nil {
+			cp.fail(baseError("syntax-quote needs an argument"))
+			return true
+		}
+		cp.tail(syntaxQuote(args.car, cp.env), tail)
+	case "test":
+		tf, err := parseTest(args, cp.seq)
+		if err != nil {
+			cp.fail(extendError("test", err))
+			return true
+		}
+		cp.native(tf.eval, tail)
+	case "cond":
+		cp.cond(args, tail)
+	case "and":
+		cp.and(args, tail)
+	case "or":
+		cp.or(args, tail)
+	case "loop":
+		cp.loop(args)
+	case "swallow":
+		forms, err := consToExprs(args)
+		if err != nil {
+			cp.fail(baseError("swallow requires a list of expressions"))
+			return true
+		}
+		body := cp.seq(forms)
+		cp.native(func(e *Env) (Sexpr, error) {
+			if _, err := body(e); isEscape(err) {
+				return nil, err
+			} else if err != nil {
+				return True, nil
+			}
+			return Nil, nil
+		}, tail)
+	case "def":
+		cp.def(args, tail)
+	case "set!":
+		cp.set(args, tail)
+	case "defn":
+		cp.defn(args, false, tail)
+	case "defmacro":
+		cp.defn(args, true, tail)
+	case "error":
+		if args == Nil {
+			cp.fail(baseError("error requires a non-empty argument list"))
+			return true
+		}
+		cp.sub(cp.frameMsg("error operator"), args.car)
+		cp.emit(opError, 0)
+	case "errors":
+		ef, err := parseErrors(args, cp.seq)
+		if err != nil {
+			cp.fail(err)
+			return true
+		}
+		cp.native(ef.eval, tail)
+	case "raise":
+		cp.raise(args)
+	case "rethrow":
+		if args == Nil || args.cdr != Nil {
+			cp.fail(typedError("arity-error", Nil, "rethrow expects a single argument"))
+			return true
+		}
+		cp.sub(cp.frameMsg("evaluating rethrow argument"), args.car)
+		cp.emit(opRethrow, 0)
+	case "try":
+		tf, err := parseTry(args, cp.seq)
+		if err != nil {
+			cp.fail(err)
+			return true
+		}
+		cp.native(tf.eval, tail)
+	case "module":
+		cp.native(func(e *Env) (Sexpr, error) { return evModule(args, e) }, tail)
+	case "export":
+		cp.native(func(e *Env) (Sexpr, error) { return evExport(args, e) }, tail)
+	case "import":
+		cp.native(func(e *Env) (Sexpr, error) { return evImport(args, e) }, tail)
+	case "let":
+		cp.let(args, tail)
+	case "lambda":
+		cp.declareName(args)
+		cp.c.lambdas = append(cp.c.lambdas, lambdaSite{args: args, proto: &lambdaProto{}})
+		cp.emit(opLambda, len(cp.c.lambdas)-1)
+		cp.ret(tail)
+	default:
+		return false
+	}
+	return true
+}
+
+// seq is the compiler's seqMaker; the forms are compiled in the scope of the
+// form being compiled, or in a new one for the locals, if any.
+func (cp *compiler) seq(forms []Sexpr, locals ...string) seqFn {
+	if len(locals) == 0 {
+		c := compileSeq(forms, cp.env, cp.scope)
+		return func(e *Env) (Sexpr, error) {
+			return runCode(c, e)
+		}
+	}
+	s := &scope{parent: cp.scope}
+	for _, name := range locals {
+		s.names = append(s.names, intern(name))
+	}
+	c := compileSeq(forms, cp.env, s)
+	return func(e *Env) (Sexpr, error) {
+		// e was made with the locals bound, in order; it should also
+		// have any names declared in the code:
+		e.names = s.names
+		return runCode(c, e)
+	}
+}
+
+func (cp *compiler) call(form, args *ConsCell, tail bool) {
+	site := cp.site(form, tail)
+	fn := cp.frameMsg("evaluating function object")
+	if a, ok := form.car.(Atom); ok && a != True && !isCxr(a) {
+		// Look the function up in a way which notices if it's actually
+		// a macro, which may have been defined since this was compiled:
+		cp.c.calls[site].ref = cp.ref(a)
+		prev := cp.node
+		cp.node = fn
+		cp.enter(evalNode, a, nil)
+		cp.emit(opCallee, site)
+		cp.node = prev
+	} else {
+		cp.sub(fn, form.car)
+	}
+	argFrame := cp.frameMsg("evaluating function arguments")
+	argc := 0
+	for l := args; l != Nil; argc++ {
+		cp.sub(argFrame, l.car)
+		next, ok := l.cdr.(*ConsCell)
+		if !ok {
+			cp.fail(baseError("malformed list for eval"))
+			return
+		}
+		l = next
+	}
+	cp.c.calls[site].argc = argc
+	if tail {
+		cp.emit(opTailCall, site)
+	} else {
+		cp.emit(opCall, site)
+	}
+	cp.c.calls[site].after = cp.here()
+}
+
+func (cp *compiler) cond(pairs *ConsCell, tail bool) {
+	condFrame := cp.frameMsg("evaluating cond condition")
+	ends := []int{}
+	for l := pairs; l != Nil; {
+		pair, ok := l.car.(*ConsCell)
+		if !ok || pair == Nil {
+			cp.fail(baseError("cond requires a list of pairs"))
+			break
+		}
+		// There's no need to test `t`, and no later pair is reached:
+		always := pair.car == True
+		next := -1
+		if !always {
+			cp.sub(condFrame, pair.car)
+			next = cp.emit(opJumpIfNil, 0)
+		}
+		branch, ok := pair.cdr.(*ConsCell)
+		if !ok || branch == Nil {
+			cp.fail(baseError("cond requires a list of pairs"))
+		} else {
+			cp.tail(branch.car, tail)
+			if !tail {
+				ends = append(ends, cp.emit(opJump, 0))
+			}
+		}
+		if always {
+			break
+		}
+		cp.patch(next, cp.here())
+		if l, ok = l.cdr.(*ConsCell); !ok {
+			cp.fail(baseError("cond requires a list of pairs"))
+			break
+		}
+	}
+	cp.literal(Nil, tail)
+	for _, pc := range ends {
+		cp.patch(pc, cp.here())
+	}
+}
+
+func (cp *compiler) and(args *ConsCell, tail bool) {
+	frame := cp.frameMsg("and operator")
+	falses := []int{}
+	for l := args; l != Nil; {
+		cp.sub(frame, l.car)
+		falses = append(falses, cp.emit(opJumpIfNil, 0))
+		var ok bool
+		if l, ok = l.cdr.(*ConsCell); !ok {
+			cp.fail(baseError("and requires a list of expressions"))
+			break
+		}
+	}
+	cp.emit(opConst, cp.constant(True))
+	end := cp.emit(opJump, 0)
+	for _, pc := range falses {
+		cp.patch(pc, cp.here())
+	}
+	cp.emit(opConst, cp.constant(Nil))
+	cp.patch(end, cp.here())
+	cp.ret(tail)
+}
+
+func (cp *compiler) or(args *ConsCell, tail bool) {
+	frame := cp.frameMsg("or operator")
+	trues := []int{}
+	for l := args; l != Nil; {
+		cp.sub(frame, l.car)
+		trues = append(trues, cp.emit(opJumpUnlessNil, 0))
+		var ok bool
+		if l, ok = l.cdr.(*ConsCell); !ok {
+			cp.fail(baseError("or requires a list of expressions"))
+			break
+		}
+	}
+	cp.emit(opConst, cp.constant(Nil))
+	for _, pc := range trues {
+		cp.patch(pc, cp.here())
+	}
+	cp.ret(tail)
+}
+
+func (cp *compiler) loop(body *ConsCell) {
+	frame := cp.frameMsg("loop operator")
+	top := cp.here()
+	for l := body; l != Nil; {
+		cp.sub(frame, l.car)
+		cp.emit(opPop, 1)
+		var ok bool
+		if l, ok = l.cdr.(*ConsCell); !ok {
+			cp.fail(baseError("loop requires a list of expressions"))
+			return
+		}
+	}
+	cp.emit(opJump, top)
+}
+
+func (cp *compiler) def(args *ConsCell, tail bool) {
+	if args == Nil {
+		cp.fail(typedError("arity-error", Nil, "missing argument"))
+		return
+	}
+	name, ok := args.car.(Atom)
+	if !ok {
+		cp.fail(baseError("def: first argument must be an atom"))
+		return
+	}
+	rest, ok := args.cdr.(*ConsCell)
+	if !ok || rest == Nil {
+		cp.fail(typedError("arity-error", Nil, "missing argument"))
+		return
+	}
+	cp.sub(cp.frameMsg("evaluating def value"), rest.car)
+	cp.emit(opDef, cp.constant(name))
+	cp.ret(tail)
+}
+
+func (cp *compiler) set(args *ConsCell, tail bool) {
+	if args == Nil {
+		cp.fail(typedError("arity-error", Nil, "missing argument"))
+		return
+	}
+	if args.car == Nil {
+		cp.fail(baseError("set!: first argument cannot be nil!"))
+		return
+	}
+	name, ok := args.car.(Atom)
+	if !ok {
+		cp.fail(baseErrorf("set!: first argument must be an atom"))
+		return
+	}
+	rest, ok := args.cdr.(*ConsCell)
+	if !ok || rest == Nil {
+		cp.fail(typedError("arity-error", Nil, "missing argument"))
+		return
+	}
+	cp.sub(cp.frameMsg("evaluating set value"), rest.car)
+	cp.emit(opSet, cp.ref(name))
+	cp.ret(tail)
+}
+
+func (cp *compiler) defn(args *ConsCell, isMacro, tail bool) {
+	errPreamble := "defn"
+	if isMacro {
+		errPreamble = "defmacro"
+	}
+	if args == Nil {
+		cp.fail(baseErrorf("%s requires a function name", errPreamble))
+		return
+	}
+	name, ok := args.car.(Atom)
+	if !ok {
+		cp.fail(baseErrorf("%s name must be an atom", errPreamble))
+		return
+	}
+	rest, ok := args.cdr.(*ConsCell)
+	if !ok || rest == Nil {
+		cp.fail(baseErrorf("%s requires an argument list", errPreamble))
+		return
+	}
+	cp.declareName(rest)
+	cp.c.lambdas = append(cp.c.lambdas,
+		lambdaSite{args: rest, name: name.s, isMacro: isMacro, proto: &lambdaProto{}})
+	cp.emit(opDefn, len(cp.c.lambdas)-1)
+	cp.ret(tail)
+}
+
+func (cp *compiler) raise(args *ConsCell) {
+	argList, err := consToExprs(args)
+	if err != nil {
+		cp.fail(extendError("raise", err))
+		return
+	}
+	if len(argList) < 2 || len(argList) > 4 {
+		cp.fail(typedError("arity-error", Nil, "raise expects two to four arguments"))
+		return
+	}
+	frame := cp.frameMsg("evaluating raise arguments")
+	for _, arg := range argList {
+		cp.sub(frame, arg)
+	}
+	cp.emit(opRaise, len(argList))
+}
+
+func (cp *compiler) let(args *ConsCell, tail bool) {
+	badBinding := baseError("a let binding must be a list of binding pairs")
+	if args == Nil {
+		cp.fail(baseError("let requires a binding list"))
+		return
+	}
+	bindings, ok := args.car.(*ConsCell)
+	if !ok {
+		cp.fail(baseError("let bindings must be a list"))
+		return
+	}
+	body, ok := args.cdr.(*ConsCell)
+	if !ok {
+		cp.fail(baseError("let requires a body"))
+		return
+	}
+	bindFrame := cp.frameMsg("evaluating let bindings")
+	names := []symbol{}
+	patterns := []Sexpr{}
+	destructures := false
+	for l := bindings; l != Nil; {
+		binding, ok := l.car.(*ConsCell)
+		if !ok || binding == Nil {
+			cp.fail(badBinding)
+			return
+		}
+		name, isName := binding.car.(Atom)
+		if !isName && !isPattern(binding.car) {
+			cp.fail(badBinding)
+			return
+		}
+		val, ok := binding.cdr.(*ConsCell)
+		if !ok {
+			cp.fail(badBinding)
+			return
+		}
+		if val == Nil {
+			// As in the walker, a binding without a value ends the
+			// let, which is ():
+			if len(patterns) > 0 {
+				cp.emit(opPop, len(patterns))
+			}
+			cp.literal(Nil, tail)
+			return
+		}
+		cp.sub(bindFrame, val.car)
+		if name == True {
+			cp.fail(extendError("setting let bindings", baseError("cannot bind or set t")))
+			return
+		}
+		patterns = append(patterns, binding.car)
+		if isName {
+			names = append(names, intern(name.s))
+		} else {
+			bound, err := patternNames(binding.car)
+			if err != nil {
+				cp.fail(extendError("setting let bindings", err))
+				return
+			}
+			names = append(names, bound...)
+			destructures = true
+		}
+		if l, ok = l.cdr.(*ConsCell); !ok {
+			cp.fail(baseError("let bindings must be a list"))
+			return
+		}
+	}
+	site := len(cp.c.lets)
+	cp.c.lets = append(cp.c.lets, letSite{n: len(names)})
+	if destructures {
+		cp.c.lets[site].patterns = patterns
+	}
+	cp.emit(opLet, site)
+	s := &scope{names: names, parent: cp.scope}
+	cp.scope = s
+	defer func() {
+		cp.scope = s.parent
+		cp.c.lets[site].names = s.names
+	}()
+	if body == Nil {
+		cp.emit(opConst, cp.constant(Nil))
+	}
+	bodyFrame := cp.frameMsg("evaluating let body")
+	for l := body; l != Nil; {
+		next, ok := l.cdr.(*ConsCell)
+		if !ok || next == Nil {
+			cp.tail(l.car, tail)
+			break
+		}
+		cp.sub(bodyFrame, l.car)
+		cp.emit(opPop, 1)
+		l = next
+	}
+	if tail {
+		if body == Nil {
+			cp.emit(opReturn, 0)
+		}
+		return
+	}
+	cp.emit(opPopEnv, 0)
+}
@@ -0,0 +1,145 @@
+package lisp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Condition is an error raised by l1 code (with `error` or `raise`) or by
+// the interpreter itself.  It has a type, such as `division-by-zero`, a
+// message, an arbitrary data payload, the form which raised it, and
+// possibly a cause: the condition which was being handled when it was
+// raised.
+//
+// As a condition returns up the call chain, frames are added to the front of
+// its trace, which is what is printed when the condition is not caught.  A
+// condition caught with `catch` can be raised again with `rethrow`.
+type Condition struct {
+	*conditionInfo // shared with copies of the condition with longer traces
+	trace          *ConsCell
+	located        bool // whether an `(at ...)` frame is in the trace
+}
+
+type conditionInfo struct {
+	typ     Atom
+	message Sexpr
+	data    Sexpr
+	form    Sexpr
+	cause   *Condition
+	hostErr error // for errors returned by Go code which aren't Conditions
+}
+
+// NewCondition returns a condition with the given type, message and data,
+// for Go code to return as an error from builtins.
+func NewCondition(typ string, message, data Sexpr) *Condition {
+	return &Condition{
+		conditionInfo: &conditionInfo{
+			typ:     Atom{typ},
+			message: message,
+			data:    data,
+		},
+		trace: list(message),
+	}
+}
+
+// Error returns the printed trace of the condition.
+func (c *Condition) Error() string {
+	return c.trace.String()
+}
+
+// Unwrap returns the Go error a condition was made from, if any.
+func (c *Condition) Unwrap() error {
+	return c.hostErr
+}
+
+// String returns a short description of the condition.
+func (c *Condition) String() string {
+	return fmt.Sprintf("<condition %s %s>", c.typ, c.message)
+}
+
+// Equal returns true if o is the same condition (possibly with a longer or
+// shorter trace).
+func (c *Condition) Equal(o Sexpr) bool {
+	o2, ok := o.(*Condition)
+	return ok && c.conditionInfo == o2.conditionInfo
+}
+
+// Type returns the type of the condition, such as `division-by-zero`.
+func (c *Condition) Type() Atom {
+	return c.typ
+}
+
+// Message returns the condition's message, usually a list of atoms or a
+// string.
+func (c *Condition) Message() Sexpr {
+	return c.message
+}
+
+// Data returns the data given when the condition was raised, or ().
+func (c *Condition) Data() Sexpr {
+	return c.data
+}
+
+// Form returns the innermost form whose evaluation raised the condition, or
+// () if it is not known.
+func (c *Condition) Form() Sexpr {
+	if c.form == nil {
+		return Nil
+	}
+	return c.form
+}
+
+// Cause returns the condition which was being handled when c was raised, or
+// nil.
+func (c *Condition) Cause() *Condition {
+	return c.cause
+}
+
+// Trace returns the frames of the condition's trace, outermost first.
+func (c *Condition) Trace() *ConsCell {
+	return c.trace
+}
+
+// withFrame returns a copy of the condition with frame added to the front of
+// its trace.
+func (c *Condition) withFrame(frame Sexpr) *Condition {
+	ret := *c
+	ret.trace = Cons(frame, c.trace)
+	return &ret
+}
+
+// hasCause returns true if o is c's cause, or its cause's cause, etc.
+func (c *Condition) hasCause(o *Condition) bool {
+	for cause := c.cause; cause != nil; cause = cause.cause {
+		if cause.Equal(o) {
+			return true
+		}
+	}
+	return false
+}
+
+// asCondition returns err as a Condition; errors from Go code which are not
+// Conditions become conditions of type `host-error`.
+func asCondition(err error) *Condition {
+	if c, ok := err.(*Condition); ok {
+		return c
+	}
+	c := NewCondition("host-error", stringsToList(strings.Split(err.Error(), " ")...), Nil)
+	c.hostErr = err
+	return c
+}
+
+// matchesType returns true if a `catch` clause (or `errors`) for type typ
+// should handle the condition; the type `error` matches all conditions.
+func (c *Condition) matchesType(typ Atom) bool {
+	return typ == c.typ || typ.s == "error"
+}
+
+// conditionArg returns the Condition argument of a builtin.
+func conditionArg(arg Sexpr) (*Condition, error) {
+	c, ok := arg.(*Condition)
+	if !ok {
+		return nil, typedErrorf("type-error", arg, "'%s' is not a condition", arg)
+	}
+	return c, nil
+}
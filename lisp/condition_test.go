@@ -0,0 +1,103 @@
+package lisp
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestNativeConditionTypes(t *testing.T) {
+	interp, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		input, typ, data string
+	}{
+		{"(/ 1 0)", "division-by-zero", "()"},
+		{"(rem 1 0)", "division-by-zero", "()"},
+		{"undefined-thing", "unknown-symbol", "undefined-thing"},
+		{"((lambda (x) x))", "arity-error", "()"},
+		{"(car)", "arity-error", "()"},
+		{"(hash-count 1 2)", "arity-error", "()"},
+		{"(car 3)", "type-error", "3"},
+		{"(+ 1 'a)", "type-error", "a"},
+		{"(vget [1] 5)", "index-error", "5"},
+		{"(error '(oops))", "error", "()"},
+		{"(raise 'my-error \"bad\" '(1 2))", "my-error", "(1 2)"},
+		{"(is (= 1 2))", "assertion-failed", "(1 2)"},
+	}
+	for _, test := range tests {
+		_, err := interp.Eval(test.input)
+		c, ok := err.(*Condition)
+		if !ok {
+			t.Errorf("Eval(%q) error = %v, want a condition", test.input, err)
+			continue
+		}
+		if c.Type().String() != test.typ {
+			t.Errorf("Eval(%q) raised %s, want %s", test.input, c.Type(), test.typ)
+		}
+		if c.Data().String() != test.data {
+			t.Errorf("Eval(%q) data = %s, want %s", test.input, c.Data(), test.data)
+		}
+	}
+}
+
+func TestConditionsFromGo(t *testing.T) {
+	interp, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = interp.RegisterBuiltin(&Builtin{
+		Name:       "open-file",
+		FixedArity: 1,
+		Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+			if args[0] == Nil {
+				return nil, NewCondition("bad-file", Str("no file name"), args[0])
+			}
+			_, err := os.Open(args[0].String())
+			return Nil, err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := interp.Eval("(try (open-file ()) (catch (bad-file e) (condition-message e)))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != `"no file name"` {
+		t.Errorf("got %s, want the condition's message", got)
+	}
+	got, err = interp.Eval("(try (open-file 'no-such-file) (catch (host-error e) (condition-type e)))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "host-error" {
+		t.Errorf("Go errors should become host-error conditions, got %s", got)
+	}
+	_, err = interp.Eval("(open-file 'no-such-file)")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("conditions should unwrap to the original Go error; got %v", err)
+	}
+}
+
+func TestConditionCopies(t *testing.T) {
+	c := NewCondition("x", list(Atom{"boom"}), Nil)
+	c2 := extendError("outer frame", c).(*Condition)
+	if !c.Equal(c2) || !c2.Equal(c) {
+		t.Error("a condition with a longer trace should be the same condition")
+	}
+	if c.Trace().String() != "((boom))" {
+		t.Errorf("extending a trace should not change the original: %s", c.Trace())
+	}
+	if c.Equal(NewCondition("x", list(Atom{"boom"}), Nil)) {
+		t.Error("distinct conditions should not be equal")
+	}
+	cause := NewCondition("y", Nil, Nil)
+	setCause(c2, cause)
+	setCause(cause, c)
+	if c.Cause() != cause || cause.Cause() != nil {
+		t.Errorf("setCause should not make a cycle: %v %v", c.Cause(), cause.Cause())
+	}
+}
@@ -0,0 +1,69 @@
+package lisp
+
+import "sync/atomic"
+
+// Continuations made by call/cc are escape-only: calling one returns from
+// the call/cc which made it, unwinding everything evaluated since, and
+// calling one after that call/cc has returned is an error.  Since the walker
+// and the VM both keep their state on the Go stack, a continuation which
+// could be re-entered would need the interpreter to be rewritten in
+// continuation-passing style.
+
+// continuation is the state of a continuation made by call/cc.
+type continuation struct {
+	done atomic.Bool // whether the call/cc has returned; read by other tasks
+}
+
+// escape is the error by which a continuation returns its value from its
+// call/cc, through the forms evaluated in between.  It isn't a condition:
+// `catch`, `errors` and `swallow` let it pass, though `finally` clauses run
+// as it does.
+type escape struct {
+	k   *continuation
+	val Sexpr
+}
+
+func (esc *escape) Error() string {
+	return "continuation called outside call/cc"
+}
+
+func isEscape(err error) bool {
+	_, ok := err.(*escape)
+	return ok
+}
+
+// callCC calls the function in args with a continuation, as a builtin
+// function which can be called with no argument or one.
+func callCC(args []Sexpr, e *Env) (Sexpr, error) {
+	if len(args) != 1 {
+		return nil, typedError("arity-error", Nil, "call/cc expects a single argument")
+	}
+	k := &continuation{}
+	kFn := &Builtin{
+		Name:       "continuation",
+		Doc:        convertStringToDoc("Return from call/cc with the argument, or ()"),
+		FixedArity: 0,
+		NAry:       true,
+		Args:       Cons(Nil, Atom{"x"}),
+		Examples:   Nil,
+		Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+			if len(args) > 1 {
+				return nil, typedError("arity-error", Nil, "a continuation takes at most one argument")
+			}
+			if k.done.Load() {
+				return nil, baseError("continuation called after its call/cc returned")
+			}
+			var val Sexpr = Nil
+			if len(args) == 1 {
+				val = args[0]
+			}
+			return nil, &escape{k, val}
+		},
+	}
+	ret, err := funcall(args[0], []Sexpr{kFn}, e)
+	k.done.Store(true)
+	if esc, ok := err.(*escape); ok && esc.k == k {
+		return esc.val, nil
+	}
+	return ret, err
+}
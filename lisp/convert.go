@@ -0,0 +1,161 @@
+package lisp
+
+import (
+	"math/big"
+	"reflect"
+	"sort"
+)
+
+// ToGo converts an l1 value to plain Go data:
+//
+//   - integers become int64 if they fit, *big.Int otherwise;
+//   - rationals become *big.Rat, and floats float64;
+//   - t becomes true, and () becomes nil;
+//   - strings and other atoms become strings;
+//   - lists and vectors become []interface{}, converted recursively;
+//   - hash maps become map[string]interface{}, if their keys are all atoms
+//     or strings.
+//
+// Functions and improper lists cannot be converted.
+func ToGo(x Sexpr) (interface{}, error) {
+	switch t := x.(type) {
+	case Number:
+		switch t.kind {
+		case ratNum:
+			return new(big.Rat).Set(t.rat), nil
+		case floatNum:
+			return t.fl, nil
+		}
+		if t.bi.IsInt64() {
+			return t.bi.Int64(), nil
+		}
+		return new(big.Int).Set(&t.bi), nil
+	case Atom:
+		if t == True {
+			return true, nil
+		}
+		return t.s, nil
+	case String:
+		return t.s, nil
+	case *ConsCell:
+		if t == Nil {
+			return nil, nil
+		}
+		ret := []interface{}{}
+		var l Sexpr = t
+		for l != Nil {
+			c, ok := l.(*ConsCell)
+			if !ok {
+				return nil, baseErrorf("cannot convert improper list %s", x)
+			}
+			item, err := ToGo(c.car)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, item)
+			l = c.cdr
+		}
+		return ret, nil
+	case *Vector:
+		ret := make([]interface{}, len(t.items))
+		for i, item := range t.items {
+			conv, err := ToGo(item)
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = conv
+		}
+		return ret, nil
+	case *HashMap:
+		ret := map[string]interface{}{}
+		for _, entry := range t.entries() {
+			var k string
+			switch kt := entry.key.(type) {
+			case Atom:
+				k = kt.s
+			case String:
+				k = kt.s
+			default:
+				return nil, baseErrorf("cannot convert hash map with key %s", entry.key)
+			}
+			val, err := ToGo(entry.val)
+			if err != nil {
+				return nil, err
+			}
+			ret[k] = val
+		}
+		return ret, nil
+	default:
+		return nil, baseErrorf("cannot convert %s to a Go value", x)
+	}
+}
+
+// FromGo converts Go data to an l1 value; it is the inverse of ToGo.  In
+// addition to the types returned by ToGo, FromGo accepts any Go integer or
+// float type, big.Int, slices and arrays, and maps with string keys.  Go
+// strings become l1 strings.  Maps become hash maps with atoms as keys, added
+// in sorted order.  Values which are already S-expressions are returned as
+// is.
+func FromGo(v interface{}) (Sexpr, error) {
+	switch t := v.(type) {
+	case nil:
+		return Nil, nil
+	case Sexpr:
+		return t, nil
+	case bool:
+		if t {
+			return True, nil
+		}
+		return Nil, nil
+	case string:
+		return String{t}, nil
+	case *big.Int:
+		return Int(t), nil
+	case big.Int:
+		return Int(&t), nil
+	case *big.Rat:
+		return ratNumber(new(big.Rat).Set(t)), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int(big.NewInt(rv.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return Int(new(big.Int).SetUint64(rv.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return floatNumber(rv.Float()), nil
+	case reflect.Slice, reflect.Array:
+		items := make([]Sexpr, rv.Len())
+		for i := range items {
+			item, err := FromGo(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return list(items...), nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, baseErrorf("cannot convert map with %s keys", rv.Type().Key())
+		}
+		keys := []string{}
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		h := mkHashMap()
+		for _, k := range keys {
+			val, err := FromGo(rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key())).Interface())
+			if err != nil {
+				return nil, err
+			}
+			if err := h.set(Atom{k}, val); err != nil {
+				return nil, err
+			}
+		}
+		return h, nil
+	default:
+		return nil, baseErrorf("cannot convert Go value of type %T", v)
+	}
+}
@@ -0,0 +1,110 @@
+package lisp
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestToGo(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	var tests = []struct {
+		input string
+		want  interface{}
+	}{
+		{"1", int64(1)},
+		{"-5", int64(-5)},
+		{"123456789012345678901234567890", huge},
+		{"(/ 2 -6)", big.NewRat(-1, 3)},
+		{"1.5", 1.5},
+		{"t", true},
+		{"()", nil},
+		{"'foo", "foo"},
+		{`"foo bar"`, "foo bar"},
+		{"'(1 (a b) ())", []interface{}{int64(1), []interface{}{"a", "b"}, nil}},
+		{"['a [1] []]", []interface{}{"a", []interface{}{int64(1)}, []interface{}{}}},
+		{`{'a 1 "b" (list 2)}`, map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2)}}},
+	}
+	interp, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		x, err := interp.Eval(test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ToGo(x)
+		if err != nil {
+			t.Errorf("ToGo(%s) failed: %v", x, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ToGo(%s) = %#v, want %#v", x, got, test.want)
+		}
+	}
+	for _, input := range []string{"'(1 . 2)", "car", "(lambda (x) x)", "{'(1) 2}"} {
+		x, err := interp.Eval(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ToGo(x); err == nil {
+			t.Errorf("ToGo(%s) = %v, expected error", x, got)
+		}
+	}
+}
+
+func TestFromGo(t *testing.T) {
+	var tests = []struct {
+		input interface{}
+		want  string
+	}{
+		{nil, "()"},
+		{true, "t"},
+		{false, "()"},
+		{3, "3"},
+		{uint8(255), "255"},
+		{int64(-9), "-9"},
+		{big.NewInt(12), "12"},
+		{big.NewRat(6, 4), "3/2"},
+		{2.5, "2.5"},
+		{float32(-1), "-1.0"},
+		{"hello", `"hello"`},
+		{Num(4), "4"},
+		{[]int{1, 2, 3}, "(1 2 3)"},
+		{[]interface{}{"a", []string{"b", "c"}, nil}, `("a" ("b" "c") ())`},
+		{map[string]interface{}{"z": 1, "a": []int{2}}, "{a (2) z 1}"},
+	}
+	for _, test := range tests {
+		got, err := FromGo(test.input)
+		if err != nil {
+			t.Errorf("FromGo(%#v) failed: %v", test.input, err)
+			continue
+		}
+		if got.String() != test.want {
+			t.Errorf("FromGo(%#v) = %s, want %s", test.input, got, test.want)
+		}
+	}
+	for _, input := range []interface{}{1i, map[int]int{1: 2}, struct{}{}} {
+		if _, err := FromGo(input); err == nil ||
+			!strings.Contains(err.Error(), "cannot convert") {
+			t.Errorf("FromGo(%#v): expected conversion error, got %v", input, err)
+		}
+	}
+}
+
+func TestGoRoundTrip(t *testing.T) {
+	data := []interface{}{int64(1), "two", []interface{}{true, int64(-3)}}
+	x, err := FromGo(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ToGo(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, data) {
+		t.Errorf("round trip of %#v gave %#v", data, got)
+	}
+}
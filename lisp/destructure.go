@@ -0,0 +1,126 @@
+package lisp
+
+// Arguments of functions, and the names `let` binds, may be patterns: lists
+// of names, or of further patterns, such as (a (b c) . rest).  A pattern
+// matches a list of the same shape, binding each name to the corresponding
+// part of the list; a name after a dot is bound to the rest of it.
+
+// patternNames returns the names bound by pattern, in the order destructure
+// gives their values.
+func patternNames(pattern Sexpr) ([]symbol, error) {
+	names := []symbol{}
+	var walk func(p Sexpr) error
+	walk = func(p Sexpr) error {
+		switch t := p.(type) {
+		case Atom:
+			if t == True {
+				return baseError("cannot bind or set t")
+			}
+			names = append(names, intern(t.s))
+			return nil
+		case *ConsCell:
+			for t != Nil {
+				if err := walk(t.car); err != nil {
+					return err
+				}
+				switch rest := t.cdr.(type) {
+				case Atom:
+					return walk(rest)
+				case *ConsCell:
+					t = rest
+				default:
+					return baseErrorf("'%s' in pattern %s is not a name", rest, pattern)
+				}
+			}
+			return nil
+		default:
+			return baseErrorf("'%s' in pattern %s is not a name", p, pattern)
+		}
+	}
+	if err := walk(pattern); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// isPattern returns true if x, in an argument list or a let binding, is a
+// pattern to destructure rather than a name.
+func isPattern(x Sexpr) bool {
+	c, ok := x.(*ConsCell)
+	return ok && c != Nil
+}
+
+// destructure appends to vals the values of the names in pattern, matched
+// against x, returning an error if x doesn't have the pattern's shape.
+func destructure(pattern, x Sexpr, vals []Sexpr) ([]Sexpr, error) {
+	p, ok := pattern.(*ConsCell)
+	if !ok {
+		return append(vals, x), nil
+	}
+	if v, ok := x.(*Vector); ok {
+		x = list(v.items...)
+	}
+	for xs := x; ; {
+		if p == Nil {
+			if xs != Nil {
+				return nil, typedErrorf("arity-error", x,
+					"too many elements in %s for pattern %s", x, pattern)
+			}
+			return vals, nil
+		}
+		l, ok := xs.(*ConsCell)
+		if !ok {
+			return nil, typedErrorf("type-error", x,
+				"pattern %s expects a list, got %s", pattern, x)
+		}
+		if l == Nil {
+			return nil, typedErrorf("arity-error", x,
+				"not enough elements in %s for pattern %s", x, pattern)
+		}
+		var err error
+		if vals, err = destructure(p.car, l.car, vals); err != nil {
+			return nil, err
+		}
+		switch rest := p.cdr.(type) {
+		case Atom:
+			return append(vals, l.cdr), nil
+		case *ConsCell:
+			p, xs = rest, l.cdr
+		}
+	}
+}
+
+// bindPattern binds the names in pattern, in e, to the parts of x they
+// match, as the walker does.
+func bindPattern(e *Env, pattern, x Sexpr) error {
+	if a, ok := pattern.(Atom); ok {
+		return e.Set(a.s, x)
+	}
+	names, err := patternNames(pattern)
+	if err != nil {
+		return err
+	}
+	vals, err := destructure(pattern, x, nil)
+	if err != nil {
+		return err
+	}
+	for i, name := range names {
+		if err := e.Set(name.String(), vals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// destructureAll returns the values of the names in patterns, matched
+// against xs.
+func destructureAll(patterns, xs []Sexpr) ([]Sexpr, error) {
+	vals := []Sexpr{}
+	for i, p := range patterns {
+		var err error
+		if vals, err = destructure(p, xs[i], vals); err != nil {
+			return nil, err
+		}
+	}
+	return vals, nil
+}
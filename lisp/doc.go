@@ -24,6 +24,7 @@ type formRec struct {
 	ftype     string
 	args      *ConsCell
 	examples  string
+	caps      []Capability
 }
 
 func a(s string) Sexpr { return Atom{s} }
@@ -156,7 +157,7 @@ ERROR in '(ensure-list 3)':
 		farity:    1,
 		isSpecial: true,
 		ismulti:   true,
-		doc:       convertStringToDoc("Error checking, for tests"),
+		doc:       convertStringToDoc("Error checking, for tests: check that body raises an error containing the expected list, or a condition of the expected type"),
 		args:      Cons(a("expected"), a("body")),
 		ftype:     special,
 		examples: `> (errors '(is not a function)
@@ -168,6 +169,40 @@ ERROR in '(ensure-list 3)':
 ;;=>
 ERROR in '(errors (quote (is not a function)) (+))':
 error not found in ((quote (is not a function)) (+))
+> (errors 'division-by-zero (/ 1 0))
+;;=>
+()
+`,
+	},
+	{
+		name:      "export",
+		farity:    0,
+		isSpecial: true,
+		ismulti:   true,
+		doc:       convertStringToDoc("Make names defined in the current module available to modules which import it"),
+		ftype:     special,
+		args:      Cons(Nil, a("names")),
+		examples: `> (module geometry
+    (export area)
+    (defn area (r) (* 3 r r)))
+;;=>
+<module geometry>
+`,
+	},
+	{
+		name:      "import",
+		farity:    1,
+		isSpecial: true,
+		ismulti:   true,
+		doc:       convertStringToDoc("Import a module, loading its file if need be; its exports are available as alias/name"),
+		ftype:     special,
+		args:      Cons(a("name"), a("as-alias")),
+		examples: `> (import geometry :as g)
+;;=>
+()
+> (g/area 2)
+;;=>
+12
 `,
 	},
 	{
@@ -198,7 +233,7 @@ t
 		farity:    1,
 		isSpecial: true,
 		ismulti:   true,
-		doc:       convertStringToDoc("Create a local scope with bindings"),
+		doc:       convertStringToDoc("Create a local scope with bindings, each of a name or a pattern to destructure"),
 		ftype:     special,
 		args:      Cons(a("binding-pairs"), a("body")),
 
@@ -207,6 +242,10 @@ t
     (+ a b))
 ;;=>
 3
+> (let (((a b . more) '(1 2 3 4)))
+    (list a b more))
+;;=>
+(1 2 (3 4))
 `,
 	},
 	{
@@ -225,6 +264,28 @@ Help me, I am looping forever!
 Help me, I am looping forever!
 Help me, I am looping forever!
 ...
+`,
+	},
+	{
+		name:      "module",
+		farity:    1,
+		isSpecial: true,
+		ismulti:   true,
+		doc:       convertStringToDoc("Define a module, evaluating body in a top-level environment of its own"),
+		ftype:     special,
+		args:      Cons(a("name"), a("body")),
+		examples: `> (module counter
+    (export next)
+    (def n 0)
+    (defn next () (set! n (inc n))))
+;;=>
+<module counter>
+> (import counter)
+;;=>
+()
+> (counter/next)
+;;=>
+1
 `,
 	},
 	{
@@ -256,6 +317,45 @@ foo
 (1 2 3)
 > '(1 2 3)
 (1 2 3)
+`,
+	},
+	{
+		name:      "raise",
+		farity:    2,
+		isSpecial: true,
+		ismulti:   true,
+		doc:       convertStringToDoc("Raise a condition of the given type, with a message and optional data and cause"),
+		ftype:     special,
+		args:      Cons(a("type"), Cons(a("message"), a("more"))),
+		examples: `> (raise 'out-of-cheese '(please reinstall universe) 42)
+;;=>
+ERROR:
+((at repl:1:1) (please reinstall universe))
+> (try
+    (raise 'out-of-cheese '(please reinstall universe) 42)
+    (catch (out-of-cheese e)
+      (list (condition-type e) (condition-data e))))
+;;=>
+(out-of-cheese 42)
+`,
+	},
+	{
+		name:      "rethrow",
+		farity:    1,
+		isSpecial: true,
+		ismulti:   false,
+		doc:       convertStringToDoc("Raise a caught condition again, keeping its type, data and trace"),
+		ftype:     special,
+		args:      list(a("condition")),
+		examples: `> (try
+    (/ 1 0)
+    (catch e
+      (printl '(cleaning up))
+      (rethrow e)))
+;;=>
+cleaning up
+ERROR:
+((catch body) (at repl:2:5) (builtin function /) (division by zero))
 `,
 	},
 	{
@@ -330,12 +430,19 @@ ERROR:
 > (try
     (error '(boom))
     (catch e
-      (printl e)))
+      (printl (condition-message e))))
 ;;=>
-(boom)
-> (try (/ 1 0) (catch e (len e)))
-2
->
+boom
+> (try (/ 1 0) (catch (division-by-zero e) (condition-type e)))
+;;=>
+division-by-zero
+> (try (car 1) (catch (division-by-zero e) 'never) (catch e 'instead))
+;;=>
+instead
+> (try (/ 1 0) (catch e 'caught) (finally (printl '(always))))
+;;=>
+always
+caught
 `,
 	},
 	{
@@ -349,7 +456,7 @@ ERROR:
 	},
 }
 
-const columnsFormat = "%14s %2s %5s  %s"
+const columnsFormat = "%17s %2s %5s  %s"
 
 func formatFunctionInfo(name, shortDesc string,
 	arity int,
@@ -395,6 +502,8 @@ func functionExamplesFromDoc(l lambdaFn) *ConsCell {
 	}
 }
 
+// examplesToString shows examples with their values in e, or (if e is nil)
+// just the examples.
 func examplesToString(examples *ConsCell, e *Env) string {
 	ret := ""
 	for {
@@ -405,8 +514,10 @@ func examplesToString(examples *ConsCell, e *Env) string {
 		if example == Nil {
 			break
 		}
-		output, err := eval(example, e)
-		if err != nil {
+		if e == nil {
+			// There's nothing to evaluate it in:
+			ret += fmt.Sprintf("> %s\n", example)
+		} else if output, err := eval(example, e); err != nil {
 			ret += fmt.Sprintf("> %s\n;;=>\nERROR: %s\n", example, err)
 		} else {
 			ret += fmt.Sprintf("> %s\n;;=>\n%s\n", example, output)
@@ -425,52 +536,26 @@ func availableForms(e *Env) ([]formRec, error) {
 	// Start with special forms...
 	out := specialForms
 
-	// Add builtins...:
-	for _, builtin := range builtins {
-		out = append(out, formRec{
-			name:     builtin.Name,
-			farity:   builtin.FixedArity,
-			ismulti:  builtin.NAry,
-			isNative: true,
-			doc:      builtin.Doc,
-			ftype:    native,
-			args:     builtin.Args,
-			examples: examplesToString(builtin.Examples, e),
-		})
+	// Add builtins, including any defined by the host program...:
+	available := definedBuiltins(e)
+	for name, builtin := range builtins {
+		v, _ := e.Lookup(name)
+		if b, ok := v.(*Builtin); ok && b != builtin && b.Name == name {
+			// Replaced by a host-defined builtin of the same name
+			continue
+		}
+		available = append(available, builtin)
+	}
+	for _, builtin := range available {
+		out = append(out, builtinForm(builtin, e))
 	}
 	// Add user-defined / internal l1 functions...:
 	for _, lambdaName := range EnvKeys(e) {
 		expr, _ := e.Lookup(lambdaName)
 		l, ok := expr.(*lambdaFn)
-		if !ok {
-			continue
-		}
-		ftype := function
-		if l.isMacro {
-			ftype = macro
-		}
-		cl, err := consLength(l.args)
-		if err != nil {
-			return nil, extendError("availableForms", err)
-		}
-		args := l.args
-		if l.restArg != "" {
-			args = combineArgs(l.args, Atom{l.restArg})
-		}
 		if ok && l.doc != Nil {
-			examples := examplesToString(functionExamplesFromDoc(*l), e)
-			out = append(out, formRec{
-				name:     lambdaName,
-				farity:   cl,
-				isMacro:  l.isMacro,
-				ismulti:  l.restArg != "",
-				doc:      l.doc,
-				ftype:    ftype,
-				args:     args,
-				examples: examples,
-			})
+			out = append(out, lambdaForm(lambdaName, l, e))
 		}
-
 	}
 	// Order by name:
 	sort.Slice(out, func(i, j int) bool {
@@ -479,6 +564,93 @@ func availableForms(e *Env) ([]formRec, error) {
 	return out, nil
 }
 
+func builtinForm(builtin *Builtin, e *Env) formRec {
+	return formRec{
+		name:     builtin.Name,
+		farity:   builtin.FixedArity,
+		ismulti:  builtin.NAry,
+		isNative: true,
+		doc:      builtin.Doc,
+		ftype:    native,
+		args:     builtin.Args,
+		examples: examplesToString(builtin.Examples, e),
+		caps:     builtin.Capabilities,
+	}
+}
+
+func lambdaForm(name string, l *lambdaFn, e *Env) formRec {
+	ftype := function
+	if l.isMacro {
+		ftype = macro
+	}
+	args := l.args
+	if l.restArg != "" {
+		args = combineArgs(l.args, Atom{l.restArg})
+	}
+	return formRec{
+		name:     name,
+		farity:   l.nargs,
+		isMacro:  l.isMacro,
+		ismulti:  l.restArg != "" || len(l.optional) > 0 || len(l.keys) > 0,
+		doc:      l.doc,
+		ftype:    ftype,
+		args:     args,
+		examples: examplesToString(functionExamplesFromDoc(*l), e),
+	}
+}
+
+// describe returns the documentation of the special form, function or
+// macro with the given name, for reading at the REPL.
+func describe(name string, e *Env) (string, error) {
+	var form *formRec
+	for i := range specialForms {
+		if specialForms[i].name == name {
+			form = &specialForms[i]
+		}
+	}
+	if form == nil {
+		v, _ := e.Lookup(name)
+		switch t := v.(type) {
+		case *Builtin:
+			f := builtinForm(t, e)
+			form = &f
+		case *lambdaFn:
+			f := lambdaForm(name, t, e)
+			form = &f
+		case nil:
+			return "", typedErrorf("unknown-symbol", Atom{name}, "unknown symbol: %s", name)
+		default:
+			return "", typedErrorf("type-error", v, "%s is not a function or macro: %s", name, v)
+		}
+	}
+	return form.description(), nil
+}
+
+// description returns the documentation of form, as shown by describe.
+func (form *formRec) description() string {
+	multi := ""
+	if form.ismulti {
+		multi = "+"
+	}
+	doc := "(no documentation)"
+	if form.doc != Nil {
+		doc = capitalize(docToString(form.doc))
+	}
+	ret := fmt.Sprintf("%s: %s, arity %d%s\nArgs: %s\n%s\n",
+		form.name, form.ftype, form.farity, multi, form.args, doc)
+	if len(form.caps) > 0 {
+		names := make([]string, len(form.caps))
+		for i, c := range form.caps {
+			names[i] = string(c)
+		}
+		ret += fmt.Sprintf("Capabilities: %s\n", strings.Join(names, ", "))
+	}
+	if form.examples != "" {
+		ret += "Examples:\n" + form.examples
+	}
+	return strings.TrimRight(ret, "\n")
+}
+
 func combineArgs(args *ConsCell, cdr Sexpr) *ConsCell {
 	if cdr == Nil {
 		return args
@@ -527,6 +699,14 @@ func LongDocStr(e *Env) (string, error) {
 		if doc.ismulti {
 			isMulti = "+"
 		}
+		caps := ""
+		if len(doc.caps) > 0 {
+			names := make([]string, len(doc.caps))
+			for i, c := range doc.caps {
+				names[i] = codeQuote(string(c))
+			}
+			caps = fmt.Sprintf("\nCapabilities: %s\n", strings.Join(names, ", "))
+		}
 		examples := ""
 		if doc.examples != "" {
 			examples = fmt.Sprintf("\n### Examples\n\n```\n%s\n```\n", doc.examples)
@@ -542,7 +722,7 @@ Type: %s
 Arity: %d%s
 
 Args: %s
-
+%s
 %s
 
 [<sub><sup>Back to index</sup></sub>](#api-index)
@@ -555,6 +735,7 @@ Args: %s
 			doc.farity,
 			isMulti,
 			fmt.Sprintf("`%s`", doc.args),
+			caps,
 			examples))
 	}
 	return strings.Join(outStrs, "\n"), nil
@@ -574,10 +755,10 @@ func ShortDocStr(e *Env) (string, error) {
 		"l1 - a Lisp interpreter.\n",
 		fmt.Sprintf(columnsFormat, "", "Type", "", ""),
 		fmt.Sprintf(columnsFormat, "", "---", "", ""),
-		"                S - special form",
-		"                M - macro",
-		"                N - native (Go) function",
-		"                F - Lisp function\n",
+		"                   S - special form",
+		"                   M - macro",
+		"                   N - native (Go) function",
+		"                   F - Lisp function\n",
 		fmt.Sprintf(columnsFormat, "Name", "Type", "Arity", "Description"),
 		fmt.Sprintf(columnsFormat, "----", "---", "----", "-----------"),
 	)
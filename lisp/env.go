@@ -1,44 +1,178 @@
 package lisp
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 )
 
 // Env stores a local environment, possibly pointing to a caller's environment.
+// The values bound locally are kept in a slice, in the order of their names;
+// the compiler resolves variables to their place in the environments code
+// will run in.  The top-level environment keeps its values in a table
+// indexed by symbol instead.
 type Env struct {
-	syms   map[string]Sexpr
+	names  []symbol
+	vals   []Sexpr // never longer than names; missing values are unbound
 	parent *Env
+	// The values in the top-level environment, shared by all the
+	// environments below it:
+	globals *globals
+	// The interpreter owning this environment, if any; inherited from the
+	// parent:
+	interp *Interpreter
+	// The task evaluating code in this environment, or nil outside tasks;
+	// calls pass it on from the caller, rather than from the parent:
+	task *Task
 }
 
 // mkEnv makes a new Env.
 func mkEnv(parent *Env) Env {
+	if parent == nil {
+		g := newGlobals()
+		g.modules = newModules(g)
+		return Env{globals: g}
+	}
 	return Env{
-		syms:   map[string]Sexpr{},
-		parent: parent,
+		parent:  parent,
+		globals: parent.globals,
+		interp:  parent.interp,
+		task:    parent.task,
+	}
+}
+
+// mkFrame makes a new environment below parent, with the given names bound
+// to vals.  names is shared, not copied; it's never appended to in place.
+func mkFrame(parent *Env, names []symbol, vals []Sexpr) *Env {
+	return &Env{
+		names:   names,
+		vals:    vals,
+		parent:  parent,
+		globals: parent.globals,
+		interp:  parent.interp,
+		task:    parent.task,
+	}
+}
+
+// stdout returns the writer printing builtins should use.
+func (e *Env) stdout() io.Writer {
+	if e.interp == nil {
+		return os.Stdout
+	}
+	if out := e.captured(); out != nil {
+		return out
+	}
+	return e.interp.stdout
+}
+
+// stderr returns the writer used for reporting errors.
+func (e *Env) stderr() io.Writer {
+	if e.interp == nil {
+		return os.Stderr
+	}
+	if out := e.captured(); out != nil {
+		return out
+	}
+	return e.interp.stderr
+}
+
+// captured returns the writer capturing the output of the evaluation of
+// the code in e, or of the evaluation which spawned its task, if there is
+// one.
+func (e *Env) captured() *captureWriter {
+	if e.task != nil && e.task.out != nil {
+		return e.task.out
+	}
+	if e.interp == nil {
+		return nil
 	}
+	return e.interp.out.Load()
+}
+
+// treeWalking returns true if code should be evaluated by walking it rather
+// than compiling it.
+func (e *Env) treeWalking() bool {
+	return e.interp != nil && e.interp.treeWalk
+}
+
+// readLine reads a line from the interpreter's input, or from stdin if the
+// environment has no interpreter.
+func (e *Env) readLine() (string, error) {
+	if e.interp == nil {
+		return ReadLine()
+	}
+	e.interp.stdinMu.Lock()
+	defer e.interp.stdinMu.Unlock()
+	return readLineFrom(e.interp.stdin)
+}
+
+func readLineFrom(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
 }
 
 // EnvKeys returns the keys of an environment, including any parents' keys.
 func EnvKeys(m *Env) []string {
 	ret := []string{}
-	for k := range m.syms {
-		ret = append(ret, k)
+	if m.parent == nil {
+		m.globals.each(func(name symbol, _ Sexpr) {
+			ret = append(ret, name.String())
+		})
+		return ret
 	}
-	if m.parent != nil {
-		ret = append(ret, EnvKeys(m.parent)...)
+	for i, name := range m.names {
+		if i < len(m.vals) && m.vals[i] != nil {
+			ret = append(ret, name.String())
+		}
 	}
-	return ret
+	return append(ret, EnvKeys(m.parent)...)
+}
+
+// slot returns the index of name in e's values, or -1.  Later bindings of a
+// name shadow earlier ones.
+func (e *Env) slot(name symbol) int {
+	for i := len(e.names) - 1; i >= 0; i-- {
+		if e.names[i] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// local returns the value of name bound in e itself, if any.
+func (e *Env) local(name symbol) Sexpr {
+	if e.parent == nil {
+		return e.globals.get(name)
+	}
+	if i := e.slot(name); i >= 0 && i < len(e.vals) {
+		return e.vals[i]
+	}
+	return nil
 }
 
 // Lookup returns the value of a symbol in an environment or its parent(s).
 func (e *Env) Lookup(s string) (Sexpr, bool) {
-	if v, ok := e.syms[s]; ok {
-		return v, true
+	if name, ok := lookupSymbol(s); ok {
+		if v := e.lookup(name); v != nil {
+			return v, true
+		}
 	}
-	if e.parent != nil {
-		return e.parent.Lookup(s)
+	v, _ := e.qualified(s)
+	return v, v != nil
+}
+
+func (e *Env) lookup(name symbol) Sexpr {
+	for ; e != nil; e = e.parent {
+		if v := e.local(name); v != nil {
+			return v
+		}
 	}
-	return nil, false
+	return nil
 }
 
 // Set sets the value of a symbol in an environment.
@@ -46,16 +180,34 @@ func (e *Env) Set(s string, v Sexpr) error {
 	if s == "t" {
 		return baseError("cannot bind or set t")
 	}
-	e.syms[s] = v
+	e.set(intern(s), v)
 	return nil
 }
 
+func (e *Env) set(name symbol, v Sexpr) {
+	if e.parent == nil {
+		e.globals.set(name, v)
+		return
+	}
+	i := e.slot(name)
+	if i < 0 {
+		// Never append to names in place, since it may be shared:
+		i = len(e.names)
+		e.names = append(e.names[:i:i], name)
+	}
+	if i >= len(e.vals) {
+		e.vals = append(e.vals, make([]Sexpr, i+1-len(e.vals))...)
+	}
+	e.vals[i] = v
+}
+
 // SetTopLevel sets the value of a symbol in the top-level environment.
 func (e *Env) SetTopLevel(s string, v Sexpr) error {
-	if e.parent == nil {
-		return e.Set(s, v)
+	if s == "t" {
+		return baseError("cannot bind or set t")
 	}
-	return e.parent.SetTopLevel(s, v)
+	e.globals.set(intern(s), v)
+	return nil
 }
 
 // Update updates the value of a symbol in an environment, or in a parent.
@@ -63,24 +215,35 @@ func (e *Env) Update(s string, v Sexpr) error {
 	if s == "t" {
 		return baseError("cannot bind or set t")
 	}
-	if _, ok := e.syms[s]; ok {
-		e.syms[s] = v
-		return nil
+	if name, ok := lookupSymbol(s); ok {
+		for env := e; env != nil; env = env.parent {
+			if env.local(name) != nil {
+				env.set(name, v)
+				return nil
+			}
+		}
 	}
-	if e.parent != nil {
-		return e.parent.Update(s, v)
+	// Code in a module may refer to its own definitions as module/name:
+	if m, name := e.globals.qualifier(s); m != nil && m == e.globals.module {
+		return m.env.Update(name, v)
 	}
 	return baseErrorf("%s is not bound in any environment", s)
 }
 
 func (e *Env) String() string {
 	ret := ""
-	for k, v := range e.syms {
-		ret += fmt.Sprintf("%s=%s\n", k, v)
+	if e.parent == nil {
+		e.globals.each(func(name symbol, v Sexpr) {
+			ret += fmt.Sprintf("%s=%s\n", name, v)
+		})
+		return ret + "\n"
 	}
-	ret += "\n"
-	if e.parent != nil {
-		ret += fmt.Sprintf("PARENT: %s\n", e.parent.String())
+	for i, v := range e.vals {
+		if v != nil {
+			ret += fmt.Sprintf("%s=%s\n", e.names[i], v)
+		}
 	}
+	ret += "\n"
+	ret += fmt.Sprintf("PARENT: %s\n", e.parent.String())
 	return ret
 }
@@ -1,6 +1,7 @@
 package lisp
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
 )
@@ -28,3 +29,60 @@ func TestEnv(t *testing.T) {
 		t.Errorf("expected error setting t")
 	}
 }
+
+func TestEnvShadowingAndUpdate(t *testing.T) {
+	if intern("a") != intern("a") || intern("a") == intern("b") {
+		t.Fatal("symbols should be interned")
+	}
+	top := mkEnv(nil)
+	top.Set("a", Num(1))
+	child := mkEnv(&top)
+	child.Set("a", Num(2))
+	if v, _ := child.Lookup("a"); !v.Equal(Num(2)) {
+		t.Errorf("expected child binding to shadow, got %s", v)
+	}
+	if err := child.Update("a", Num(3)); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := top.Lookup("a"); !v.Equal(Num(1)) {
+		t.Errorf("expected top-level binding to be unchanged, got %s", v)
+	}
+	if err := child.SetTopLevel("c", Num(4)); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := top.Lookup("c"); !ok || !v.Equal(Num(4)) {
+		t.Errorf("expected c to be set at the top level, got %v", v)
+	}
+	if err := child.Update("never-bound", Num(5)); err == nil {
+		t.Errorf("expected error updating unbound symbol")
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	top := mkEnv(nil)
+	// The builtins are in the same table as the other global values:
+	if v, _ := top.Lookup("car"); v != builtins["car"] {
+		t.Errorf("car is %v", v)
+	}
+	top.Set("car", Num(1))
+	if v, _ := top.Lookup("car"); !v.Equal(Num(1)) {
+		t.Errorf("car is %v after setting it", v)
+	}
+	other := mkEnv(nil)
+	if v, _ := other.Lookup("car"); v != builtins["car"] {
+		t.Errorf("car is %v in another environment", v)
+	}
+	// The table grows by more than it needs to, so that binding new names
+	// doesn't copy it every time:
+	grown := 0
+	last := len(*top.globals.vals.Load())
+	for i := 0; i < 1000; i++ {
+		top.Set(fmt.Sprintf("test-globals-%d", i), Num(i))
+		if n := len(*top.globals.vals.Load()); n != last {
+			grown, last = grown+1, n
+		}
+	}
+	if grown > 10 {
+		t.Errorf("the table grew %d times for 1000 names", grown)
+	}
+}
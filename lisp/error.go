@@ -6,26 +6,30 @@ import (
 )
 
 func extendWithList(carList *ConsCell, err error) error {
-	ret, ok := err.(*ConsCell)
-	if !ok {
+	if isEscape(err) {
 		return err
 	}
-	return Cons(carList, ret)
+	return asCondition(err).withFrame(carList)
 }
 
 func extendError(msg string, err error) error {
 	return extendWithList(stringsToList(strings.Split(msg, " ")...), err)
 }
 
-func startStacktrace(carList *ConsCell) error {
-	return Cons(carList, Nil)
-}
-
 func baseError(msg string) error {
-	return startStacktrace(stringsToList(strings.Split(msg, " ")...))
+	return typedError("error", Nil, msg)
 }
 
 func baseErrorf(format string, a ...interface{}) error {
-	return startStacktrace(stringsToList(
-		strings.Split(fmt.Sprintf(format, a...), " ")...))
+	return typedError("error", Nil, fmt.Sprintf(format, a...))
+}
+
+// typedError returns a condition of the given type, with the message split
+// into atoms, as for baseError.
+func typedError(typ string, data Sexpr, msg string) error {
+	return NewCondition(typ, stringsToList(strings.Split(msg, " ")...), data)
+}
+
+func typedErrorf(typ string, data Sexpr, format string, a ...interface{}) error {
+	return typedError(typ, data, fmt.Sprintf(format, a...))
 }
@@ -28,7 +28,7 @@ func TestStackTrace(t *testing.T) {
 	inner3 := func() error {
 		return extendWithList(list(Atom{"outerError"}), inner2())
 	}
-	err := inner3().(*ConsCell)
+	err := inner3().(*Condition)
 	if err == nil {
 		t.Error("expected error")
 	}
@@ -36,7 +36,7 @@ func TestStackTrace(t *testing.T) {
 		t.Error("wrong error message:", err.Error())
 	}
 	// Ensure we can pick apart the stacktrace
-	if !err.car.Equal(list(Atom{"outerError"})) {
+	if !err.Trace().car.Equal(list(Atom{"outerError"})) {
 		t.Error("incorrect car for error message:", err.Error())
 	}
 }
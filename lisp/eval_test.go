@@ -42,6 +42,69 @@ func TestEval(t *testing.T) {
 		{ECases(S("'foo", "foo", OK))},
 		{ECases(S("(len (split '水果))", "2", OK))},
 		{Cases(S("'123", "123", OK))},
+		// Rationals and floats:
+		{ECases(S("(/ 1 3)", "1/3", OK))},
+		{Cases(S("(/ 6 3)", "2", OK))},
+		{ECases(S("(+ 1/3 2/3)", "1", OK))},
+		{ECases(S("(* 1.5 2)", "3.0", OK))},
+		{Cases(S("(+ 1/2 0.25)", "0.75", OK))},
+		{Cases(S("(= 1 1.0 2/2)", "t", OK))},
+		{Cases(S("(< 1/3 0.34 1/2)", "t", OK))},
+		{Cases(S("(/ 1 0.0)", "", "division by zero"))},
+		{Cases(S("(rem 1.5 2)", "", "rem expects integers"))},
+		{Cases(S("(floor -7/2)", "-4", OK))},
+		{Cases(S("(round 2.5)", "3", OK))},
+		{Cases(S("(numerator 0.5)", "", "is not an exact number"))},
+		{Cases(S("(float 1/8)", "0.125", OK))},
+		// Hash maps:
+		{ECases(S("{'a 1 'b (+ 1 1)}", "{a 1 b 2}", OK))},
+		{Cases(S("'{a (+ 1 1)}", "{a (+ 1 1)}", OK))},
+		{ECases(S("(hash-get (hash-put {} '(1 2) 'x) (list 1 2))", "x", OK))},
+		{Cases(S("(= {'a 1 'b 2} {'b 2 'a 1})", "t", OK))},
+		{Cases(S("(= {'a 1} {'a 2})", "()", OK))},
+		{Cases(S("(hash-keys (hash-del {1 'one 2 'two 3 'three} 2))", "(1 3)", OK))},
+		{Cases(S("(let ((x 3)) `{a ~x})", "{a 3}", OK))},
+		{Cases(S("{(lambda ()) 1}", "", "cannot be used as a hash key"))},
+		{Cases(S("(hash-get '(a 1) 'a)", "", "is not a hash map"))},
+		// Conditions:
+		{ECases(S("(try (/ 1 0) (catch (division-by-zero e) (condition-type e)))", "division-by-zero", OK))},
+		{Cases(S("(try (car 1) (catch (division-by-zero e) 1))", "", "is not a list"))},
+		{ECases(S("(try (raise 'oops '(it broke) 42) (catch e (condition-data e)))", "42", OK))},
+		{Cases(S("(raise 'oops '(it broke))", "", "(it broke)"))},
+		{Cases(S("(try 1 (finally (car 1)))", "", "finally clause"))},
+		{Cases(S("(try 1 (catch 1 2))", "", "catch binding name must be a symbol"))},
+		{Cases(S("(try 1 (catch (e) 2))", "", "catch requires condition types and a binding name"))},
+		// Vectors:
+		{ECases(S("['a (+ 1 1) [3]]", "[a 2 [3]]", OK))},
+		{Cases(S("'[a (+ 1 1)]", "[a (+ 1 1)]", OK))},
+		{Cases(S("(= [1 [2]] [1 [2]])", "t", OK))},
+		{Cases(S("(= [1 2] '(1 2))", "()", OK))},
+		{ECases(S("(let ((v [1 2])) (vset! v 0 'x) (vpush! v 3) v)", "[x 2 3]", OK))},
+		{Cases(S("(vget [1 2] 2)", "", "index 2 out of range for vector of length 2"))},
+		{Cases(S("(vget [1 2] 1/2)", "", "is not an integer"))},
+		{Cases(S("(subvec [1 2 3] 2 1)", "", "start 2 is after end 1"))},
+		{Cases(S("(vlen '(1 2))", "", "is not a vector"))},
+		{Cases(S("(map inc [1 2 3])", "[2 3 4]", OK))},
+		{Cases(S("(apply + 1 [2 3])", "6", OK))},
+		{Cases(S("(let ((x 3) (l '(4 5))) `[a ~x ~@l])", "[a 3 4 5]", OK))},
+		// Strings:
+		{ECases(S(`"Hello, world"`, `"Hello, world"`, OK))},
+		{Cases(S(`"a \"quoted\" word"`, `"a \"quoted\" word"`, OK))},
+		{ECases(S(`(str "abc" 'def 1 '(2 3))`, `"abcdef1(2 3)"`, OK))},
+		{Cases(S(`(= "abc" "abc")`, "t", OK))},
+		{Cases(S(`(= "abc" 'abc)`, "()", OK))},
+		{Cases(S(`(len "水果")`, "2", OK))},
+		{Cases(S(`(split "ab")`, `("a" "b")`, OK))},
+		{Cases(S(`(upcase "abc")`, `"ABC"`, OK))},
+		{Cases(S(`(substring "hello" 1 3)`, `"el"`, OK))},
+		{Cases(S(`(substring "水果" 1)`, `"果"`, OK))},
+		{Cases(S(`(substring "hello" 4 9)`, "", "out of range"))},
+		{Cases(S(`(string-index "水果" "果")`, "1", OK))},
+		{Cases(S(`(string->number "-12")`, "-12", OK))},
+		{Cases(S(`(string->number "12x")`, "", "is not a number"))},
+		{Cases(S(`(string->atom "abc")`, "abc", OK))},
+		{Cases(S(`(sort '("b" "c" "a"))`, `("a" "b" "c")`, OK))},
+		{Cases(S(`"unterminated`, "", "unterminated string"))},
 		{Cases(S("'bar", "bar", OK))},
 		{Cases(S("(quote (((1 2 3))))", "(((1 2 3)))", OK))},
 		{ECases(S("(quote (the (ten (laws (of (greenspun))))))", "(the (ten (laws (of (greenspun)))))", OK))},
@@ -0,0 +1,385 @@
+package lisp
+
+import "strings"
+
+// expander expands the macro calls throughout a form, as `macroexpand-all`
+// does, or one at a time, for stepping through the expansion.  It knows
+// which parts of each special form are code, and which are data (such as
+// quoted forms, and the names bound by `let` and `lambda`); the names bound
+// locally shadow macros of the same names, as they do when code is
+// evaluated.
+type expander struct {
+	env *Env
+	// Whether to stop after expanding one macro call:
+	step bool
+	// The name of the macro expanded, once one has been, when stepping:
+	macro string
+	err   error
+	// Whether to qualify the functions templates call instead of expanding
+	// macro calls (see qualifyTemplates), and whether in a template:
+	qualify, inTemplate bool
+}
+
+// macroexpandAll expands all the macro calls in x.
+func macroexpandAll(x Sexpr, e *Env) (Sexpr, error) {
+	ex := &expander{env: e}
+	ret := ex.expand(x, nil)
+	if ex.err != nil {
+		return nil, extendError("macroexpand-all", ex.err)
+	}
+	return ret, nil
+}
+
+// qualifyTemplates returns the body of a macro defined in e, outside any
+// module, with the atoms its templates call as functions qualified as
+// l1/name, where they name functions defined at the top level or builtins.
+// The code the macro expands to then calls those functions, even where
+// they're shadowed by local bindings.  Atoms elsewhere, such as the names
+// bound by `let` and `lambda`, the names of macros, and quoted data, are
+// left as they are, as are the calls of functions defined after the macro.
+func qualifyTemplates(body *ConsCell, e *Env) *ConsCell {
+	ex := &expander{env: e, qualify: true}
+	forms, err := consToExprs(body)
+	if err != nil {
+		return body
+	}
+	return list(ex.expandEach(forms, nil)...)
+}
+
+// qualified returns the name the function called by l, in a template,
+// is qualified as, if it's to be qualified.
+func (ex *expander) qualified(l *ConsCell, locals []string) (Atom, bool) {
+	a, ok := l.car.(Atom)
+	if !ok || contains(locals, a.s) || strings.Contains(a.s, "/") {
+		return a, false
+	}
+	name, ok := lookupSymbol(a.s)
+	if !ok {
+		return a, false
+	}
+	switch f := ex.env.globals.get(name).(type) {
+	case *lambdaFn:
+		if f.isMacro {
+			return a, false
+		}
+	case *Builtin:
+	default:
+		return a, false
+	}
+	return Atom{topLevel + "/" + a.s}, true
+}
+
+// ExpansionStep is a step in the expansion of the macro calls in a form.
+type ExpansionStep struct {
+	Macro string // the name of the macro whose call was expanded
+	Form  Sexpr  // the whole form, after the call was expanded
+}
+
+// ExpansionSteps expands the macro calls in x one at a time, outermost
+// first, returning the form after each step.
+func (i *Interpreter) ExpansionSteps(x Sexpr) ([]ExpansionStep, error) {
+	steps := []ExpansionStep{}
+	for {
+		ex := &expander{env: &i.globals, step: true}
+		x = ex.expand(x, nil)
+		if ex.err != nil {
+			return steps, ex.err
+		}
+		if ex.macro == "" {
+			return steps, nil
+		}
+		steps = append(steps, ExpansionStep{ex.macro, x})
+	}
+}
+
+func (ex *expander) done() bool {
+	return ex.err != nil || ex.macro != ""
+}
+
+// expand expands the macro calls in the code x, where the names in locals
+// are bound.
+func (ex *expander) expand(x Sexpr, locals []string) Sexpr {
+	if ex.done() {
+		return x
+	}
+	switch t := x.(type) {
+	case *Vector:
+		return &Vector{ex.expandEach(t.items, locals)}
+	case *HashMap:
+		ret := mkHashMap()
+		for _, entry := range t.entries() {
+			if err := ret.set(ex.expand(entry.key, locals), ex.expand(entry.val, locals)); err != nil {
+				ex.err = err
+				return x
+			}
+		}
+		return ret
+	case *ConsCell:
+		if t == Nil {
+			return t
+		}
+		if ex.qualify {
+			return ex.expandForm(t, locals)
+		}
+		if name, ok := ex.macroCall(t, locals); ok {
+			var err error
+			if ex.step {
+				x, err = macroexpand1(t, ex.env)
+				ex.macro = name
+			} else {
+				x, err = macroexpand(t, ex.env)
+			}
+			if err != nil {
+				ex.err = err
+				return t
+			}
+			if ex.step {
+				return x
+			}
+			return ex.expand(x, locals)
+		}
+		return ex.expandForm(t, locals)
+	default:
+		return x
+	}
+}
+
+// macroCall returns the name of the macro l calls, if it calls one.
+func (ex *expander) macroCall(l *ConsCell, locals []string) (string, bool) {
+	a, ok := l.car.(Atom)
+	if !ok || contains(locals, a.s) || !isMacroCall(l, ex.env) {
+		return "", false
+	}
+	return a.s, true
+}
+
+func contains(names []string, s string) bool {
+	for _, name := range names {
+		if name == s {
+			return true
+		}
+	}
+	return false
+}
+
+// expandForm expands the macro calls in the parts of the special form or
+// function call l which are code.
+func (ex *expander) expandForm(l *ConsCell, locals []string) Sexpr {
+	items, err := consToExprs(l)
+	if err != nil {
+		// A dotted list is not code; leave it as it is:
+		return l
+	}
+	head, _ := l.car.(Atom)
+	switch head.s {
+	case "quote", "export", "import":
+		return l
+	case "syntax-quote":
+		if len(items) != 2 {
+			return l
+		}
+		if ex.qualify {
+			if ex.inTemplate {
+				return l
+			}
+			// The template's code is bound where it's used, not here:
+			ex.inTemplate = true
+			defer func() { ex.inTemplate = false }()
+			return list(items[0], ex.expand(items[1], nil))
+		}
+		return list(items[0], ex.expandTemplate(items[1], locals))
+	case "unquote", "splicing-unquote":
+		if ex.qualify && ex.inTemplate {
+			// The macro's own code, which may hold templates of its own:
+			ex.inTemplate = false
+			defer func() { ex.inTemplate = true }()
+			return ex.expandFrom(1, items, nil)
+		}
+	case "def", "set!":
+		return ex.expandFrom(2, items, locals)
+	case "cond":
+		for i, clause := range items[1:] {
+			if c, ok := clause.(*ConsCell); ok {
+				if clauseItems, err := consToExprs(c); err == nil {
+					items[i+1] = list(ex.expandEach(clauseItems, locals)...)
+				}
+			}
+		}
+		return list(items...)
+	case "let":
+		if len(items) < 2 {
+			return l
+		}
+		bindings, err := consToExprs(items[1])
+		if err != nil {
+			return l
+		}
+		inner := locals
+		for i, b := range bindings {
+			pair, err := consToExprs(b)
+			if err != nil || len(pair) == 0 {
+				continue
+			}
+			bindings[i] = list(append(pair[:1:1], ex.expandEach(pair[1:], locals)...)...)
+			inner = bind(inner, pair[0])
+		}
+		items[1] = list(bindings...)
+		return ex.expandFrom(2, items, inner)
+	case "lambda":
+		start := 1
+		inner := locals
+		if len(items) > 1 {
+			if name, ok := items[1].(Atom); ok {
+				inner = bind(inner, name)
+				start = 2
+			}
+		}
+		return ex.expandLambda(start, items, inner)
+	case "defn", "defmacro":
+		return ex.expandLambda(2, items, locals)
+	case "try":
+		for i, clause := range items[1:] {
+			c, ok := clause.(*ConsCell)
+			switch {
+			case ok && listStartsWith(c, "catch"):
+				clauseItems, err := consToExprs(c)
+				if err != nil || len(clauseItems) < 2 {
+					continue
+				}
+				// The binding name is alone, or last in a list of
+				// condition types:
+				binding := clauseItems[1]
+				if spec, err := consToExprs(binding); err == nil && len(spec) > 0 {
+					binding = spec[len(spec)-1]
+				}
+				inner := bind(locals, binding)
+				items[i+1] = list(append(clauseItems[:2:2], ex.expandEach(clauseItems[2:], inner)...)...)
+			case ok && listStartsWith(c, "finally"):
+				if clauseItems, err := consToExprs(c); err == nil {
+					items[i+1] = ex.expandFrom(1, clauseItems, locals)
+				}
+			default:
+				items[i+1] = ex.expand(clause, locals)
+			}
+		}
+		return list(items...)
+	case "module":
+		return ex.expandFrom(2, items, locals)
+	case "error", "errors", "raise", "rethrow", "test", "and", "or",
+		"loop", "swallow":
+		return ex.expandFrom(1, items, locals)
+	}
+	if ex.inTemplate {
+		if a, ok := ex.qualified(l, locals); ok {
+			items[0] = a
+		}
+	}
+	return list(ex.expandEach(items, locals)...)
+}
+
+// expandLambda expands the body of a lambda, defn or defmacro form, whose
+// argument list is items[start], leaving any doc form as it is.
+func (ex *expander) expandLambda(start int, items []Sexpr, locals []string) Sexpr {
+	if len(items) <= start {
+		return list(items...)
+	}
+	inner := locals
+	items[start], inner = ex.expandArgs(items[start], locals)
+	body := start + 1
+	if len(items) > body && isDocForm(items[body]) {
+		body++
+	}
+	return ex.expandFrom(body, items, inner)
+}
+
+// expandArgs expands the defaults of the &optional and &key parameters in
+// the argument list args, returning it with locals plus the names it binds.
+func (ex *expander) expandArgs(args Sexpr, locals []string) (Sexpr, []string) {
+	c, ok := args.(*ConsCell)
+	if !ok {
+		return args, bind(locals, args)
+	}
+	items := []Sexpr{}
+	optional := false
+	for c != Nil {
+		item := c.car
+		if a, ok := item.(Atom); ok && (a.s == "&optional" || a.s == "&key") {
+			optional = true
+		} else if p, ok := item.(*ConsCell); ok && optional {
+			if pair, err := consToExprs(p); err == nil && len(pair) == 2 {
+				item = list(pair[0], ex.expand(pair[1], locals))
+			}
+			locals = bind(locals, p.car)
+		} else {
+			locals = bind(locals, item)
+		}
+		items = append(items, item)
+		next, ok := c.cdr.(*ConsCell)
+		if !ok {
+			return combineArgs(list(items...), c.cdr), bind(locals, c.cdr)
+		}
+		c = next
+	}
+	return list(items...), locals
+}
+
+func isDocForm(x Sexpr) bool {
+	c, ok := x.(*ConsCell)
+	return ok && listStartsWith(c, "doc")
+}
+
+// expandFrom expands the items of a form from the start'th on.
+func (ex *expander) expandFrom(start int, items []Sexpr, locals []string) Sexpr {
+	if len(items) <= start {
+		return list(items...)
+	}
+	return list(append(items[:start:start], ex.expandEach(items[start:], locals)...)...)
+}
+
+func (ex *expander) expandEach(xs []Sexpr, locals []string) []Sexpr {
+	ret := make([]Sexpr, len(xs))
+	for i, x := range xs {
+		ret[i] = ex.expand(x, locals)
+	}
+	return ret
+}
+
+// expandTemplate expands the macro calls in the unquoted parts of a
+// syntax-quoted template.
+func (ex *expander) expandTemplate(x Sexpr, locals []string) Sexpr {
+	c, ok := x.(*ConsCell)
+	if !ok || c == Nil {
+		return x
+	}
+	items, err := consToExprs(c)
+	if err != nil {
+		return x
+	}
+	if listStartsWith(c, "unquote") || listStartsWith(c, "splicing-unquote") {
+		return ex.expandFrom(1, items, locals)
+	}
+	for i, item := range items {
+		items[i] = ex.expandTemplate(item, locals)
+	}
+	return list(items...)
+}
+
+// bind returns locals with the names in x, an atom or an argument list,
+// added.
+func bind(locals []string, x Sexpr) []string {
+	ret := locals[:len(locals):len(locals)]
+	var walk func(Sexpr)
+	walk = func(x Sexpr) {
+		switch t := x.(type) {
+		case Atom:
+			ret = append(ret, t.s)
+		case *ConsCell:
+			if t != Nil {
+				walk(t.car)
+				walk(t.cdr)
+			}
+		}
+	}
+	walk(x)
+	return ret
+}
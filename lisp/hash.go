@@ -0,0 +1,393 @@
+package lisp
+
+import (
+	"hash/maphash"
+	"math"
+	"math/big"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HashMap is an immutable mapping from keys to values, written
+// `{k1 v1 k2 v2 ...}`.  Keys may be atoms, numbers, strings, or lists of
+// these; keys which are Equal refer to the same entry.  Entries are kept in
+// the order in which they were first added.
+//
+// The entries are kept in a hash array mapped trie, indexed by the hash of
+// their hashKey, so that Put and Del make a new map by copying the few
+// nodes on the path to the entry, sharing the rest with the old one.
+type HashMap struct {
+	root *hamtNode
+	n    int
+	seq  uint64 // the order of the next entry added
+}
+
+type hashEntry struct {
+	key, val Sexpr
+	hk       string
+	seq      uint64 // the order of the entry, among those of its map
+}
+
+// hamtNode is a node of the trie.  Each of its children, *hamtNodes or
+// *hamtBuckets, is for hashes with a different value of the hamtBits bits
+// the node is at; bitmap has a bit set for each value there's a child for.
+type hamtNode struct {
+	bitmap   uint32
+	children []any
+}
+
+// hamtBucket holds the entries whose keys have the same hash.
+type hamtBucket struct {
+	hash    uint64
+	entries []hashEntry
+}
+
+const hamtBits = 5
+
+var hashSeed = maphash.MakeSeed()
+
+func mkHashMap() *HashMap {
+	return &HashMap{}
+}
+
+// hashKey returns a string which is the same for all keys which are Equal to
+// k, and different for all others.
+func hashKey(k Sexpr) (string, error) {
+	var sb strings.Builder
+	if err := writeHashKey(&sb, k); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func writeHashKey(sb *strings.Builder, k Sexpr) error {
+	switch t := k.(type) {
+	case Atom:
+		// Atoms made with `fuse` or `string->atom` can have any name, so
+		// quote it:
+		sb.WriteString("a")
+		sb.WriteString(strconv.Quote(t.s))
+	case String:
+		sb.WriteString("s")
+		sb.WriteString(strconv.Quote(t.s))
+	case Number:
+		// Numbers of different kinds which are Equal must have the same
+		// key, so floats are written as exact rationals:
+		sb.WriteString("n")
+		if t.kind != floatNum {
+			sb.WriteString(t.toRat().RatString())
+			break
+		}
+		if math.IsNaN(t.fl) {
+			return typedError("type-error", k, "NaN cannot be used as a hash key")
+		}
+		if math.IsInf(t.fl, 0) {
+			sb.WriteString(t.String())
+			break
+		}
+		sb.WriteString(new(big.Rat).SetFloat64(t.fl).RatString())
+	case *ConsCell:
+		sb.WriteString("(")
+		var l Sexpr = t
+		for l != Nil {
+			c, ok := l.(*ConsCell)
+			if !ok {
+				sb.WriteString(" . ")
+				if err := writeHashKey(sb, l); err != nil {
+					return err
+				}
+				break
+			}
+			if c != t {
+				sb.WriteString(" ")
+			}
+			if err := writeHashKey(sb, c.car); err != nil {
+				return err
+			}
+			l = c.cdr
+		}
+		sb.WriteString(")")
+	default:
+		return typedErrorf("type-error", k, "%s cannot be used as a hash key", k)
+	}
+	return nil
+}
+
+// hamtBit returns the bit for hash in the bitmap of a node at shift.
+func hamtBit(hash uint64, shift uint) uint32 {
+	return uint32(1) << ((hash >> shift) & (1<<hamtBits - 1))
+}
+
+// pos returns where the child for bit is, or would be, in n's children.
+func (n *hamtNode) pos(bit uint32) int {
+	return bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+// with returns a copy of n with child at i replaced by c.
+func (n *hamtNode) with(i int, c any) *hamtNode {
+	children := make([]any, len(n.children))
+	copy(children, n.children)
+	children[i] = c
+	return &hamtNode{n.bitmap, children}
+}
+
+// put returns a copy of n, which is at shift, with e added for hash; it
+// returns whether e's key is new, rather than replacing an entry.
+func (n *hamtNode) put(shift uint, hash uint64, e hashEntry) (*hamtNode, bool) {
+	bit := hamtBit(hash, shift)
+	i := n.pos(bit)
+	if n.bitmap&bit == 0 {
+		children := make([]any, len(n.children)+1)
+		copy(children, n.children[:i])
+		children[i] = &hamtBucket{hash, []hashEntry{e}}
+		copy(children[i+1:], n.children[i:])
+		return &hamtNode{n.bitmap | bit, children}, true
+	}
+	switch c := n.children[i].(type) {
+	case *hamtNode:
+		child, added := c.put(shift+hamtBits, hash, e)
+		return n.with(i, child), added
+	case *hamtBucket:
+		if c.hash != hash {
+			// Move the bucket down a level, where the hashes differ, or
+			// will further down:
+			cbit := hamtBit(c.hash, shift+hamtBits)
+			sub := &hamtNode{cbit, []any{c}}
+			child, added := sub.put(shift+hamtBits, hash, e)
+			return n.with(i, child), added
+		}
+		entries := make([]hashEntry, len(c.entries), len(c.entries)+1)
+		copy(entries, c.entries)
+		added := true
+		for j, old := range entries {
+			if old.hk == e.hk {
+				e.seq = old.seq
+				entries[j] = e
+				added = false
+				break
+			}
+		}
+		if added {
+			entries = append(entries, e)
+		}
+		return n.with(i, &hamtBucket{hash, entries}), added
+	}
+	panic("bad hash map node")
+}
+
+// get returns the entry with hashKey hk, whose hash is hash, if any.
+func (n *hamtNode) get(shift uint, hash uint64, hk string) (hashEntry, bool) {
+	for n != nil {
+		bit := hamtBit(hash, shift)
+		if n.bitmap&bit == 0 {
+			break
+		}
+		switch c := n.children[n.pos(bit)].(type) {
+		case *hamtNode:
+			n, shift = c, shift+hamtBits
+			continue
+		case *hamtBucket:
+			for _, e := range c.entries {
+				if e.hk == hk {
+					return e, true
+				}
+			}
+		}
+		break
+	}
+	return hashEntry{}, false
+}
+
+// del returns a copy of n without the entry with hashKey hk, or nil if it
+// would be empty.  n must have the entry.
+func (n *hamtNode) del(shift uint, hash uint64, hk string) *hamtNode {
+	bit := hamtBit(hash, shift)
+	i := n.pos(bit)
+	var child any
+	switch c := n.children[i].(type) {
+	case *hamtNode:
+		if sub := c.del(shift+hamtBits, hash, hk); sub != nil {
+			child = sub
+		}
+	case *hamtBucket:
+		entries := make([]hashEntry, 0, len(c.entries))
+		for _, e := range c.entries {
+			if e.hk != hk {
+				entries = append(entries, e)
+			}
+		}
+		if len(entries) > 0 {
+			child = &hamtBucket{hash, entries}
+		}
+	}
+	if child != nil {
+		return n.with(i, child)
+	}
+	if len(n.children) == 1 {
+		return nil
+	}
+	children := make([]any, 0, len(n.children)-1)
+	children = append(children, n.children[:i]...)
+	children = append(children, n.children[i+1:]...)
+	return &hamtNode{n.bitmap &^ bit, children}
+}
+
+// each calls f for each entry under n, in no particular order.
+func (n *hamtNode) each(f func(hashEntry)) {
+	if n == nil {
+		return
+	}
+	for _, c := range n.children {
+		switch c := c.(type) {
+		case *hamtNode:
+			c.each(f)
+		case *hamtBucket:
+			for _, e := range c.entries {
+				f(e)
+			}
+		}
+	}
+}
+
+// set adds or replaces an entry in h, which must not be visible to l1 code
+// yet (HashMaps are immutable once created).
+func (h *HashMap) set(k, v Sexpr) error {
+	hk, err := hashKey(k)
+	if err != nil {
+		return err
+	}
+	root := h.root
+	if root == nil {
+		root = &hamtNode{}
+	}
+	root, added := root.put(0, maphash.String(hashSeed, hk), hashEntry{k, v, hk, h.seq})
+	h.root = root
+	if added {
+		h.n++
+		h.seq++
+	}
+	return nil
+}
+
+// Get returns the value for key k, and whether there was one.
+func (h *HashMap) Get(k Sexpr) (Sexpr, bool) {
+	hk, err := hashKey(k)
+	if err != nil {
+		return nil, false
+	}
+	entry, ok := h.root.get(0, maphash.String(hashSeed, hk), hk)
+	return entry.val, ok
+}
+
+// Put returns a new HashMap with k set to v.
+func (h *HashMap) Put(k, v Sexpr) (*HashMap, error) {
+	ret := *h
+	if err := ret.set(k, v); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// Del returns a new HashMap without any entry for k.
+func (h *HashMap) Del(k Sexpr) *HashMap {
+	hk, err := hashKey(k)
+	if err != nil {
+		return h
+	}
+	hash := maphash.String(hashSeed, hk)
+	if _, ok := h.root.get(0, hash, hk); !ok {
+		return h
+	}
+	return &HashMap{h.root.del(0, hash, hk), h.n - 1, h.seq}
+}
+
+// entries returns the entries of h, in the order they were added.
+func (h *HashMap) entries() []hashEntry {
+	ret := make([]hashEntry, 0, h.n)
+	h.root.each(func(e hashEntry) {
+		ret = append(ret, e)
+	})
+	sort.Slice(ret, func(i, j int) bool { return ret[i].seq < ret[j].seq })
+	return ret
+}
+
+// Len returns the number of entries in h.
+func (h *HashMap) Len() int {
+	return h.n
+}
+
+// Keys returns the keys of h, in the order they were added.
+func (h *HashMap) Keys() []Sexpr {
+	entries := h.entries()
+	ret := make([]Sexpr, len(entries))
+	for i, entry := range entries {
+		ret[i] = entry.key
+	}
+	return ret
+}
+
+// Vals returns the values of h, in the same order as Keys.
+func (h *HashMap) Vals() []Sexpr {
+	entries := h.entries()
+	ret := make([]Sexpr, len(entries))
+	for i, entry := range entries {
+		ret[i] = entry.val
+	}
+	return ret
+}
+
+// String returns the map in the same `{k1 v1 ...}` form used to write it.
+func (h *HashMap) String() string {
+	parts := make([]string, 0, 2*h.n)
+	for _, entry := range h.entries() {
+		parts = append(parts, entry.key.String(), entry.val.String())
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
+
+// Equal returns true if o is a HashMap with Equal values for the same keys,
+// regardless of order.
+func (h *HashMap) Equal(o Sexpr) bool {
+	o2, ok := o.(*HashMap)
+	if !ok || h.n != o2.n {
+		return false
+	}
+	equal := true
+	h.root.each(func(entry hashEntry) {
+		other, ok := o2.root.get(0, maphash.String(hashSeed, entry.hk), entry.hk)
+		if !ok || !entry.val.Equal(other.val) {
+			equal = false
+		}
+	})
+	return equal
+}
+
+// evalHashMap evaluates the keys and values of a map literal.
+func evalHashMap(h *HashMap, e *Env) (Sexpr, error) {
+	ret := mkHashMap()
+	for _, entry := range h.entries() {
+		k, err := eval(entry.key, e)
+		if err != nil {
+			return nil, err
+		}
+		v, err := eval(entry.val, e)
+		if err != nil {
+			return nil, err
+		}
+		if err := ret.set(k, v); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// hashArg returns the HashMap argument of a builtin.
+func hashArg(arg Sexpr) (*HashMap, error) {
+	h, ok := arg.(*HashMap)
+	if !ok {
+		return nil, typedErrorf("type-error", arg, "'%s' is not a hash map", arg)
+	}
+	return h, nil
+}
@@ -0,0 +1,137 @@
+package lisp
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHashKeys(t *testing.T) {
+	var tests = []struct {
+		a, b Sexpr
+		same bool
+	}{
+		{Atom{"a"}, Atom{"a"}, true},
+		{Atom{"a"}, Atom{"b"}, false},
+		{Atom{"a"}, String{"a"}, false},
+		{Atom{"1"}, Num(1), false},
+		{Num(1), Num(1.0), true},
+		{Num("1/2"), Num(0.5), true},
+		{Num("1/3"), Num(0.3333333333333333), false},
+		{list(Atom{"a"}, Num(1)), list(Atom{"a"}, Num(1)), true},
+		{list(Atom{"a"}, Num(1)), list(Atom{"a b"}), false},
+		{Cons(Atom{"a"}, Atom{"b"}), list(Atom{"a"}, Atom{"b"}), false},
+		{Nil, list(Nil), false},
+		{String{`a" "b`}, list(String{"a"}, String{"b"}), false},
+	}
+	for _, test := range tests {
+		ka, err := hashKey(test.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kb, err := hashKey(test.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (ka == kb) != test.same {
+			t.Errorf("hashKey(%s) = %q, hashKey(%s) = %q; want same: %v",
+				test.a, ka, test.b, kb, test.same)
+		}
+		if test.a.Equal(test.b) != test.same {
+			t.Errorf("%s.Equal(%s) != %v", test.a, test.b, test.same)
+		}
+	}
+	for _, bad := range []Sexpr{mkHashMap(), Num(0.0).Div(Num(0.0)), list(&Builtin{Name: "x"})} {
+		if _, err := hashKey(bad); err == nil {
+			t.Errorf("hashKey(%s) should fail", bad)
+		}
+	}
+}
+
+func TestHashMapOps(t *testing.T) {
+	h := mkHashMap()
+	h2, err := h.Put(Atom{"a"}, Num(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h3, err := h2.Put(Num(2), String{"two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Len() != 0 || h2.Len() != 1 || h3.Len() != 2 {
+		t.Errorf("Put should not modify the original map: %s %s %s", h, h2, h3)
+	}
+	if got := h3.String(); got != `{a 1 2 "two"}` {
+		t.Errorf("got %s", got)
+	}
+	if v, ok := h3.Get(Num(2.0)); !ok || !v.Equal(String{"two"}) {
+		t.Errorf("Get(2.0) = %v, %v", v, ok)
+	}
+	h4 := h3.Del(Atom{"a"})
+	if h4.Len() != 1 || h3.Len() != 2 {
+		t.Errorf("Del: got %s from %s", h4, h3)
+	}
+	if h3.Del(Atom{"zzz"}) != h3 {
+		t.Error("deleting a missing key should not copy the map")
+	}
+	other, _ := mkHashMap().Put(Num(2), String{"two"})
+	other, _ = other.Put(Atom{"a"}, Num(1))
+	if !h3.Equal(other) || !other.Equal(h3) {
+		t.Errorf("%s and %s should be equal", h3, other)
+	}
+	if h3.Equal(h4) || h3.Equal(list(Atom{"a"}, Num(1))) {
+		t.Errorf("%s should not equal %s", h3, h4)
+	}
+}
+
+func TestHashMapMany(t *testing.T) {
+	const n = 5000
+	h := mkHashMap()
+	var err error
+	for i := 0; i < n; i++ {
+		if h, err = h.Put(Num(i), Num(i*i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Replacing an entry keeps its place:
+	if h, err = h.Put(Num(0), Atom{"zero"}); err != nil {
+		t.Fatal(err)
+	}
+	if h.Len() != n {
+		t.Fatalf("got %d entries, want %d", h.Len(), n)
+	}
+	for i, k := range h.Keys() {
+		if !k.Equal(Num(i)) {
+			t.Fatalf("key %d is %s", i, k)
+		}
+	}
+	for i := 1; i < n; i += 2 {
+		h = h.Del(Num(i))
+	}
+	if h.Len() != n/2 {
+		t.Fatalf("got %d entries after deleting, want %d", h.Len(), n/2)
+	}
+	for i := 0; i < n; i++ {
+		v, ok := h.Get(Num(i))
+		if ok != (i%2 == 0) || ok && i > 0 && !v.Equal(Num(i*i)) {
+			t.Fatalf("Get(%d) = %v, %v", i, v, ok)
+		}
+	}
+}
+
+// Building a map by reduction should take time proportional to its size:
+func BenchmarkFrequencies(b *testing.B) {
+	benchmarkSizes(b, "(frequencies l)")
+}
+
+func BenchmarkGroupBy(b *testing.B) {
+	benchmarkSizes(b, "(group-by even? l)")
+}
+
+// benchmarkSizes benchmarks expr on lists l of increasing size.
+func benchmarkSizes(b *testing.B, expr string) {
+	for _, n := range []int{1000, 4000, 16000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			benchmarkEval(b, fmt.Sprintf("(def l (range %d))", n), expr)
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package lisp
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Code is indented as Emacs' lisp-mode indents it, set up as in the Emacs
+// Integration section of intro.md: the elements of a list of data line up
+// with its first element; the arguments of a function call line up with
+// its first argument, if that's on the same line as the function, and
+// with the function otherwise; and the bodies of forms like `defn` and
+// `let` are indented two spaces past the paren, unless they start on the
+// same line as it.
+
+// indentSpecs give the number of arguments which come before the body, for
+// forms which have bodies, besides those whose names start with "def" (as
+// `defn` and `defmacro` do, but `def` doesn't).
+var indentSpecs = map[string]int{
+	"block":    1,
+	"catch":    1,
+	"dotimes":  1,
+	"errors":   1,
+	"foreach":  2,
+	"if":       1,
+	"if-not":   1,
+	"lambda":   1,
+	"let":      1,
+	"let*":     1,
+	"loop":     0,
+	"module":   1,
+	"progn":    0,
+	"swallow":  0,
+	"try":      0,
+	"test":     1,
+	"testing":  1,
+	"when":     1,
+	"when-not": 1,
+	"while":    1,
+}
+
+// indentation returns the number of spaces to indent a line following text
+// by.
+func indentation(text []rune) int {
+	_, open := scanParens(text)
+	if len(open) == 0 {
+		return 0
+	}
+	i := open[len(open)-1]
+	return elementIndent(text, i, column(text, i))
+}
+
+// column returns the column text[i] is in.
+func column(text []rune, i int) int {
+	col := 0
+	for j := i - 1; j >= 0 && text[j] != '\n'; j-- {
+		col += runewidth.RuneWidth(text[j])
+	}
+	return col
+}
+
+// elementIndent returns the indentation of the next element of the list
+// opened by text[i], which is in column col, and continues to the end of
+// text.
+func elementIndent(text []rune, i, col int) int {
+	if text[i] != '(' {
+		return col + 1
+	}
+	elems := listElements(text, i)
+	if len(elems) == 0 || !isWordRune(text[elems[0]]) || unicode.IsDigit(text[elems[0]]) {
+		return col + 1
+	}
+	j := elems[0]
+	for j < len(text) && isAtomRune(text[j]) {
+		j++
+	}
+	op := string(text[elems[0]:j])
+	args := elems[1:]
+	// sameLine returns the column of the nth argument, if it's on the same
+	// line as the paren, or -1:
+	sameLine := func(n int) int {
+		if n >= len(args) || strings.ContainsRune(string(text[i:args[n]]), '\n') {
+			return -1
+		}
+		return col + runewidth.StringWidth(string(text[i:args[n]]))
+	}
+	n, ok := indentSpecs[op]
+	isDef := strings.HasPrefix(op, "def") && op != "def"
+	if isDef {
+		// A name and arguments, as for defn:
+		n, ok = 2, true
+	}
+	if op == "lambda" && len(args) > 0 && isWordRune(text[args[0]]) {
+		// A named lambda:
+		n = 2
+	}
+	if ok {
+		switch {
+		case len(args) < n && sameLine(0) >= 0:
+			return sameLine(0)
+		case len(args) < n:
+			return col + 4
+		case sameLine(n) >= 0 && !isDef:
+			return sameLine(n)
+		}
+		return col + 2
+	}
+	if sameLine(0) >= 0 {
+		return sameLine(0)
+	}
+	return col + 1
+}
+
+// isAtomRune is true for the runes of atoms, including the # ending
+// those made unique in syntax-quoted templates.
+func isAtomRune(r rune) bool {
+	return isWordRune(r) || r == '#'
+}
+
+// listElements returns the positions in text at which the elements of the
+// list opened by text[i] start.
+func listElements(text []rune, i int) []int {
+	starts := []int{}
+	depth := 0
+	inAtom, inString, quoted := false, false, false
+	for j := i + 1; j < len(text); j++ {
+		r := text[j]
+		if inString {
+			if r == '\\' {
+				j++
+			} else if r == '"' || r == '\n' {
+				inString = false
+			}
+			continue
+		}
+		if inAtom {
+			if isAtomRune(r) {
+				continue
+			}
+			inAtom = false
+		}
+		if depth == 0 && !unicode.IsSpace(r) && !strings.ContainsRune(")]};", r) {
+			// A quote and what it quotes are one element:
+			if !quoted {
+				starts = append(starts, j)
+			}
+			quoted = strings.ContainsRune("'`~@", r)
+		}
+		switch {
+		case r == ';':
+			for j+1 < len(text) && text[j+1] != '\n' {
+				j++
+			}
+		case r == '"':
+			inString = true
+		case r == '(' || r == '[' || r == '{':
+			depth++
+		case r == ')' || r == ']' || r == '}':
+			depth--
+			if depth < 0 {
+				return starts
+			}
+		case isWordRune(r):
+			inAtom = true
+		}
+	}
+	return starts
+}
+
+// reindent indents each line of src as the REPL would have as it was typed,
+// and strips trailing whitespace.
+func reindent(src string) string {
+	out := []rune{}
+	// The positions in out of the parens of the lists open:
+	open := []int{}
+	for n, line := range strings.Split(src, "\n") {
+		if n > 0 {
+			out = append(out, '\n')
+		}
+		line = strings.TrimSpace(line)
+		if len(open) > 0 && line != "" {
+			i := open[len(open)-1]
+			line = strings.Repeat(" ", elementIndent(out, i, column(out, i))) + line
+		}
+		start := len(out)
+		out = append(out, []rune(line)...)
+		inString := false
+	scan:
+		for i := start; i < len(out); i++ {
+			r := out[i]
+			switch {
+			case inString:
+				if r == '\\' {
+					i++
+				} else if r == '"' {
+					inString = false
+				}
+			case r == '"':
+				inString = true
+			case r == ';':
+				break scan
+			case r == '(' || r == '[' || r == '{':
+				open = append(open, i)
+			case r == ')' || r == ']' || r == '}':
+				if len(open) > 0 {
+					open = open[:len(open)-1]
+				}
+			}
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,57 @@
+package lisp
+
+import "testing"
+
+func TestIndentation(t *testing.T) {
+	var tests = []struct {
+		text string
+		want int
+	}{
+		{"(+ 1 2)", 0},
+		{"(defn f (x)", 2},
+		{"(let ((a 1)", 6},
+		{"(let ((a 1)\n      (b [1", 10},
+		{`(print "(" ; (`, 7},
+		{"  (foo", 3},
+		{"(foo a", 5},
+		{"(if (> x 1)", 2},
+		{"(try (car 1)", 5},
+		{"(lambda f (x)", 2},
+		{"(defn f\n  (x) (doc (f))", 2},
+	}
+	for _, test := range tests {
+		if got := indentation([]rune(test.text)); got != test.want {
+			t.Errorf("%q: got %d, want %d", test.text, got, test.want)
+		}
+	}
+}
+
+func TestReindent(t *testing.T) {
+	src := `(defn f (x)
+(let ((a 1)
+(b "(" ))
+        ;; comment
+   (list a
+b [1
+2])
+(try (car x)
+(catch e
+(concat
+'(oops) a)))))
+   (f 1)   `
+	want := `(defn f (x)
+  (let ((a 1)
+        (b "(" ))
+    ;; comment
+    (list a
+          b [1
+             2])
+    (try (car x)
+         (catch e
+           (concat
+            '(oops) a)))))
+(f 1)`
+	if got := reindent(src); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
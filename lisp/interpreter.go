@@ -0,0 +1,195 @@
+package lisp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Interpreter is an l1 interpreter for use by Go programs.  It owns a global
+// environment with the core library already loaded, and the streams used by
+// builtins which read or print (`readlist`, `print`, `test`, etc.).
+type Interpreter struct {
+	globals Env
+	stdin   *bufio.Reader
+	stdinMu sync.Mutex // held while reading a line, since tasks may read at once
+	stdout  io.Writer
+	stderr  io.Writer
+	// Where the evaluation running prints, instead of stdout and stderr,
+	// if its output is being captured (as by a Server):
+	out atomic.Pointer[captureWriter]
+	// Whether to evaluate with the tree-walker rather than the compiler
+	// and VM; see withTreeWalker:
+	treeWalk bool
+	// Limits on evaluation, if any; see WithMaxSteps, etc.:
+	limits *limits
+	// The capabilities builtins may use; see WithPolicy:
+	policy Policy
+	// Directories to search for modules' files; see WithSearchPath:
+	searchPath []string
+	// The global values once the core library was loaded, so those set
+	// since can be listed at the REPL:
+	core map[symbol]*globalVal
+	// The files loaded at the REPL with :load, for :reload:
+	replFiles []string
+}
+
+// Option configures an Interpreter; see NewInterpreter.
+type Option func(*Interpreter)
+
+// WithStdin sets the reader used by builtins which read input.
+func WithStdin(r io.Reader) Option {
+	return func(i *Interpreter) {
+		i.stdin = bufio.NewReader(r)
+	}
+}
+
+// WithStdout sets the writer used by builtins which print.
+func WithStdout(w io.Writer) Option {
+	return func(i *Interpreter) {
+		i.stdout = w
+	}
+}
+
+// WithStderr sets the writer used to report errors.
+func WithStderr(w io.Writer) Option {
+	return func(i *Interpreter) {
+		i.stderr = w
+	}
+}
+
+// withTreeWalker makes the interpreter evaluate code by walking it, as l1
+// did before it had a compiler; it's slower, and is kept for comparison.
+func withTreeWalker() Option {
+	return func(i *Interpreter) {
+		i.treeWalk = true
+	}
+}
+
+// NewInterpreter makes a new interpreter and loads the l1 core library into
+// it.  By default, the interpreter uses the process's standard streams.
+func NewInterpreter(opts ...Option) (*Interpreter, error) {
+	i := &Interpreter{
+		stdin:  stdinReader,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	// Tasks may print at once:
+	i.stdout = &syncWriter{w: i.stdout}
+	i.stderr = &syncWriter{w: i.stderr}
+	// The limits are for the code the interpreter is given, not the core
+	// library:
+	lim := i.limits
+	i.limits = nil
+	i.globals = InitGlobals()
+	i.globals.interp = i
+	i.globals.globals.modules.path = searchPath(i.searchPath)
+	if err := lexParseEvalFile("l1.l1", RawCore, &i.globals); err != nil {
+		return nil, extendError("loading core library", err)
+	}
+	i.core = i.globals.globals.snapshot()
+	if lim != nil {
+		lim.start()
+		i.limits = lim
+	}
+	return i, nil
+}
+
+// Env returns the interpreter's global environment.
+func (i *Interpreter) Env() *Env {
+	return &i.globals
+}
+
+// Eval lexes, parses and evaluates the given source code, returning the value
+// of the last expression (or () if there are none).
+func (i *Interpreter) Eval(src string) (Sexpr, error) {
+	return i.evalNamed("", src)
+}
+
+// EvalFile evaluates the contents of the named file, returning the value of
+// the last expression in it.  Errors give the positions in the file of the
+// forms which failed.
+func (i *Interpreter) EvalFile(filename string) (Sexpr, error) {
+	bytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return i.evalNamed(filename, string(bytes))
+}
+
+// evalNamed evaluates src, which came from the named file (if any).
+func (i *Interpreter) evalNamed(filename, src string) (Sexpr, error) {
+	exprs, err := lexAndParseFile(filename, strings.Split(src, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	if i.limits != nil {
+		defer i.limits.start()()
+	}
+	return evalLast(exprs, &i.globals)
+}
+
+// EvalExprs evaluates exprs, as read by Parse, as the package function
+// EvalExprs does, in the interpreter's global environment.
+func (i *Interpreter) EvalExprs(exprs []Sexpr, doPrint bool) error {
+	if i.limits != nil {
+		defer i.limits.start()()
+	}
+	return EvalExprs(exprs, &i.globals, doPrint)
+}
+
+// evalLast evaluates exprs in order, returning the last result.
+func evalLast(exprs []Sexpr, e *Env) (Sexpr, error) {
+	var ret Sexpr = Nil
+	for _, expr := range exprs {
+		var err error
+		ret, err = eval(expr, e)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// RegisterBuiltin makes a native (Go) function available to code run by the
+// interpreter; see Env.DefineBuiltin.
+func (i *Interpreter) RegisterBuiltin(b *Builtin) error {
+	return i.globals.DefineBuiltin(b)
+}
+
+// syncWriter serializes writes to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// captureWriter captures what an evaluation prints.
+type captureWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *captureWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
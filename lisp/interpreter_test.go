@@ -0,0 +1,261 @@
+package lisp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterpreterEval(t *testing.T) {
+	interp, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		input string
+		want  string
+		err   string
+	}{
+		{"", "()", ""},
+		{"(+ 1 2)", "3", ""},
+		{"(defn sq (x) (* x x)) (sq 12)", "144", ""},
+		{"(sq 3)", "9", ""},
+		{"(map inc (range 3))", "(1 2 3)", ""},
+		{"(/ 1 0)", "", "division by zero"},
+		{"(", "", "unbalanced parens"},
+	}
+	for _, test := range tests {
+		got, err := interp.Eval(test.input)
+		if err != nil {
+			if test.err == "" || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Eval(%q) error = %q, want %q", test.input, err, test.err)
+			}
+			continue
+		}
+		if test.err != "" {
+			t.Errorf("Eval(%q) = %s, want error %q", test.input, got, test.err)
+			continue
+		}
+		if got.String() != test.want {
+			t.Errorf("Eval(%q) = %s, want %s", test.input, got, test.want)
+		}
+	}
+}
+
+func TestInterpreterStreams(t *testing.T) {
+	var out bytes.Buffer
+	interp, err := NewInterpreter(
+		WithStdin(strings.NewReader("(a b c)\n(1 2)\n")),
+		WithStdout(&out),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := interp.Eval("(println (readlist)) (print 'x 'y) (readlist)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "((1 2))" {
+		t.Errorf("second readlist = %s, want ((1 2))", got)
+	}
+	if out.String() != "((a b c))\nx y" {
+		t.Errorf("got output %q", out.String())
+	}
+}
+
+// EvalExprs prints results and errors to stdout, as it always has, even
+// for embedders who set stderr:
+func TestEvalExprsPrinting(t *testing.T) {
+	var out, errs bytes.Buffer
+	interp, err := NewInterpreter(WithStdout(&out), WithStderr(&errs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exprs, err := Parse(LexItems([]string{"(+ 1 2) (car 1)"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EvalExprs(exprs, interp.Env(), true); err == nil {
+		t.Error("expected an error")
+	}
+	if !strings.HasPrefix(out.String(), "3\nERROR:\n") || errs.Len() != 0 {
+		t.Errorf("got output %q, errors %q", out.String(), errs.String())
+	}
+}
+
+func TestInterpreterEvalFile(t *testing.T) {
+	var out bytes.Buffer
+	interp, err := NewInterpreter(WithStdout(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "f.l1")
+	src := "(defn f (n) (* n 2))\n(printl '(loaded))\n(f 21)\n"
+	if err := os.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := interp.EvalFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "42" {
+		t.Errorf("EvalFile = %s, want 42", got)
+	}
+	if out.String() != "loaded\n" {
+		t.Errorf("got output %q", out.String())
+	}
+	if _, err := interp.EvalFile(filename + ".missing"); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	var out bytes.Buffer
+	interp, err := NewInterpreter(WithStdout(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = interp.RegisterBuiltin(&Builtin{
+		Name:       "double",
+		Doc:        convertStringToDoc("Double a number"),
+		FixedArity: 1,
+		Args:       list(Atom{"x"}),
+		Examples:   list(list(Atom{"double"}, Num(21))),
+		Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+			n, ok := args[0].(Number)
+			if !ok {
+				return nil, baseErrorf("'%s' is not a number", args[0])
+			}
+			return n.Add(n), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = interp.RegisterBuiltin(&Builtin{
+		Name:       "pair-up",
+		FixedArity: 1,
+		NAry:       true,
+		Args:       Cons(Atom{"x"}, Atom{"xs"}),
+		Fn: func(args []Sexpr, _ *Env) (Sexpr, error) {
+			return list(args...), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		input string
+		want  string
+		err   string
+	}{
+		{"(double 21)", "42", ""},
+		{"(pair-up 1 2)", "(1 2)", ""},
+		{"(pair-up)", "", "pair-up expects at least one argument, got 0"},
+		{"(map double (range 3))", "(0 2 4)", ""},
+		{"(doc double)", "(Double a number)", ""},
+		{"(some (comp (partial = 'double) first) (forms))", "t", ""},
+		{"(double)", "", "double expects a single argument, got 0"},
+		{"(double 1 2)", "", "double expects a single argument, got 2"},
+		{"(double 'x)", "", "is not a number"},
+	}
+	for _, test := range tests {
+		got, err := interp.Eval(test.input)
+		if err != nil {
+			if test.err == "" || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Eval(%q) error = %q, want %q", test.input, err, test.err)
+			}
+			continue
+		}
+		if test.err != "" || got.String() != test.want {
+			t.Errorf("Eval(%q) = %s, want %q (error %q)", test.input, got, test.want, test.err)
+		}
+	}
+	longDoc, err := LongDocStr(interp.Env())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(longDoc, "## `double`") || !strings.Contains(longDoc, "> (double 21)\n;;=>\n42") {
+		t.Error("double missing from long documentation")
+	}
+	if _, err := interp.Eval("(help)"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "double  N    1   Double a number") {
+		t.Errorf("double missing from help output:\n%s", out.String())
+	}
+
+	for _, b := range []*Builtin{
+		{Fn: func([]Sexpr, *Env) (Sexpr, error) { return Nil, nil }},
+		{Name: "nofn"},
+		{Name: "let", Fn: func([]Sexpr, *Env) (Sexpr, error) { return Nil, nil }},
+	} {
+		if err := interp.RegisterBuiltin(b); err == nil {
+			t.Errorf("expected error registering %q", b.Name)
+		}
+	}
+}
+
+func TestInterpreterTasks(t *testing.T) {
+	var out bytes.Buffer
+	interp, err := NewInterpreter(WithStdout(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tasks which define globals, make gensyms, compile functions on
+	// first call and print, all at once:
+	got, err := interp.Eval(`
+(defn work (n)
+  (let ((name (string->atom (str 'global- n))))
+    (eval (list 'def name n))
+    (gensym)
+    (println n)
+    (* n (eval name))))
+(def tasks (map (lambda (n) (spawn work n)) (range 50)))
+(apply + (apply join tasks))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "40425" {
+		t.Errorf("got %s, want 40425", got)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 50 {
+		t.Errorf("got %d lines of output, want 50", n)
+	}
+	for _, name := range []string{"global-0", "global-49"} {
+		if _, ok := interp.Env().Lookup(name); !ok {
+			t.Errorf("%s was not defined", name)
+		}
+	}
+}
+
+func TestExpansionSteps(t *testing.T) {
+	interp, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := interp.Eval("(defmacro twice (x) `(progn ~x ~x))"); err != nil {
+		t.Fatal(err)
+	}
+	exprs, err := Parse(LexItems([]string{"(list (twice 1) '(twice 2))"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps, err := interp.ExpansionSteps(exprs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, step := range steps {
+		got = append(got, step.Macro+": "+step.Form.String())
+	}
+	want := []string{
+		"twice: (list (progn 1 1) (quote (twice 2)))",
+		"progn: (list (let () 1 1) (quote (twice 2)))",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
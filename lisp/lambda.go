@@ -2,19 +2,55 @@ package lisp
 
 import (
 	"fmt"
+	"strings"
 )
 
 type lambdaFn struct {
-	args    *ConsCell
+	args    *ConsCell // names, or patterns to destructure
 	restArg string
+	// The number of required arguments:
+	nargs int
+	// The parameters after &optional and &key, with their defaults:
+	optional, keys []optionalParam
+	// Whether any of args is a pattern:
+	patterns bool
+	// The names of the rest argument, if any, then of the other arguments
+	// (or the names in their patterns), as bound in the environment of a
+	// call:
+	params  []symbol
 	body    *ConsCell
 	doc     *ConsCell
 	isMacro bool
 	env     *Env
+	// Compiled code for the body, shared by all the lambdas made by the
+	// same lambda or defn form:
+	proto *lambdaProto
 }
 
 var noRestArg string = ""
 
+// optionalParam is an &optional or &key parameter, whose default is
+// evaluated, in the environment of the call, when no argument is given for
+// it.
+type optionalParam struct {
+	name  Atom
+	deflt Sexpr
+}
+
+// parseOptionalParam parses an &optional or &key parameter given as
+// (name default).
+func parseOptionalParam(x *ConsCell) (optionalParam, error) {
+	items, err := consToExprs(x)
+	if err != nil || len(items) != 2 {
+		return optionalParam{}, baseErrorf("'%s' is not a name or (name default)", x)
+	}
+	name, ok := items[0].(Atom)
+	if !ok || name == True || name.s == "&optional" || name.s == "&key" {
+		return optionalParam{}, baseErrorf("'%s' in %s is not a name", items[0], x)
+	}
+	return optionalParam{name, items[1]}, nil
+}
+
 func mkLambda(cdr *ConsCell, isMacro bool, e *Env) (*lambdaFn, error) {
 	restArg := noRestArg
 	// look for fn name
@@ -35,17 +71,62 @@ func mkLambda(cdr *ConsCell, isMacro bool, e *Env) (*lambdaFn, error) {
 		return nil, baseError("lambda requires an argument list")
 	}
 	emptyArgList := false
-	args := []string{}
+	args := []Sexpr{}
+	params := []symbol{}
+	patterns := false
+	nargs := 0
+	// Which parameters are being read: required, &optional or &key:
+	var mode string
+	var optional, keys []optionalParam
 top:
 	for argList != Nil && !emptyArgList {
-		if argList.car == Nil {
-			emptyArgList = true
-		} else {
-			arg, ok := argList.car.(Atom)
-			if !ok {
-				return nil, baseError("argument list item is not an atom")
+		switch arg := argList.car.(type) {
+		case Atom:
+			args = append(args, arg)
+			if arg.s == "&optional" || arg.s == "&key" {
+				if mode == "&key" || mode == arg.s {
+					return nil, baseErrorf("%s is out of place in argument list", arg.s)
+				}
+				mode = arg.s
+				break
+			}
+			switch mode {
+			case "":
+				nargs++
+			case "&optional":
+				optional = append(optional, optionalParam{arg, Nil})
+			default:
+				keys = append(keys, optionalParam{arg, Nil})
+			}
+			params = append(params, intern(arg.s))
+		case *ConsCell:
+			if arg == Nil && mode == "" {
+				emptyArgList = true
+				break
 			}
-			args = append(args, arg.s)
+			args = append(args, arg)
+			if mode != "" {
+				p, err := parseOptionalParam(arg)
+				if err != nil {
+					return nil, extendError("lambda argument list", err)
+				}
+				if mode == "&optional" {
+					optional = append(optional, p)
+				} else {
+					keys = append(keys, p)
+				}
+				params = append(params, intern(p.name.s))
+				break
+			}
+			names, err := patternNames(arg)
+			if err != nil {
+				return nil, extendError("lambda argument list", err)
+			}
+			nargs++
+			params = append(params, names...)
+			patterns = true
+		default:
+			return nil, baseError("argument list item is not an atom or a pattern")
 		}
 		switch t := argList.cdr.(type) {
 		case Atom:
@@ -60,6 +141,9 @@ top:
 	if emptyArgList && restArg == noRestArg {
 		return nil, baseError("lambda with () argument requires a rest argument")
 	}
+	if len(keys) > 0 && restArg != noRestArg {
+		return nil, baseError("lambda cannot have both &key and rest arguments")
+	}
 	body := cdr.cdr.(*ConsCell)
 	// Find `doc` form and save it if found:
 	doc := Nil
@@ -77,13 +161,26 @@ top:
 			body = body.cdr.(*ConsCell) // Skip `doc` part.
 		}
 	}
+	if restArg != noRestArg {
+		params = append([]symbol{intern(restArg)}, params...)
+	}
+	if isMacro && e.globals.module == nil {
+		body = qualifyTemplates(body, e)
+	}
 	f := lambdaFn{
-		stringsToList(args...),
-		restArg,
-		body,
-		doc,
-		isMacro,
-		e}
+		args:     list(args...),
+		restArg:  restArg,
+		nargs:    nargs,
+		optional: optional,
+		keys:     keys,
+		patterns: patterns,
+		params:   params[:len(params):len(params)],
+		body:     body,
+		doc:      doc,
+		isMacro:  isMacro,
+		env:      e,
+		proto:    &lambdaProto{},
+	}
 	if fnName != "" {
 		// Monkey-patch the environment the lambda is created in, so the
 		// lambda can invoke itself if the name is available:
@@ -105,3 +202,122 @@ func (f *lambdaFn) String() string {
 func (f *lambdaFn) Equal(o Sexpr) bool {
 	return false
 }
+
+// splitArgs splits the arguments of a call of f into those for its required
+// parameters, those for its &optional parameters, and the rest, which are
+// for its rest parameter or are keyword arguments.
+func (f *lambdaFn) splitArgs(args []Sexpr) (required, optional, more []Sexpr, err error) {
+	if len(args) < f.nargs {
+		missing := f.args
+		for i := 0; i < len(args); i++ {
+			missing = missing.cdr.(*ConsCell)
+		}
+		return nil, nil, nil, typedErrorf("arity-error", Nil,
+			"not enough arguments for function: missing %s", missing.car)
+	}
+	required, more = args[:f.nargs], args[f.nargs:]
+	// Keyword arguments can't stand in for missing optional ones, so
+	// the first keyword ends the optional arguments, if f takes any:
+	n := 0
+	for n < len(f.optional) && n < len(more) && !f.startsKeys(more[n]) {
+		n++
+	}
+	optional, more = more[:n], more[n:]
+	if len(more) > 0 && f.restArg == noRestArg && len(f.keys) == 0 {
+		return nil, nil, nil, typedError("arity-error", Nil, "too many arguments for function")
+	}
+	return required, optional, more, nil
+}
+
+// startsKeys returns true if x, given as an argument for one of f's
+// &optional parameters, is a keyword, and so begins f's keyword arguments.
+func (f *lambdaFn) startsKeys(x Sexpr) bool {
+	a, ok := x.(Atom)
+	return ok && len(f.keys) > 0 && isKeyword(a)
+}
+
+// isKey returns true if x is a keyword naming one of f's &key parameters.
+func (f *lambdaFn) isKey(x Sexpr) bool {
+	a, ok := x.(Atom)
+	if !ok || !isKeyword(a) {
+		return false
+	}
+	for _, k := range f.keys {
+		if k.name.s == a.s[1:] {
+			return true
+		}
+	}
+	return false
+}
+
+// bindOptional binds f's &optional and &key parameters in e, the
+// environment of a call, to the arguments given for them, or to their
+// defaults.  more holds the keyword arguments, as alternating keywords and
+// values.
+func (f *lambdaFn) bindOptional(e *Env, optional, more []Sexpr) error {
+	for i, p := range f.optional {
+		if i < len(optional) {
+			e.set(intern(p.name.s), optional[i])
+			continue
+		}
+		if err := bindDefault(e, p); err != nil {
+			return err
+		}
+	}
+	if len(f.keys) == 0 {
+		return nil
+	}
+	given := map[string]Sexpr{}
+	for i := 0; i < len(more); i += 2 {
+		k, ok := more[i].(Atom)
+		if !ok || !isKeyword(k) {
+			return typedErrorf("arity-error", more[i],
+				"expected a keyword, got '%s'", more[i])
+		}
+		if !f.isKey(k) {
+			return typedErrorf("arity-error", k,
+				"unknown keyword %s (expected %s)", k, f.keywords())
+		}
+		if i+1 == len(more) {
+			return typedErrorf("arity-error", k, "missing value for keyword %s", k)
+		}
+		given[k.s[1:]] = more[i+1]
+	}
+	for _, p := range f.keys {
+		if v, ok := given[p.name.s]; ok {
+			e.set(intern(p.name.s), v)
+			continue
+		}
+		if err := bindDefault(e, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keywords lists the keywords naming f's &key parameters.
+func (f *lambdaFn) keywords() string {
+	ks := make([]string, len(f.keys))
+	for i, k := range f.keys {
+		ks[i] = ":" + k.name.s
+	}
+	return strings.Join(ks, ", ")
+}
+
+// bindDefault binds p in e to its default, evaluated in e, so that it may
+// refer to the parameters before it.
+func bindDefault(e *Env, p optionalParam) error {
+	v := p.deflt
+	switch v.(type) {
+	case Number, String:
+	default:
+		if v != Nil {
+			var err error
+			if v, err = eval(p.deflt, e); err != nil {
+				return extendError("default for "+p.name.s, err)
+			}
+		}
+	}
+	e.set(intern(p.name.s), v)
+	return nil
+}
@@ -3,24 +3,35 @@ package lisp
 import (
 	"fmt"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/eigenhombre/lexutil"
 )
 
 // Use with lexutil.go (which should eventually be its own package).
 
-// Token is a lexeme with a line number.
+// Token is a lexeme with the file, line and column it was found at.
 type Token struct {
 	lexeme lexutil.LexItem
 	line   int
+	col    int
+	file   string
+	// The comments before the token, which the parser ignores, but which
+	// the formatter keeps:
+	trivia []Token
 }
 
 // Token Types:
 const (
 	itemNumber lexutil.ItemType = iota
 	itemAtom
+	itemString
 	itemLeftParen
 	itemRightParen
+	itemLeftBrace
+	itemRightBrace
+	itemLeftBracket
+	itemRightBracket
 	itemForwardQuote
 	itemSyntaxQuote
 	itemUnquote
@@ -29,14 +40,20 @@ const (
 	itemCommentNext
 	itemShebang
 	itemError
+	itemComment
 )
 
 // Human-readable versions of above:
 var typeMap = map[lexutil.ItemType]string{
 	itemNumber:          "NUM",
 	itemAtom:            "ATOM",
+	itemString:          "STR",
 	itemLeftParen:       "LP",
 	itemRightParen:      "RP",
+	itemLeftBrace:       "LB",
+	itemRightBrace:      "RB",
+	itemLeftBracket:     "LBR",
+	itemRightBracket:    "RBR",
 	itemForwardQuote:    "QUOTE",
 	itemSyntaxQuote:     "SYNTAXQUOTE",
 	itemUnquote:         "UNQUOTE",
@@ -45,6 +62,7 @@ var typeMap = map[lexutil.ItemType]string{
 	itemCommentNext:     "COMMENTNEXT",
 	itemShebang:         "SHEBANG",
 	itemError:           "ERR",
+	itemComment:         "COMMENT",
 }
 
 // LexRepr returns a string representation of a known lexeme.
@@ -54,10 +72,20 @@ func LexRepr(i Token) string {
 		return fmt.Sprintf("%s(%s)", typeMap[i.lexeme.Typ], i.lexeme.Val)
 	case itemAtom:
 		return fmt.Sprintf("%s(%s)", typeMap[i.lexeme.Typ], i.lexeme.Val)
+	case itemString:
+		return fmt.Sprintf("%s(%s)", typeMap[i.lexeme.Typ], i.lexeme.Val)
 	case itemLeftParen:
 		return "LP"
 	case itemRightParen:
 		return "RP"
+	case itemLeftBrace:
+		return "LB"
+	case itemRightBrace:
+		return "RB"
+	case itemLeftBracket:
+		return "LBR"
+	case itemRightBracket:
+		return "RBR"
 	case itemError:
 		return fmt.Sprintf("%s(%s)", typeMap[i.lexeme.Typ], i.lexeme.Val)
 	case itemForwardQuote:
@@ -74,6 +102,8 @@ func LexRepr(i Token) string {
 		return "COMMENTNEXT"
 	case itemShebang:
 		return "SHEBANG"
+	case itemComment:
+		return fmt.Sprintf("%s(%s)", typeMap[i.lexeme.Typ], i.lexeme.Val)
 	default:
 		panic("bad item type")
 	}
@@ -87,11 +117,14 @@ func isSpace(r rune) bool {
 	return strings.ContainsRune(" \t\n\r", r)
 }
 
-func ignoreToEndOfLine(l *lexutil.Lexer) {
+// lexComment lexes a comment, from the semicolon to the end of the line.
+func lexComment(l *lexutil.Lexer) lexutil.StateFn {
 	for {
-		if r := l.Next(); r == '\n' || r == lexutil.EOF {
-			return
+		if r := l.Peek(); r == '\n' || r == lexutil.EOF {
+			l.Emit(itemComment)
+			return lexStart
 		}
+		l.Next()
 	}
 }
 
@@ -101,16 +134,26 @@ func lexStart(l *lexutil.Lexer) lexutil.StateFn {
 		case isSpace(r):
 			l.Ignore()
 		case r == ';':
-			ignoreToEndOfLine(l)
+			return lexComment
 		case r == lexutil.EOF:
 			return nil
 		case isDigit(r) || r == '-' || r == '+':
 			l.Backup()
-			return lexInt
+			return lexNumber
+		case r == '"':
+			return lexString
 		case r == '(':
 			l.Emit(itemLeftParen)
 		case r == ')':
 			l.Emit(itemRightParen)
+		case r == '{':
+			l.Emit(itemLeftBrace)
+		case r == '}':
+			l.Emit(itemRightBrace)
+		case r == '[':
+			l.Emit(itemLeftBracket)
+		case r == ']':
+			l.Emit(itemRightBracket)
 		case isAtomStart(r):
 			return lexAtom
 		case r == '\'':
@@ -131,7 +174,7 @@ func lexStart(l *lexutil.Lexer) lexutil.StateFn {
 	}
 }
 
-var disallowedForAtomAfterStart = " \t\n\r()~@#;`'"
+var disallowedForAtomAfterStart = " \t\n\r(){}[]~@#;`'\""
 var disallowedForAtomStart = "0123456789+-." + disallowedForAtomAfterStart
 
 func isAtomStart(r rune) bool {
@@ -157,10 +200,34 @@ func lexAtom(l *lexutil.Lexer) lexutil.StateFn {
 	acceptIf(l, func(r rune) bool {
 		return !(strings.ContainsRune(disallowedForAtomAfterStart, r))
 	})
+	// An atom may end in #, for auto-gensyms in syntax-quote:
+	if l.Accept("#") {
+		if r := l.Peek(); r != lexutil.EOF && !strings.ContainsRune(disallowedForAtomAfterStart, r) {
+			return l.Errorf("unexpected character %q in atom after #", itemError, r)
+		}
+	}
 	l.Emit(itemAtom)
 	return lexStart
 }
 
+// lexString lexes a double-quoted string, including the quotes; escape
+// sequences are interpreted by the parser.  Strings cannot span lines.
+func lexString(l *lexutil.Lexer) lexutil.StateFn {
+	for {
+		switch l.Next() {
+		case '\\':
+			if r := l.Next(); r == lexutil.EOF || r == '\n' {
+				return l.Errorf("unterminated string", itemError)
+			}
+		case '"':
+			l.Emit(itemString)
+			return lexStart
+		case lexutil.EOF, '\n':
+			return l.Errorf("unterminated string", itemError)
+		}
+	}
+}
+
 func lexHashSugar(l *lexutil.Lexer) lexutil.StateFn {
 	l.Accept("#")
 	nextRune := l.Peek()
@@ -195,39 +262,102 @@ func lexUnquote(l *lexutil.Lexer) lexutil.StateFn {
 	return lexStart
 }
 
-func lexInt(l *lexutil.Lexer) lexutil.StateFn {
+// lexNumber lexes an integer (`-12`), rational (`1/3`) or float (`1.5`,
+// `1e-9`, `-2.5E+3`).
+func lexNumber(l *lexutil.Lexer) lexutil.StateFn {
 	l.Accept("-+")
 	nextRune := l.Peek()
 	if isDigit(nextRune) {
 		l.AcceptRun("0123456789")
+		if !acceptDigitsAfter(l, "/", "") {
+			acceptDigitsAfter(l, ".", "")
+			acceptDigitsAfter(l, "eE", "-+")
+		}
 		l.Emit(itemNumber)
 		return lexStart
 	}
 	return lexAtom
 }
 
+// acceptDigitsAfter consumes a rune from first, optionally a rune from
+// optional, then one or more digits.  If there are no digits, nothing is
+// consumed and acceptDigitsAfter returns false.
+func acceptDigitsAfter(l *lexutil.Lexer, first, optional string) bool {
+	pos := l.Pos
+	if l.Accept(first) {
+		l.Accept(optional)
+		if isDigit(l.Peek()) {
+			l.AcceptRun("0123456789")
+			return true
+		}
+	}
+	l.Pos = pos
+	return false
+}
+
 // LexItems lexes a string into a slice of tokens.
 func LexItems(ss []string) []Token {
+	return LexItemsFrom("", 1, ss)
+}
+
+// LexItemsFrom lexes lines of the named file, the first of which is line
+// number firstLine, into a slice of tokens.
+func LexItemsFrom(filename string, firstLine int, ss []string) []Token {
+	tokens, _ := lexWithTrivia(filename, firstLine, ss)
+	return tokens
+}
+
+// lexWithTrivia is like LexItemsFrom, but also returns the comments after
+// the last token.  (Those before a token are its trivia.)
+func lexWithTrivia(filename string, firstLine int, ss []string) ([]Token, []Token) {
 	ret := []Token{}
+	var trivia []Token
 	for line, s := range ss {
 		l := lexutil.Lex("main", s, lexStart)
+		// Only spaces and comments are skipped between tokens, so each
+		// token is the next occurrence of its text in the line:
+		pos := 0
 		for tok := range l.Items {
-			// Programmers may be civilians, counting lines from 1 rather than
-			// 0:
-			ret = append(ret, Token{tok, line + 1})
+			if tok.Typ != itemError {
+				if i := strings.Index(s[pos:], tok.Val); i >= 0 {
+					pos += i
+				}
+			}
+			// Programmers may be civilians, counting columns from 1 rather
+			// than 0:
+			col := utf8.RuneCountInString(s[:pos]) + 1
+			if tok.Typ != itemError {
+				pos += len(tok.Val)
+			}
+			if tok.Typ == itemComment {
+				trivia = append(trivia, Token{tok, firstLine + line, col, filename, nil})
+				continue
+			}
+			ret = append(ret, Token{tok, firstLine + line, col, filename, trivia})
+			trivia = nil
 		}
 	}
-	return ret
+	return ret, trivia
+}
+
+// isOpener and isCloser are true for tokens which begin and end lists and
+// other collections.
+func isOpener(t lexutil.ItemType) bool {
+	return t == itemLeftParen || t == itemLeftBrace || t == itemLeftBracket
+}
+
+func isCloser(t lexutil.ItemType) bool {
+	return t == itemRightParen || t == itemRightBrace || t == itemRightBracket
 }
 
-// IsBalanced returns true iff parens are balanced
+// IsBalanced returns true iff parens (and braces and brackets) are balanced
 func IsBalanced(tokens []Token) (bool, error) {
 	level := 0
 	for _, token := range tokens {
-		switch token.lexeme.Typ {
-		case itemLeftParen:
+		switch {
+		case isOpener(token.lexeme.Typ):
 			level++
-		case itemRightParen:
+		case isCloser(token.lexeme.Typ):
 			level--
 		}
 	}
@@ -10,7 +10,7 @@ import (
 func TestLex(t *testing.T) {
 	abbrev := func(typ lexutil.ItemType) func(string, int) Token {
 		return func(input string, line int) Token {
-			return Token{lexutil.LexItem{Typ: typ, Val: input}, line}
+			return Token{lexeme: lexutil.LexItem{Typ: typ, Val: input}, line: line}
 		}
 	}
 	S := func(input ...string) []string {
@@ -19,7 +19,12 @@ func TestLex(t *testing.T) {
 	N := abbrev(itemNumber)
 	LP := abbrev(itemLeftParen)
 	RP := abbrev(itemRightParen)
+	LB := abbrev(itemLeftBrace)
+	RB := abbrev(itemRightBrace)
+	LBR := abbrev(itemLeftBracket)
+	RBR := abbrev(itemRightBracket)
 	A := abbrev(itemAtom)
+	STR := abbrev(itemString)
 	DOT := abbrev(itemDot)
 	QUOTE := abbrev(itemForwardQuote)
 	BACKQUOTE := abbrev(itemSyntaxQuote)
@@ -54,6 +59,10 @@ func TestLex(t *testing.T) {
 		{S("-1"), toks(N("-1", 1))},
 		{S("+0"), toks(N("+0", 1))},
 		{S("+3 -5 "), toks(N("+3", 1), N("-5", 1))},
+		{S("1/3 -22/7"), toks(N("1/3", 1), N("-22/7", 1))},
+		{S("3.14 -0.5 1e-9 6.02E+23"), toks(N("3.14", 1), N("-0.5", 1), N("1e-9", 1), N("6.02E+23", 1))},
+		{S("1/x"), toks(N("1", 1), A("/x", 1))},
+		{S("(1 . 2)"), toks(LP("(", 1), N("1", 1), DOT(".", 1), N("2", 1), RP(")", 1))},
 		{S("("), toks(LP("(", 1))},
 		{S("( "), toks(LP("(", 1))},
 		{S(" ("), toks(LP("(", 1))},
@@ -106,14 +115,29 @@ func TestLex(t *testing.T) {
 			Err("unexpected character '@' in input", 3),
 			N("3", 4),
 			RP(")", 4))},
+		{S(`"hello"`), toks(STR(`"hello"`, 1))},
+		{S(`("a b" c"d")`), toks(LP("(", 1), STR(`"a b"`, 1), A("c", 1), STR(`"d"`, 1), RP(")", 1))},
+		{S(`"say \"hi\"\n"`), toks(STR(`"say \"hi\"\n"`, 1))},
+		{S(`""`), toks(STR(`""`, 1))},
+		{S(`"abc`), toks(Err("unterminated string", 1))},
+		{S("{a 1}"), toks(LB("{", 1), A("a", 1), N("1", 1), RB("}", 1))},
+		{S("{}x{"), toks(LB("{", 1), RB("}", 1), A("x", 1), LB("{", 1))},
+		{S("[a 1]"), toks(LBR("[", 1), A("a", 1), N("1", 1), RBR("]", 1))},
+		{S("[]x["), toks(LBR("[", 1), RBR("]", 1), A("x", 1), LBR("[", 1))},
 		{S("#_1"), toks(COMMENTNEXT("#_", 1), N("1", 1))},
 		{S("#_(1 2 3)"), toks(COMMENTNEXT("#_", 1), LP("(", 1), N("1", 1), N("2", 1), N("3", 1), RP(")", 1))},
+		{S("`(x# ~y)"), toks(BACKQUOTE("`", 1), LP("(", 1),
+			A("x#", 1), UNQUOTE("~", 1), A("y", 1), RP(")", 1))},
+		{S("x#y"), toks(Err("unexpected character 'y' in atom after #", 1))},
 		{S("#!/bin/bash\n1(+)\n"), toks(SHEBANG("#!/bin/bash", 1),
 			N("1", 1), LP("(", 1), A("+", 1), RP(")", 1))},
 	}
 
 	for _, test := range tests {
 		items := LexItems(test.input)
+		for i := range items {
+			items[i].col = 0 // Checked in TestLexPositions
+		}
 		if !reflect.DeepEqual(items, test.output) {
 			t.Errorf("%q: expected %v, got %v ... ERROR", test.input, test.output, items)
 		} else {
@@ -121,3 +145,65 @@ func TestLex(t *testing.T) {
 		}
 	}
 }
+
+func TestLexPositions(t *testing.T) {
+	type pos struct{ line, col int }
+	var tests = []struct {
+		input []string
+		want  []pos
+	}{
+		{[]string{"(a b)"}, []pos{{1, 1}, {1, 2}, {1, 4}, {1, 5}}},
+		{[]string{"  'x ; (y)", "", "\t\"a b\" 12"}, []pos{{1, 3}, {1, 4}, {3, 2}, {3, 8}}},
+		{[]string{"水果 [x]"}, []pos{{1, 1}, {1, 4}, {1, 5}, {1, 6}}},
+		{[]string{"a a"}, []pos{{1, 1}, {1, 3}}},
+	}
+	for _, test := range tests {
+		items := LexItemsFrom("f.l1", 1, test.input)
+		got := []pos{}
+		for _, item := range items {
+			if item.file != "f.l1" {
+				t.Errorf("%q: token %v has file %q", test.input, item, item.file)
+			}
+			got = append(got, pos{item.line, item.col})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got positions %v, want %v", test.input, got, test.want)
+		}
+	}
+	items := LexItemsFrom("", 10, []string{"a", "b"})
+	if items[0].line != 10 || items[1].line != 11 {
+		t.Errorf("lines should start at 10: %v", items)
+	}
+}
+
+func TestLexComments(t *testing.T) {
+	tokens, trailing := lexWithTrivia("", 1, []string{
+		";; first",
+		"(a ; after a",
+		"   b) ;; last"})
+	type comment struct {
+		text      string
+		line, col int
+	}
+	got := [][]comment{}
+	for _, tok := range append(tokens, Token{trivia: trailing}) {
+		cs := []comment{}
+		for _, c := range tok.trivia {
+			if c.lexeme.Typ != itemComment {
+				t.Errorf("trivia %v is not a comment", c)
+			}
+			cs = append(cs, comment{c.lexeme.Val, c.line, c.col})
+		}
+		got = append(got, cs)
+	}
+	want := [][]comment{
+		{{";; first", 1, 1}},
+		{},
+		{{"; after a", 2, 4}},
+		{},
+		{{";; last", 3, 7}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
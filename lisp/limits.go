@@ -0,0 +1,265 @@
+package lisp
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Limits bound the work an interpreter will do for code which can't be
+// trusted to finish: the number of steps evaluation takes, how deeply calls
+// which aren't tail calls nest, how many cons cells are made, and how long
+// evaluation runs.  Each limit, when it's exceeded, raises a condition of its
+// own type, which can be caught like any other.  Since the code catching
+// it would soon exceed the limit again, the handlers get a further
+// graceSteps steps, after which the condition is raised again at every
+// step.
+//
+// Steps, conses and time are counted from the start of each call of Eval,
+// EvalFile or EvalExprs on the Interpreter, and the tasks code spawns count
+// towards the budget of the evaluation they run in; the depth of calls is
+// counted for each task apart, since each has a stack of its own.  The
+// cons cells counted are those made for the code evaluated, by `cons`,
+// `list` and the other builtins which build lists, and for the rest
+// arguments of functions.
+type limits struct {
+	maxSteps  int64
+	maxDepth  int64
+	maxConses int64
+	timeout   time.Duration
+	ctx       context.Context // evaluation stops when it's done
+	depth     atomic.Int64    // calls being evaluated, outside tasks
+	cur       atomic.Pointer[budget]
+}
+
+// budget is the state of the limits for one top-level evaluation.
+type budget struct {
+	ctx    context.Context
+	cancel context.CancelFunc // stops the evaluation; see Interrupt
+	steps  atomic.Int64
+	conses atomic.Int64
+	// The first limit exceeded, and the step after which it's raised at
+	// every step:
+	breach atomic.Pointer[breach]
+}
+
+type breach struct {
+	err   error
+	until int64
+}
+
+const (
+	// graceSteps is how many steps code gets, after a limit is exceeded,
+	// to handle the condition raised.
+	graceSteps = 1000
+	// How often, in steps, the context is checked:
+	ctxCheckInterval = 1024
+)
+
+// WithMaxSteps limits the number of steps evaluation may take; a step is
+// the evaluation of one form by the tree-walker, or of one instruction by
+// the VM.  A limit of 0 means none.
+func WithMaxSteps(n int64) Option {
+	return func(i *Interpreter) {
+		if n > 0 {
+			i.limitsFor().maxSteps = n
+		}
+	}
+}
+
+// WithMaxDepth limits how deeply calls of functions which aren't tail
+// calls may nest.  A limit of 0 means none.
+func WithMaxDepth(n int) Option {
+	return func(i *Interpreter) {
+		if n > 0 {
+			i.limitsFor().maxDepth = int64(n)
+		}
+	}
+}
+
+// WithMaxConses limits the number of cons cells evaluation may make.  The
+// limit is checked at each step.  A limit of 0 means none.
+func WithMaxConses(n int64) Option {
+	return func(i *Interpreter) {
+		if n > 0 {
+			i.limitsFor().maxConses = n
+		}
+	}
+}
+
+// WithTimeout limits how long each evaluation may run.  Waiting, as by
+// `sleep` or `recv`, counts.  A limit of 0 means none.
+func WithTimeout(d time.Duration) Option {
+	return func(i *Interpreter) {
+		if d > 0 {
+			i.limitsFor().timeout = d
+		}
+	}
+}
+
+// WithContext stops evaluation when ctx is done, as when its deadline
+// passes or it is canceled.
+func WithContext(ctx context.Context) Option {
+	return func(i *Interpreter) {
+		i.limitsFor().ctx = ctx
+	}
+}
+
+// limitsFor returns the interpreter's limits, making them if need be.
+func (i *Interpreter) limitsFor() *limits {
+	if i.limits == nil {
+		i.limits = &limits{}
+	}
+	return i.limits
+}
+
+// limits returns the limits on evaluation in e, or nil if there are none.
+func (e *Env) limits() *limits {
+	if e.interp == nil {
+		return nil
+	}
+	return e.interp.limits
+}
+
+// done returns a channel which is closed when evaluation in e should stop,
+// for builtins which wait; it's nil, and never closed, if there's no
+// deadline.
+func (e *Env) done() <-chan struct{} {
+	if l := e.limits(); l != nil {
+		if b := l.cur.Load(); b != nil && b.ctx != nil {
+			return b.ctx.Done()
+		}
+	}
+	return nil
+}
+
+// countConses counts n cons cells made for the code evaluated in e against
+// the limit on them, if there is one.
+func (e *Env) countConses(n int) {
+	if l := e.limits(); l != nil && l.maxConses > 0 {
+		if b := l.cur.Load(); b != nil {
+			b.conses.Add(int64(n))
+		}
+	}
+}
+
+// stopped returns the error for evaluation in e stopping, after the channel
+// returned by done is closed.
+func (e *Env) stopped() error {
+	return ctxError(e.limits().cur.Load().ctx)
+}
+
+// start starts the budget for a new top-level evaluation, returning the
+// function to call when it's done.
+func (l *limits) start() func() {
+	b := &budget{ctx: l.ctx}
+	cancel := func() {}
+	if l.timeout > 0 {
+		ctx := b.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		b.ctx, cancel = context.WithTimeout(ctx, l.timeout)
+	}
+	if b.ctx != nil {
+		b.ctx, b.cancel = context.WithCancel(b.ctx)
+		outer := cancel
+		cancel = func() {
+			b.cancel()
+			outer()
+		}
+	}
+	l.cur.Store(b)
+	return cancel
+}
+
+// Interrupt stops the evaluation the interpreter is running, if any, which
+// raises a `canceled` condition.  Only interpreters with a context or a
+// timeout (see WithContext and WithTimeout) can be interrupted; Interrupt
+// returns false for others, or if there's no evaluation running.
+func (i *Interpreter) Interrupt() bool {
+	if i.limits == nil {
+		return false
+	}
+	b := i.limits.cur.Load()
+	if b == nil || b.cancel == nil || b.ctx.Err() != nil {
+		return false
+	}
+	b.cancel()
+	return true
+}
+
+// step counts a step of evaluation, returning an error if a limit has been
+// exceeded.
+func (l *limits) step() error {
+	b := l.cur.Load()
+	n := b.steps.Add(1)
+	if br := b.breach.Load(); br != nil {
+		if n > br.until {
+			return fresh(br.err)
+		}
+		return nil
+	}
+	var err error
+	switch {
+	case l.maxSteps > 0 && n > l.maxSteps:
+		err = typedErrorf("step-limit-exceeded", Num(l.maxSteps),
+			"evaluation took more than %d steps", l.maxSteps)
+	case l.maxConses > 0 && b.conses.Load() > l.maxConses:
+		err = typedErrorf("cons-limit-exceeded", Num(l.maxConses),
+			"evaluation made more than %d cons cells", l.maxConses)
+	case b.ctx != nil && n%ctxCheckInterval == 0 && b.ctx.Err() != nil:
+		err = ctxError(b.ctx)
+	default:
+		return nil
+	}
+	if !b.breach.CompareAndSwap(nil, &breach{err, n + graceSteps}) {
+		// Another task got there first:
+		return nil
+	}
+	return err
+}
+
+// enter counts a call made by task t (nil outside tasks), returning an
+// error if calls nest too deeply; unless it does, leave must be called when
+// the call returns.
+func (l *limits) enter(t *Task) error {
+	if l.maxDepth == 0 {
+		return nil
+	}
+	depth := l.depthOf(t)
+	if depth.Add(1) > l.maxDepth {
+		depth.Add(-1)
+		return typedErrorf("depth-limit-exceeded", Num(l.maxDepth),
+			"calls nested more than %d deep", l.maxDepth)
+	}
+	return nil
+}
+
+// leave counts the return of n calls made by task t.
+func (l *limits) leave(t *Task, n int) {
+	if l.maxDepth != 0 {
+		l.depthOf(t).Add(-int64(n))
+	}
+}
+
+// depthOf returns the count of calls being evaluated by task t.
+func (l *limits) depthOf(t *Task) *atomic.Int64 {
+	if t == nil {
+		return &l.depth
+	}
+	return &t.depth
+}
+
+// ctxError returns the condition raised when evaluation stops because ctx
+// is done.
+func ctxError(ctx context.Context) error {
+	var c *Condition
+	if ctx.Err() == context.DeadlineExceeded {
+		c = NewCondition("deadline-exceeded", list(Atom{"evaluation"}, Atom{"timed"}, Atom{"out"}), Nil)
+	} else {
+		c = NewCondition("canceled", list(Atom{"evaluation"}, Atom{"canceled"}), Nil)
+	}
+	c.hostErr = ctx.Err()
+	return c
+}
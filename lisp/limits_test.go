@@ -0,0 +1,192 @@
+package lisp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	var tests = []struct {
+		name string
+		opts []Option
+		src  string
+		typ  string
+	}{
+		{"steps", []Option{WithMaxSteps(10000)}, "(loop)", "step-limit-exceeded"},
+		{"steps in tail calls", []Option{WithMaxSteps(10000)},
+			"(defn f (n) (f (+ n 1))) (f 0)", "step-limit-exceeded"},
+		{"depth", []Option{WithMaxDepth(100)},
+			"(defn f (n) (+ 1 (f n))) (f 0)", "depth-limit-exceeded"},
+		{"depth through builtins", []Option{WithMaxDepth(100)},
+			"(defn f (l) (map f (list l))) (f 0)", "depth-limit-exceeded"},
+		{"conses", []Option{WithMaxConses(1000)},
+			"(defn f (l) (f (cons 1 l))) (f ())", "cons-limit-exceeded"},
+		{"timeout", []Option{WithTimeout(50 * time.Millisecond)},
+			"(loop)", "deadline-exceeded"},
+		{"timeout while waiting", []Option{WithTimeout(50 * time.Millisecond)},
+			"(recv (chan))", "deadline-exceeded"},
+		{"timeout while sleeping", []Option{WithTimeout(50 * time.Millisecond)},
+			"(sleep 100000)", "deadline-exceeded"},
+		{"timeout in select", []Option{WithTimeout(50 * time.Millisecond)},
+			"(select (chan))", "deadline-exceeded"},
+		{"timeout in await", []Option{WithTimeout(50 * time.Millisecond)},
+			"(await (spawn (lambda () (loop))))", "deadline-exceeded"},
+	}
+	for _, walker := range []bool{false, true} {
+		for _, test := range tests {
+			opts := test.opts
+			if walker {
+				opts = append(opts, withTreeWalker())
+			}
+			t.Run(fmt.Sprintf("%s (walker: %v)", test.name, walker), func(t *testing.T) {
+				interp, err := NewInterpreter(opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = interp.Eval(test.src)
+				c, ok := err.(*Condition)
+				if !ok || c.Type().s != test.typ {
+					t.Errorf("got %v, want %s", err, test.typ)
+				}
+			})
+		}
+	}
+}
+
+func TestLimitsAreCatchable(t *testing.T) {
+	for _, walker := range []bool{false, true} {
+		opts := []Option{WithMaxSteps(10000), WithMaxDepth(50)}
+		if walker {
+			opts = append(opts, withTreeWalker())
+		}
+		interp, err := NewInterpreter(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := interp.Eval(`
+(try (loop)
+  (catch (step-limit-exceeded e)
+    (list (condition-type e) (condition-data e))))`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != "(step-limit-exceeded 10000)" {
+			t.Errorf("got %s", got)
+		}
+		// Code which carries on after catching the condition is stopped:
+		_, err = interp.Eval("(try (loop) (catch e (loop)))")
+		if c, ok := err.(*Condition); !ok || c.Type().s != "step-limit-exceeded" {
+			t.Errorf("got %v, want step-limit-exceeded", err)
+		}
+		// The depth limit is caught where calls aren't nested so deeply:
+		got, err = interp.Eval(`
+(defn f (n) (+ 1 (f n)))
+(try (f 0) (catch (depth-limit-exceeded e) 'too-deep))`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != "too-deep" {
+			t.Errorf("got %s", got)
+		}
+		// Each evaluation has a budget of its own, and calls which
+		// returned, even by raising an error, no longer count:
+		for i := 0; i < 3; i++ {
+			got, err = interp.Eval(`
+(defn g (n) (if (zero? n) 0 (+ 1 (g (- n 1)))))
+(g 40)`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != "40" {
+				t.Errorf("got %s", got)
+			}
+		}
+	}
+}
+
+// Interpreters running at once, and tasks, don't count towards each
+// other's limits:
+func TestLimitsRunningAtOnce(t *testing.T) {
+	for _, walker := range []bool{false, true} {
+		var extra []Option
+		if walker {
+			extra = append(extra, withTreeWalker())
+		}
+		var wg sync.WaitGroup
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			interp, err := NewInterpreter(append(extra, WithMaxConses(1000))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					got, err := interp.Eval(`
+(defn build (n l) (cond ((zero? n) l) (t (build (- n 1) (cons n l)))))
+(len (build 800 ()))`)
+					if err != nil || got.String() != "800" {
+						errs <- fmt.Errorf("got %v, %v", got, err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+
+		interp, err := NewInterpreter(append(extra, WithMaxDepth(100))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The tasks are all 60 calls deep while they wait to receive:
+		got, err := interp.Eval(`
+(defn deep (n ch) (cond ((zero? n) (recv ch)) (t (+ 1 (deep (- n 1) ch)))))
+(def ch (chan 3))
+(def tasks (list (spawn deep 60 ch) (spawn deep 60 ch) (spawn deep 60 ch)))
+(sleep 50)
+(send ch 0)
+(send ch 0)
+(send ch 0)
+(apply + (apply join tasks))`)
+		if err != nil || got.String() != "180" {
+			t.Errorf("got %v, %v", got, err)
+		}
+	}
+}
+
+func TestLimitsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interp, err := NewInterpreter(WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := interp.Eval("(+ 1 2)"); err != nil || got.String() != "3" {
+		t.Fatalf("got %v, %v", got, err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err = interp.Eval("(loop)")
+	if c, ok := err.(*Condition); !ok || c.Type().s != "canceled" {
+		t.Errorf("got %v, want canceled", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v to be context.Canceled", err)
+	}
+}
+
+func TestNoLimitsForCoreLibrary(t *testing.T) {
+	if _, err := NewInterpreter(WithMaxSteps(1), WithMaxConses(1), WithMaxDepth(1)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,652 @@
+package lisp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// The REPL reads forms with a LineEditor.  At a terminal, it edits the form
+// being typed with emacs-style keys (C-a, C-e, C-b, C-f, M-b, M-f, C-d,
+// C-k, C-u, C-w, M-d, C-y, C-l), recalls earlier forms with the up and down
+// arrows (or C-p and C-n), completes names with Tab, and highlights the
+// paren matching the one at the cursor.  Return enters the form once it's
+// complete; until then it starts a new line, indented to suit the
+// unfinished form.  C-c discards the form, and C-d on an empty form ends
+// the session.  Elsewhere (when input is piped in, say), it reads lines as
+// they come, until they make a complete form.
+
+// maxHistory is the number of forms kept in the history file.
+const maxHistory = 1000
+
+// LineEditor reads forms typed at the REPL.
+type LineEditor struct {
+	interp *Interpreter
+	in     *bufio.Reader
+	out    io.Writer
+	// Whether the input is a terminal to edit on, and how wide it is:
+	raw   bool
+	width func() int
+	// Switches the terminal into and out of raw mode, if it is one:
+	enterRaw func() (func(), error)
+	// Earlier forms, oldest first, and the file they're kept in:
+	history  []string
+	histPath string
+	// The text last killed, for C-y:
+	killed []rune
+}
+
+// NewLineEditor makes a LineEditor reading from the interpreter's input,
+// which is edited if it's the process's terminal.  Forms entered are kept
+// in the file at historyPath, if it isn't empty, from session to session.
+func (i *Interpreter) NewLineEditor(historyPath string) *LineEditor {
+	ed := &LineEditor{
+		interp:   i,
+		in:       i.stdin,
+		out:      i.stdout,
+		histPath: historyPath,
+	}
+	fd := int(os.Stdin.Fd())
+	if i.stdin == stdinReader && isTerminal(fd) {
+		ed.raw = true
+		ed.enterRaw = func() (func(), error) { return rawMode(fd) }
+		ed.width = func() int { return termWidth(int(os.Stdout.Fd())) }
+	}
+	ed.loadHistory()
+	return ed
+}
+
+// ReadForm reads a complete form (or several, or none, if a line is blank),
+// returning its text.  It returns io.EOF at the end of the input.
+func (ed *LineEditor) ReadForm(prompt string) (string, error) {
+	ed.interp.stdinMu.Lock()
+	defer ed.interp.stdinMu.Unlock()
+	if !ed.raw {
+		return ed.readLines(prompt)
+	}
+	restore, err := ed.enterRaw()
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+	return ed.edit(prompt)
+}
+
+// readLines reads lines without editing them, until they make a complete
+// form.
+func (ed *LineEditor) readLines(prompt string) (string, error) {
+	fmt.Fprint(ed.out, prompt)
+	lines := []string{}
+	for {
+		line, err := readLineFrom(ed.in)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+		text := strings.Join(lines, "\n")
+		if isComplete(text) {
+			return text, nil
+		}
+	}
+}
+
+// isComplete returns true unless text is an unfinished form.  Text with
+// too many closing parens is complete, so the error can be reported.
+func isComplete(text string) bool {
+	bal, err := IsBalanced(LexItems([]string{text}))
+	return bal || err != nil
+}
+
+// edit lets the user edit a form at the terminal.
+func (ed *LineEditor) edit(prompt string) (string, error) {
+	s := &editState{ed: ed, prompt: prompt}
+	histIdx := len(ed.history)
+	draft := ""
+	s.render()
+	for {
+		r, _, err := ed.in.ReadRune()
+		if err != nil {
+			s.finish()
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			text := string(s.buf)
+			if !isComplete(text) {
+				indent := ""
+				// Pasted text is indented already:
+				if ed.in.Buffered() == 0 {
+					indent = strings.Repeat(" ", indentation(s.buf[:s.pos]))
+				}
+				s.insert([]rune("\n" + indent))
+				break
+			}
+			s.finish()
+			ed.addHistory(text)
+			return text, nil
+		case ctrl('a'):
+			s.pos = s.lineStart()
+		case ctrl('e'):
+			s.pos = s.lineEnd()
+		case ctrl('b'):
+			s.left()
+		case ctrl('f'):
+			s.right()
+		case ctrl('d'):
+			if len(s.buf) == 0 {
+				s.finish()
+				return "", io.EOF
+			}
+			s.deleteTo(s.pos + 1)
+		case ctrl('h'), 127:
+			if s.pos > 0 {
+				s.deleteTo(s.pos - 1)
+			}
+		case ctrl('k'):
+			ed.killed = s.deleteTo(s.lineEnd())
+		case ctrl('u'):
+			ed.killed = s.deleteTo(s.lineStart())
+		case ctrl('w'):
+			ed.killed = s.deleteTo(s.wordStart())
+		case ctrl('y'):
+			s.insert(ed.killed)
+		case ctrl('p'), ctrl('n'):
+			up := r == ctrl('p')
+			if s.moveLine(up) {
+				break
+			}
+			histIdx, draft = ed.recall(s, histIdx, draft, up)
+		case ctrl('l'):
+			fmt.Fprint(ed.out, "\x1b[H\x1b[2J")
+			s.rows = 0
+		case ctrl('c'):
+			s.pos = len(s.buf)
+			s.done = true
+			s.render()
+			fmt.Fprint(ed.out, "^C\r\n")
+			return "", nil
+		case '\t':
+			s.complete()
+		case 27:
+			switch s.readEscape() {
+			case "[A", "OA":
+				if !s.moveLine(true) {
+					histIdx, draft = ed.recall(s, histIdx, draft, true)
+				}
+			case "[B", "OB":
+				if !s.moveLine(false) {
+					histIdx, draft = ed.recall(s, histIdx, draft, false)
+				}
+			case "[C", "OC":
+				s.right()
+			case "[D", "OD":
+				s.left()
+			case "[H", "OH", "[1~", "[7~":
+				s.pos = s.lineStart()
+			case "[F", "OF", "[4~", "[8~":
+				s.pos = s.lineEnd()
+			case "[3~":
+				s.deleteTo(s.pos + 1)
+			case "b", "[1;5D", "[1;3D":
+				s.pos = s.wordStart()
+			case "f", "[1;5C", "[1;3C":
+				s.pos = s.wordEnd()
+			case "d":
+				ed.killed = s.deleteTo(s.wordEnd())
+			case "\x7f":
+				ed.killed = s.deleteTo(s.wordStart())
+			}
+		default:
+			if r >= ' ' {
+				s.insert([]rune{r})
+			}
+		}
+		s.render()
+	}
+}
+
+func ctrl(r rune) rune {
+	return r & 0x1f
+}
+
+// recall replaces the form being edited with the one before (if up) or after
+// the histIdx'th form in the history, returning the new index and the form
+// being edited before history was recalled.
+func (ed *LineEditor) recall(s *editState, histIdx int, draft string, up bool) (int, string) {
+	if histIdx == len(ed.history) {
+		draft = string(s.buf)
+	}
+	switch {
+	case up && histIdx > 0:
+		histIdx--
+	case !up && histIdx < len(ed.history):
+		histIdx++
+	default:
+		return histIdx, draft
+	}
+	if histIdx == len(ed.history) {
+		s.buf = []rune(draft)
+	} else {
+		s.buf = []rune(ed.history[histIdx])
+	}
+	s.pos = len(s.buf)
+	return histIdx, draft
+}
+
+// editState is the form being edited, and what's shown of it.
+type editState struct {
+	ed     *LineEditor
+	prompt string
+	buf    []rune
+	pos    int // the cursor's index in buf
+	// The number of screen rows above the cursor's used by the form:
+	rows int
+	// Whether the form has been entered, or discarded:
+	done bool
+}
+
+func (s *editState) insert(rs []rune) {
+	buf := make([]rune, 0, len(s.buf)+len(rs))
+	buf = append(append(append(buf, s.buf[:s.pos]...), rs...), s.buf[s.pos:]...)
+	s.buf = buf
+	s.pos += len(rs)
+}
+
+// deleteTo deletes the text between the cursor and i, returning it.
+func (s *editState) deleteTo(i int) []rune {
+	if i < 0 || i > len(s.buf) {
+		return nil
+	}
+	from, to := s.pos, i
+	if from > to {
+		from, to = to, from
+	}
+	deleted := append([]rune{}, s.buf[from:to]...)
+	s.buf = append(s.buf[:from], s.buf[to:]...)
+	s.pos = from
+	return deleted
+}
+
+func (s *editState) left() {
+	if s.pos > 0 {
+		s.pos--
+	}
+}
+
+func (s *editState) right() {
+	if s.pos < len(s.buf) {
+		s.pos++
+	}
+}
+
+func (s *editState) lineStart() int {
+	i := s.pos
+	for i > 0 && s.buf[i-1] != '\n' {
+		i--
+	}
+	return i
+}
+
+func (s *editState) lineEnd() int {
+	i := s.pos
+	for i < len(s.buf) && s.buf[i] != '\n' {
+		i++
+	}
+	return i
+}
+
+// moveLine moves the cursor to the line above or below, keeping its column
+// if it can; it returns false if there's no such line.
+func (s *editState) moveLine(up bool) bool {
+	start, end := s.lineStart(), s.lineEnd()
+	col := s.pos - start
+	switch {
+	case up && start > 0:
+		s.pos = start - 1
+		start = s.lineStart()
+	case !up && end < len(s.buf):
+		s.pos = end + 1
+		start = s.pos
+	default:
+		return false
+	}
+	s.pos = start
+	if end := s.lineEnd(); start+col < end {
+		s.pos = start + col
+	} else {
+		s.pos = end
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return !strings.ContainsRune(disallowedForAtomAfterStart, r)
+}
+
+func (s *editState) wordStart() int {
+	i := s.pos
+	for i > 0 && !isWordRune(s.buf[i-1]) {
+		i--
+	}
+	for i > 0 && isWordRune(s.buf[i-1]) {
+		i--
+	}
+	return i
+}
+
+func (s *editState) wordEnd() int {
+	i := s.pos
+	for i < len(s.buf) && !isWordRune(s.buf[i]) {
+		i++
+	}
+	for i < len(s.buf) && isWordRune(s.buf[i]) {
+		i++
+	}
+	return i
+}
+
+// readEscape reads the rest of an escape sequence sent for a key, such as
+// "[A" for the up arrow, or "b" for M-b.
+func (s *editState) readEscape() string {
+	r, _, err := s.ed.in.ReadRune()
+	if err != nil {
+		return ""
+	}
+	if r != '[' && r != 'O' {
+		return string(r)
+	}
+	seq := []rune{r}
+	for {
+		r, _, err := s.ed.in.ReadRune()
+		if err != nil {
+			return string(seq)
+		}
+		seq = append(seq, r)
+		// Sequences end with a letter or a ~:
+		if r >= 0x40 && r <= 0x7e && len(seq) > 1 {
+			return string(seq)
+		}
+	}
+}
+
+// complete completes the name before the cursor, as far as the names it
+// could be agree; if they don't, it lists them.  With no name before the
+// cursor, it indents the line instead.
+func (s *editState) complete() {
+	start := s.wordStart()
+	if start == s.pos || !isWordRune(s.buf[s.pos-1]) {
+		s.indentLine()
+		return
+	}
+	prefix := string(s.buf[start:s.pos])
+	names := completions(&s.ed.interp.globals, prefix)
+	switch len(names) {
+	case 0:
+		return
+	case 1:
+		s.insert([]rune(names[0][len(prefix):] + " "))
+		return
+	}
+	common := names[0]
+	for _, name := range names[1:] {
+		for !strings.HasPrefix(name, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(common) > len(prefix) {
+		s.insert([]rune(common[len(prefix):]))
+		return
+	}
+	// List the candidates below the form, then show the form again:
+	end := s.pos
+	s.pos = len(s.buf)
+	s.render()
+	s.pos = end
+	fmt.Fprintf(s.ed.out, "\r\n%s\r\n", strings.Join(names, "  "))
+	s.rows = 0
+}
+
+// indentLine indents the line the cursor is on to suit the form it's in.
+func (s *editState) indentLine() {
+	start := s.lineStart()
+	if start == 0 {
+		return
+	}
+	n := 0
+	for start+n < len(s.buf) && s.buf[start+n] == ' ' {
+		n++
+	}
+	col := s.pos - start - n
+	if col < 0 {
+		col = 0
+	}
+	indent := indentation(s.buf[:start-1])
+	s.buf = append(s.buf[:start:start],
+		append([]rune(strings.Repeat(" ", indent)), s.buf[start+n:]...)...)
+	s.pos = start + indent + col
+}
+
+// completions returns the names defined in e, built in, or of REPL
+// commands, which start with prefix, in order.
+func completions(e *Env, prefix string) []string {
+	seen := map[string]bool{}
+	ret := []string{}
+	add := func(name string) {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			ret = append(ret, name)
+		}
+	}
+	for _, name := range EnvKeys(e) {
+		add(name)
+	}
+	for name := range builtins {
+		add(name)
+	}
+	for _, form := range specialForms {
+		add(form.name)
+	}
+	for name := range replCommands {
+		add(name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// render shows the form being edited, in place of what was shown before,
+// with the cursor where it is in the form.
+func (s *editState) render() {
+	var b strings.Builder
+	b.WriteString("\r")
+	if s.rows > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", s.rows)
+	}
+	b.WriteString("\x1b[J")
+	b.WriteString(s.prompt)
+	match := -1
+	if !s.done {
+		match = matchingParen(s.buf, s.pos)
+	}
+	width := 0
+	if s.ed.width != nil {
+		width = s.ed.width()
+	}
+	// The screen row and column of each position in the text, counting
+	// from the start of the prompt:
+	row, col := 0, runewidth.StringWidth(s.prompt)
+	curRow, curCol := 0, col
+	for i, r := range s.buf {
+		if i == s.pos {
+			curRow, curCol = row, col
+		}
+		if r == '\n' {
+			b.WriteString("\r\n")
+			row, col = row+1, 0
+			continue
+		}
+		w := runewidth.RuneWidth(r)
+		if width > 0 && col+w > width {
+			row, col = row+1, 0
+		}
+		if i == match {
+			fmt.Fprintf(&b, "\x1b[7m%c\x1b[0m", r)
+		} else {
+			b.WriteRune(r)
+		}
+		col += w
+	}
+	if s.pos == len(s.buf) {
+		curRow, curCol = row, col
+	}
+	if width > 0 && col == width {
+		// Move past the last column, as the terminal waits to:
+		b.WriteString(" \r")
+		row, col = row+1, 0
+		if s.pos == len(s.buf) {
+			curRow, curCol = row, col
+		}
+	}
+	if width > 0 && curCol == width {
+		curRow, curCol = curRow+1, 0
+	}
+	if row > curRow {
+		fmt.Fprintf(&b, "\x1b[%dA", row-curRow)
+	}
+	b.WriteString("\r")
+	if curCol > 0 {
+		fmt.Fprintf(&b, "\x1b[%dC", curCol)
+	}
+	s.rows = curRow
+	fmt.Fprint(s.ed.out, b.String())
+}
+
+// finish shows the whole form, with nothing highlighted, and moves to the
+// line after it.
+func (s *editState) finish() {
+	s.pos = len(s.buf)
+	s.done = true
+	s.render()
+	fmt.Fprint(s.ed.out, "\r\n")
+}
+
+// scanParens returns the positions of the parens (and brackets and braces)
+// in text which match each other, and those of the ones left open, ignoring
+// those in strings and comments.
+func scanParens(text []rune) (map[int]int, []int) {
+	pairs := map[int]int{}
+	open := []int{}
+	inString, inComment := false, false
+	for i := 0; i < len(text); i++ {
+		r := text[i]
+		switch {
+		case r == '\n':
+			inString, inComment = false, false
+		case inComment:
+		case inString:
+			if r == '\\' {
+				i++
+			} else if r == '"' {
+				inString = false
+			}
+		case r == '"':
+			inString = true
+		case r == ';':
+			inComment = true
+		case r == '(' || r == '[' || r == '{':
+			open = append(open, i)
+		case r == ')' || r == ']' || r == '}':
+			if len(open) > 0 {
+				j := open[len(open)-1]
+				open = open[:len(open)-1]
+				pairs[i], pairs[j] = j, i
+			}
+		}
+	}
+	return pairs, open
+}
+
+// matchingParen returns the position of the paren matching the one before
+// the cursor at pos, if that closes a list, or the one at pos; it returns
+// -1 if there is none.
+func matchingParen(text []rune, pos int) int {
+	pairs, _ := scanParens(text)
+	if pos > 0 && strings.ContainsRune(")]}", text[pos-1]) {
+		if j, ok := pairs[pos-1]; ok {
+			return j
+		}
+	}
+	if pos < len(text) && strings.ContainsRune("([{", text[pos]) {
+		if j, ok := pairs[pos]; ok {
+			return j
+		}
+	}
+	return -1
+}
+
+// loadHistory reads the forms entered in earlier sessions.
+func (ed *LineEditor) loadHistory() {
+	if ed.histPath == "" {
+		return
+	}
+	bytes, err := os.ReadFile(ed.histPath)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(bytes), "\n") {
+		if line != "" {
+			ed.history = append(ed.history, unescapeHistory(line))
+		}
+	}
+	if len(ed.history) > maxHistory {
+		ed.history = ed.history[len(ed.history)-maxHistory:]
+		ed.saveHistory()
+	}
+}
+
+// saveHistory rewrites the history file.
+func (ed *LineEditor) saveHistory() {
+	lines := make([]string, len(ed.history))
+	for i, form := range ed.history {
+		lines[i] = escapeHistory(form) + "\n"
+	}
+	os.WriteFile(ed.histPath, []byte(strings.Join(lines, "")), 0600)
+}
+
+// addHistory adds a form to the history, and to the history file, unless
+// it's blank or the same as the last one.
+func (ed *LineEditor) addHistory(form string) {
+	if strings.TrimSpace(form) == "" ||
+		(len(ed.history) > 0 && ed.history[len(ed.history)-1] == form) {
+		return
+	}
+	ed.history = append(ed.history, form)
+	if ed.histPath == "" {
+		return
+	}
+	f, err := os.OpenFile(ed.histPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, escapeHistory(form))
+}
+
+// Forms are kept one per line in the history file, with their newlines
+// (and backslashes) escaped.
+var (
+	historyEscaper   = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	historyUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+)
+
+func escapeHistory(form string) string {
+	return historyEscaper.Replace(form)
+}
+
+func unescapeHistory(line string) string {
+	return historyUnescaper.Replace(line)
+}
@@ -0,0 +1,94 @@
+package lisp
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testEditor returns a LineEditor editing keys as if they were typed at a
+// terminal.
+func testEditor(t *testing.T, keys, histPath string) *LineEditor {
+	interp, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &LineEditor{
+		interp:   interp,
+		in:       bufio.NewReader(strings.NewReader(keys)),
+		out:      io.Discard,
+		raw:      true,
+		width:    func() int { return 80 },
+		enterRaw: func() (func(), error) { return func() {}, nil },
+		histPath: histPath,
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	var tests = []struct {
+		keys string
+		want []string
+	}{
+		{"(+ 1 2)\r", []string{"(+ 1 2)"}},
+		{"(+ 1\r2)\r", []string{"(+ 1\n2)"}},
+		// Editing keys:
+		{"(+ 1 3\x022\x05)\r", []string{"(+ 1 23)"}},
+		{"(list 1 2)\x01\x06\x06\x06\x06\x06\x0b*\x19\r", []string{"(list* 1 2)"}},
+		{"1 2 3\x17\x17\x1b[C\r", []string{"1 "}},
+		{"abc def\x1bb\x1bd(xyz)\r", []string{"abc (xyz)"}},
+		{"(1)\x15(2)\r", []string{"(2)"}},
+		{"(1 2\x7f3)\r", []string{"(1 3)"}},
+		// History:
+		{"(a)\r(b)\r\x1b[A\x1b[A\r", []string{"(a)", "(b)", "(a)"}},
+		{"(a)\r(b\x10\x10\x0e)\r", []string{"(a)", "(b)"}},
+		// Completion:
+		{"(macroexpand-a\t1)\r", []string{"(macroexpand-all 1)"}},
+		{"(car (lis\t*))\r", []string{"(car (list*))"}},
+		// C-c discards what was typed:
+		{"(oops\x03(1)\r", []string{"", "(1)"}},
+	}
+	for _, test := range tests {
+		ed := testEditor(t, test.keys, "")
+		got := []string{}
+		for {
+			text, err := ed.ReadForm("> ")
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, text)
+		}
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%q: got %q, want %q", test.keys, got, test.want)
+		}
+	}
+}
+
+func TestMatchingParen(t *testing.T) {
+	text := []rune(`(a "(" (b))`)
+	for pos, want := range map[int]int{11: 0, 0: 10, 10: 7, 7: 9, 2: -1, 5: -1} {
+		if got := matchingParen(text, pos); got != want {
+			t.Errorf("%d: got %d, want %d", pos, got, want)
+		}
+	}
+}
+
+func TestLineEditorHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	ed := testEditor(t, "(f \"a\\nb\"\r1)\r(g)\r(g)\r \r", path)
+	for {
+		if _, err := ed.ReadForm("> "); err != nil {
+			break
+		}
+	}
+	ed = testEditor(t, "", path)
+	ed.loadHistory()
+	want := []string{"(f \"a\\nb\"\n1)", "(g)"}
+	if strings.Join(ed.history, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", ed.history, want)
+	}
+}
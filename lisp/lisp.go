@@ -14,18 +14,18 @@ type Sexpr interface {
 }
 
 func evAtom(a Atom, e *Env) (Sexpr, error) {
-	if a.s == "t" {
+	if a.s == "t" || isKeyword(a) {
 		return a, nil
 	}
-	ret, ok := e.Lookup(a.s)
-	if ok {
-		return ret, nil
+	if name, ok := lookupSymbol(a.s); ok {
+		if ret := e.lookup(name); ret != nil {
+			return ret, nil
+		}
 	}
-	ret, ok = builtins[a.s]
-	if ok {
-		return ret, nil
+	if ret, err := e.qualified(a.s); ret != nil || err != nil {
+		return ret, err
 	}
-	return nil, baseErrorf("unknown symbol: %s", a.s)
+	return nil, typedErrorf("unknown-symbol", a, "unknown symbol: %s", a.s)
 }
 
 // Do this once, it's pretty expensive:
@@ -44,16 +44,19 @@ func extractCxrLambda(t Atom, e *Env) (Sexpr, error) {
 	}
 	newLambda := &lambdaFn{
 		args:    list(Atom{"xs"}),
+		nargs:   1,
+		params:  []symbol{intern("xs")},
 		body:    list(list(Atom{"c*r"}, list(Atom{"quote"}, args), Atom{"xs"})),
 		isMacro: false,
 		env:     e,
+		proto:   &lambdaProto{},
 	}
 	return newLambda, nil
 }
 
 func evDef(args *ConsCell, e *Env) (Sexpr, error) {
 	if args == Nil {
-		return nil, baseError("missing argument")
+		return nil, typedError("arity-error", Nil, "missing argument")
 	}
 	carAtom, ok := args.car.(Atom)
 	if !ok {
@@ -62,7 +65,7 @@ func evDef(args *ConsCell, e *Env) (Sexpr, error) {
 	name := carAtom.s
 	args, ok = args.cdr.(*ConsCell)
 	if !ok || args == Nil {
-		return nil, baseError("missing argument")
+		return nil, typedError("arity-error", Nil, "missing argument")
 	}
 	val, err := eval(args.car, e)
 	if err != nil {
@@ -77,7 +80,7 @@ func evDef(args *ConsCell, e *Env) (Sexpr, error) {
 
 func evSet(args *ConsCell, e *Env) (Sexpr, error) {
 	if args == Nil {
-		return nil, baseError("missing argument")
+		return nil, typedError("arity-error", Nil, "missing argument")
 	}
 	if args.car == Nil {
 		return nil, baseError("set!: first argument cannot be nil!")
@@ -89,7 +92,7 @@ func evSet(args *ConsCell, e *Env) (Sexpr, error) {
 	name := carAtom.s
 	args, ok = args.cdr.(*ConsCell)
 	if !ok || args == Nil {
-		return nil, baseError("missing argument")
+		return nil, typedError("arity-error", Nil, "missing argument")
 	}
 	val, err := eval(args.car, e)
 	if err != nil {
@@ -131,83 +134,335 @@ func evDefn(args *ConsCell, isMacro bool, e *Env) (Sexpr, error) {
 }
 
 func evErrors(args *ConsCell, e *Env) (Sexpr, error) {
+	ef, err := parseErrors(args, walkSeq)
+	if err != nil {
+		return nil, err
+	}
+	return ef.eval(e)
+}
+
+// errorsForm is a parsed `errors` form.
+type errorsForm struct {
+	args      *ConsCell
+	signature seqFn
+	body      seqFn
+}
+
+func parseErrors(args *ConsCell, seq seqMaker) (*errorsForm, error) {
 	if args == Nil {
 		return nil, baseError("no error spec given")
 	}
-	sigExpr, ok := args.car.(*ConsCell)
-	if !ok {
-		return nil, baseError("error signature must be a list")
+	body, err := consToExprs(args.cdr)
+	if err != nil {
+		return nil, extendError("errors body", err)
 	}
-	sigEvaled, err := eval(sigExpr, e)
+	return &errorsForm{args, seq([]Sexpr{args.car}), seq(body)}, nil
+}
+
+func (ef *errorsForm) eval(e *Env) (Sexpr, error) {
+	sigEvaled, err := ef.signature(e)
 	if err != nil {
 		return nil, extendError("evaluating error signature", err)
 	}
-	sigList, ok := sigEvaled.(*ConsCell)
+	// The signature is either a condition type or a list to look for in
+	// the printed error:
+	var matches func(error) bool
+	var errorStr string
+	switch t := sigEvaled.(type) {
+	case Atom:
+		if t == True {
+			return nil, baseError("error signature must be a list or a condition type")
+		}
+		errorStr = t.s
+		matches = func(err error) bool {
+			return asCondition(err).matchesType(t)
+		}
+	case *ConsCell:
+		errorStr = unwrapList(t)
+		matches = func(err error) bool {
+			return strings.Contains(err.Error(), errorStr)
+		}
+	default:
+		return nil, baseError("error signature must be a list or a condition type")
+	}
+	if _, err := ef.body(e); err != nil {
+		if isEscape(err) {
+			return nil, err
+		}
+		if matches(err) {
+			return Nil, nil
+		}
+		return nil, baseErrorf("error '%s' not found in '%s'",
+			errorStr, err.Error())
+	}
+	return nil, baseErrorf("error not found in %s", ef.args)
+}
+
+// evRaise evaluates the arguments of `raise`: a condition type, a message, and
+// optionally data and a cause, returning the new condition as an error.
+func evRaise(args *ConsCell, e *Env) (Sexpr, error) {
+	argList, err := consToExprs(args)
+	if err != nil {
+		return nil, extendError("raise", err)
+	}
+	if len(argList) < 2 || len(argList) > 4 {
+		return nil, typedError("arity-error", Nil, "raise expects two to four arguments")
+	}
+	vals := make([]Sexpr, len(argList))
+	for i, arg := range argList {
+		if vals[i], err = eval(arg, e); err != nil {
+			return nil, extendError("evaluating raise arguments", err)
+		}
+	}
+	return nil, raiseCondition(vals)
+}
+
+// raiseCondition returns the condition raised by `raise` with the given
+// (evaluated) arguments.
+func raiseCondition(args []Sexpr) error {
+	vals := []Sexpr{Nil, Nil, Nil, Nil}
+	copy(vals, args)
+	typ, ok := vals[0].(Atom)
 	if !ok {
-		return nil, baseError("error signature must be a list")
+		return typedErrorf("type-error", vals[0], "condition type '%s' is not a symbol", vals[0])
 	}
-	errorStr := unwrapList(sigList)
-	bodyArgs := args.cdr.(*ConsCell)
-	for {
-		if bodyArgs == Nil {
-			return nil, baseErrorf("error not found in %s", args)
+	c := NewCondition(typ.s, vals[1], vals[2])
+	if vals[3] != Nil {
+		var err error
+		if c.cause, err = conditionArg(vals[3]); err != nil {
+			return err
+		}
+	}
+	return c
+}
+
+// seqFn evaluates a sequence of forms in an environment, returning the value
+// of the last one, or () if there are none.
+type seqFn func(*Env) (Sexpr, error)
+
+// seqMaker makes the seqFn for a sequence of forms; locals are any names
+// bound around the forms (by `catch`).  The tree-walker evaluates the forms
+// afresh each time (walkSeq); the compiler compiles them once, up front.
+type seqMaker func(forms []Sexpr, locals ...string) seqFn
+
+func walkSeq(forms []Sexpr, _ ...string) seqFn {
+	return func(e *Env) (Sexpr, error) {
+		var ret Sexpr = Nil
+		for _, form := range forms {
+			var err error
+			if ret, err = eval(form, e); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	}
+}
+
+// evTry evaluates the body of a `try` form.  If the body raises a condition,
+// it is handled by the first `catch` clause which matches its type, if any.
+// The body of the `finally` clause, if there is one, is evaluated last,
+// whether or not there was an error.
+func evTry(args *ConsCell, e *Env) (Sexpr, error) {
+	tf, err := parseTry(args, walkSeq)
+	if err != nil {
+		return nil, err
+	}
+	return tf.eval(e)
+}
+
+// tryForm is a parsed `try` form.
+type tryForm struct {
+	body    seqFn
+	catches []catchClause
+	finally seqFn // nil if there is no finally clause
+}
+
+func parseTry(args *ConsCell, seq seqMaker) (*tryForm, error) {
+	body := []Sexpr{}
+	catches := []catchClause{}
+	var finally *ConsCell
+	for l := args; l != Nil; {
+		clause, _ := l.car.(*ConsCell)
+		switch {
+		case listStartsWith(clause, "catch"):
+			if finally != nil {
+				return nil, baseError("finally must be the last clause in try")
+			}
+			cc, err := parseCatch(clause, seq)
+			if err != nil {
+				return nil, err
+			}
+			catches = append(catches, cc)
+		case listStartsWith(clause, "finally"):
+			if finally != nil {
+				return nil, baseError("try can only have one finally clause")
+			}
+			finally = clause
+		default:
+			if len(catches) > 0 || finally != nil {
+				return nil, baseError("try body must come before catch and finally clauses")
+			}
+			body = append(body, l.car)
 		}
-		toEval := bodyArgs.car
-		_, err := eval(toEval, e)
+		next, ok := l.cdr.(*ConsCell)
+		if !ok {
+			return nil, baseError("try requires a list of expressions")
+		}
+		l = next
+	}
+	tf := &tryForm{body: seq(body), catches: catches}
+	if finally != nil {
+		forms, err := consToExprs(finally.cdr)
 		if err != nil {
-			if strings.Contains(err.Error(), errorStr) {
-				return Nil, nil
+			return nil, extendError("finally clause", err)
+		}
+		tf.finally = seq(forms)
+	}
+	return tf, nil
+}
+
+func (tf *tryForm) eval(e *Env) (Sexpr, error) {
+	ret, err := tf.body(e)
+	if err != nil && !isEscape(err) {
+		c := asCondition(err)
+		for _, cc := range tf.catches {
+			if cc.matches(c) {
+				ret, err = evCatch(cc, c, e)
+				break
 			}
-			return nil, baseErrorf("error '%s' not found in '%s'",
-				errorStr, err.Error())
 		}
-		bodyArgs = bodyArgs.cdr.(*ConsCell)
 	}
+	if tf.finally != nil {
+		if _, ferr := tf.finally(e); ferr != nil {
+			if isEscape(ferr) {
+				return nil, ferr
+			}
+			fc := asCondition(ferr)
+			if err != nil && !isEscape(err) {
+				setCause(fc, asCondition(err))
+			}
+			return nil, extendError("finally clause", fc)
+		}
+	}
+	return ret, err
+}
+
+// catchClause is a parsed `catch` clause of a `try` form.
+type catchClause struct {
+	types   []Atom // types of conditions handled; all, if empty
+	binding Atom
+	handler seqFn
+}
+
+// parseCatch parses a `catch` clause, written either `(catch e body...)` to
+// handle any condition, or `(catch (type1 type2 ... e) body...)` to handle
+// only conditions of the given types.
+func parseCatch(clause *ConsCell, seq seqMaker) (catchClause, error) {
+	var ret catchClause
+	badClause := baseError("catch body must be a list with a binding name")
+	cdr, ok := clause.cdr.(*ConsCell)
+	if !ok || cdr == Nil {
+		return ret, badClause
+	}
+	handler, err := consToExprs(cdr.cdr)
+	if err != nil {
+		return ret, badClause
+	}
+	spec, ok := cdr.car.(*ConsCell)
+	if !ok {
+		if ret.binding, ok = cdr.car.(Atom); !ok {
+			return ret, baseError("catch binding name must be a symbol")
+		}
+		ret.handler = seq(handler, ret.binding.s)
+		return ret, nil
+	}
+	items, err := consToExprs(spec)
+	if err != nil || len(items) < 2 {
+		return ret, baseError("catch requires condition types and a binding name")
+	}
+	for _, item := range items[:len(items)-1] {
+		typ, ok := item.(Atom)
+		if !ok {
+			return ret, baseErrorf("condition type %s is not a symbol", item)
+		}
+		ret.types = append(ret.types, typ)
+	}
+	if ret.binding, ok = items[len(items)-1].(Atom); !ok {
+		return ret, baseError("catch binding name must be a symbol")
+	}
+	ret.handler = seq(handler, ret.binding.s)
+	return ret, nil
+}
+
+// matches returns true if the clause handles c.
+func (cc catchClause) matches(c *Condition) bool {
+	if len(cc.types) == 0 {
+		return true
+	}
+	for _, typ := range cc.types {
+		if c.matchesType(typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// evCatch evaluates the body of a catch clause with the condition bound to
+// the clause's binding name.  Conditions raised by the handler have c as their
+// cause.
+func evCatch(cc catchClause, c *Condition, e *Env) (Sexpr, error) {
+	eInner := mkEnv(e)
+	if err := eInner.Set(cc.binding.s, c); err != nil {
+		return nil, extendError("catch binding", err)
+	}
+	ret, err := cc.handler(&eInner)
+	if err != nil {
+		if isEscape(err) {
+			return nil, err
+		}
+		hc := asCondition(err)
+		setCause(hc, c)
+		return nil, extendError("catch body", hc)
+	}
+	return ret, nil
+}
+
+// setCause makes cause the cause of c, unless c already has one (or is
+// being rethrown).
+func setCause(c, cause *Condition) {
+	if c.cause != nil || c.Equal(cause) || cause.hasCause(c) {
+		return
+	}
+	c.cause = cause
 }
 
 // Both eval, apply and macroexpansion use this to bind lambda arguments in the
 // supplied environment:
 func setLambdaArgsInEnv(e *Env, lambda *lambdaFn, evaledList []Sexpr) error {
-	numArgs, err := consLength(lambda.args)
+	required, optional, more, err := lambda.splitArgs(evaledList)
 	if err != nil {
-		return extendError("setting lambda args", err)
+		return err
 	}
 	if lambda.restArg != noRestArg {
-		if numArgs > len(evaledList) {
-			return baseError("not enough arguments for function")
-		}
-		err = e.Set(lambda.restArg,
-			mkListAsConsWithCdr(evaledList[numArgs:],
-				Nil))
+		e.countConses(len(more))
+		err = e.Set(lambda.restArg, mkListAsConsWithCdr(more, Nil))
 		if err != nil {
 			return err
 		}
-	} else {
-		if numArgs < len(evaledList) {
-			return baseError("too many arguments for function")
-		} else if numArgs > len(evaledList) {
-			return baseError("not enough arguments for function")
-		}
 	}
-	// // iterate over lambda.args and evaledList, binding each:
+	// iterate over lambda.args and the required arguments, binding each:
 	start := lambda.args
-	i := 0
-	for start != Nil {
-		arg, ok := start.car.(Atom)
-		if !ok {
-			return baseError("lambda argument must be an atom")
-		}
-		err = e.Set(arg.s, evaledList[i])
-		if err != nil {
+	for _, arg := range required {
+		if err := bindPattern(e, start.car, arg); err != nil {
 			return extendError("setting lambda arg", err)
 		}
+		var ok bool
 		start, ok = start.cdr.(*ConsCell)
 		if !ok {
 			return baseError("lambda argument list must be a list")
 		}
-		i++
 	}
-	return nil
+	return lambda.bindOptional(e, optional, more)
 }
 
 func isMacroCall(args Sexpr, e *Env) bool {
@@ -250,26 +505,18 @@ func macroexpand1(expr Sexpr, e *Env) (Sexpr, error) {
 	if err != nil {
 		return nil, extendError("converting macro call to list", err)
 	}
+	// The macro's body runs where it's called, not where it was defined,
+	// though with the definitions of the module it was defined in:
 	eNew := mkEnv(e)
+	eNew.globals = lambda.env.globals
 	if err := setLambdaArgsInEnv(&eNew, lambda, asCons); err != nil {
 		return nil, extendError("setting macro call arguments", err)
 	}
-	ast := lambda.body
-	var ret Sexpr = Nil
-	for {
-		if ast == Nil {
-			return ret, nil
-		}
-		toEval := ast.car
-		ret, err = eval(toEval, &eNew)
-		if err != nil {
-			return nil, extendError("evaluating macro expansion", err)
-		}
-		ast, ok = ast.cdr.(*ConsCell)
-		if !ok {
-			return nil, baseError("macro body must be a list")
-		}
+	ret, err := evalBody(lambda, &eNew)
+	if err != nil {
+		return nil, extendError("evaluating macro expansion", err)
 	}
+	return ret, nil
 }
 
 func macroexpand(expr Sexpr, e *Env) (Sexpr, error) {
@@ -297,85 +544,238 @@ func listStartsWith(expr *ConsCell, s string) bool {
 	return car.s == s
 }
 
+// syntaxQuoter turns a syntax-quoted template into code which builds it.
+type syntaxQuoter struct {
+	// Where the template is, for resolving its free symbols:
+	env *Env
+	// The auto-gensyms (x#, y#, ...) in the template, in order:
+	autos []Atom
+}
+
+// concatFn is the concatenation function the code for templates with
+// splicing-unquote calls.
+var concatFn = &Builtin{
+	Name:       "concat2",
+	FixedArity: 2,
+	Fn: func(args []Sexpr, e *Env) (Sexpr, error) {
+		items, err := seqItems(args[0])
+		if err != nil {
+			return nil, typedErrorf("type-error", args[0],
+				"splicing-unquote expects a list, got %s", args[0])
+		}
+		e.countConses(len(items))
+		return mkListAsConsWithCdr(items, args[1]), nil
+	},
+}
+
+// call returns code calling fn with args.  The functions templates are
+// built with are put in the code as quoted values, rather than by name, so
+// no binding where the code is evaluated can stand in for them.
+func (sq *syntaxQuoter) call(fn *Builtin, args ...Sexpr) *ConsCell {
+	return Cons(list(Atom{"quote"}, fn), list(args...))
+}
+
+func coreBuiltin(name string) *Builtin {
+	return builtins[name]
+}
+
 // Adapted from
 // https://github.com/kanaka/mal/blob/master/impls/go/src/step7_quote/step7_quote.go#L36,
 // but done recursively:
-func splicingUnquote(l *ConsCell) (*ConsCell, error) {
+func (sq *syntaxQuoter) splicingUnquote(l *ConsCell) *ConsCell {
 	if l == Nil {
-		return Nil, nil
+		return Nil
 	}
 	cdr, ok := l.cdr.(*ConsCell)
 	if !ok {
-		return l, nil
-	}
-	nxt, err := splicingUnquote(cdr)
-	if err != nil {
-		return nil, extendError("splicing unquote", err)
+		return l
 	}
 	elt := l.car
-	switch t := elt.(type) {
-	case *ConsCell:
-		if listStartsWith(t, "splicing-unquote") {
-			return Cons(Atom{"concat2"}, Cons(t.cdr.(*ConsCell).car, Cons(nxt, Nil))), nil
-		}
-	default:
+	if t, ok := elt.(*ConsCell); ok && listStartsWith(t, "splicing-unquote") {
+		return sq.call(concatFn, t.cdr.(*ConsCell).car, sq.splicingUnquote(cdr))
 	}
-	return Cons(Atom{"cons"}, Cons(syntaxQuote(elt), Cons(nxt, Nil))), nil
+	// Quote elt first, so auto-gensyms are made in the order they're read:
+	q := sq.quote(elt)
+	return sq.call(coreBuiltin("cons"), q, sq.splicingUnquote(cdr))
 }
 
-func syntaxQuote(arg Sexpr) Sexpr {
+// syntaxQuote returns code which builds the template arg, found in e.  Each
+// time the code is evaluated, the auto-gensyms in the template, atoms such
+// as x# ending in #, are replaced with fresh symbols, the same throughout the
+// template.  Free atoms naming definitions in the module the template is
+// in are qualified with its name, so that they refer to those definitions
+// wherever the template is used, as by a macro.  (Outside modules, the
+// templates of macros are qualified when the macros are defined; see
+// qualifyTemplates.)
+func syntaxQuote(arg Sexpr, e *Env) Sexpr {
+	sq := &syntaxQuoter{env: e}
+	body := sq.quote(arg)
+	if len(sq.autos) == 0 {
+		return body
+	}
+	bindings := make([]Sexpr, len(sq.autos))
+	for i, a := range sq.autos {
+		prefix := Atom{strings.TrimSuffix(a.s, "#")}
+		bindings[i] = list(a, sq.call(coreBuiltin("gensym"), list(Atom{"quote"}, prefix)))
+	}
+	return list(Atom{"let"}, list(bindings...), body)
+}
+
+func (sq *syntaxQuoter) quote(arg Sexpr) Sexpr {
 	switch t := arg.(type) {
-	case Number, Atom:
+	case Atom:
+		if isAutoGensym(t) {
+			sq.auto(t)
+			return t
+		}
+		return list(Atom{"quote"}, sq.resolve(t))
+	case Number:
 		return Cons(Atom{"quote"}, Cons(arg, Nil))
 	case *ConsCell:
 		if listStartsWith(t, "unquote") {
 			return t.cdr.(*ConsCell).car
 		}
-		ret, err := splicingUnquote(t)
-		if err != nil {
-			return nil
+		return sq.splicingUnquote(t)
+	case *HashMap:
+		// The result is a map literal, whose keys and values are evaluated
+		// when it is:
+		ret := mkHashMap()
+		for _, entry := range t.entries() {
+			if err := ret.set(sq.quote(entry.key), sq.quote(entry.val)); err != nil {
+				return nil
+			}
 		}
 		return ret
+	case *Vector:
+		// Build a list as for lists, then convert it, so that
+		// splicing-unquote works:
+		ret := sq.splicingUnquote(list(t.items...))
+		return sq.call(coreBuiltin("vec"), ret)
 	default:
 		return t
 	}
 }
 
-func evalTest(body *ConsCell, e *Env) (Sexpr, error) {
-	if body == Nil {
-		return Nil, nil
+func isAutoGensym(a Atom) bool {
+	return len(a.s) > 1 && strings.HasSuffix(a.s, "#")
+}
+
+// auto notes the auto-gensym a.
+func (sq *syntaxQuoter) auto(a Atom) {
+	for _, b := range sq.autos {
+		if a == b {
+			return
+		}
 	}
-	testDesc := body.car
-	evDesc, err := eval(testDesc, e)
+	sq.autos = append(sq.autos, a)
+}
+
+// resolve returns a, qualified with the name of the module the template is
+// in if it's defined at the top level of that module.
+func (sq *syntaxQuoter) resolve(a Atom) Sexpr {
+	if sq.env == nil || sq.env.globals.module == nil || strings.Contains(a.s, "/") {
+		return a
+	}
+	name, ok := lookupSymbol(a.s)
+	if !ok || sq.env.globals.own(name) == nil {
+		return a
+	}
+	return Atom{sq.env.globals.module.name + "/" + a.s}
+}
+
+func evTest(args *ConsCell, e *Env) (Sexpr, error) {
+	tf, err := parseTest(args, walkSeq)
 	if err != nil {
-		return nil, extendError("evaluating test description", err)
+		return nil, extendError("test", err)
 	}
-	fmt.Printf("TEST %s ", evDesc)
-	expr, ok := body.cdr.(*ConsCell)
-	if !ok {
+	return tf.eval(e)
+}
+
+// testForm is a parsed `test` form.
+type testForm struct {
+	desc  seqFn
+	forms []Sexpr
+	body  []seqFn // one for each of forms
+}
+
+func parseTest(args *ConsCell, seq seqMaker) (*testForm, error) {
+	tf := &testForm{}
+	if args == Nil {
+		return tf, nil
+	}
+	tf.desc = seq([]Sexpr{args.car})
+	forms, err := consToExprs(args.cdr)
+	if err != nil {
 		return nil, baseError("test body must be a list")
 	}
-	for {
-		if expr == Nil {
-			fmt.Println("✓")
-			return Nil, nil
-		}
-		_, err := eval(expr.car, e)
-		if err != nil {
-			return nil, extendError(fmt.Sprintf("evaluating test %s", expr.car), err)
-		}
-		fmt.Print(".")
-		expr, ok = expr.cdr.(*ConsCell)
-		if !ok {
-			return nil, baseError("test body must be a list")
+	tf.forms = forms
+	for _, form := range forms {
+		tf.body = append(tf.body, seq([]Sexpr{form}))
+	}
+	return tf, nil
+}
+
+func (tf *testForm) eval(e *Env) (Sexpr, error) {
+	if tf.desc == nil {
+		return Nil, nil
+	}
+	evDesc, err := tf.desc(e)
+	if err != nil {
+		return nil, extendError("test", extendError("evaluating test description", err))
+	}
+	fmt.Fprintf(e.stdout(), "TEST %s ", evDesc)
+	for i, form := range tf.forms {
+		if _, err := tf.body[i](e); err != nil {
+			return nil, extendError("test",
+				extendError(fmt.Sprintf("evaluating test %s", form), err))
 		}
+		fmt.Fprint(e.stdout(), ".")
 	}
+	fmt.Fprintln(e.stdout(), "✓")
+	return Nil, nil
 }
 
-func eval(exprArg Sexpr, e *Env) (Sexpr, error) {
+// eval evaluates expr in e: normally by compiling it to bytecode and running
+// it (see compile.go and vm.go), but by walking it directly if e belongs to
+// an interpreter made with withTreeWalker.
+func eval(expr Sexpr, e *Env) (Sexpr, error) {
+	if e.treeWalking() {
+		return walk(expr, e)
+	}
+	return evalCode(compile(expr, e), expr, e)
+}
+
+// walk evaluates expr by walking the tree of the form, dispatching on
+// special form names and expanding macros as it goes.  It is the reference
+// for the semantics of the compiler and VM.
+func walk(expr Sexpr, e *Env) (Sexpr, error) {
+	cur := expr
+	ret, err := walkForm(expr, e, &cur)
+	if err != nil {
+		// Report where the innermost failing form came from; cur is the
+		// last form evaluated in tail position, which may not have been
+		// read from source:
+		return nil, locateError(err, cur, expr)
+	}
+	return ret, nil
+}
+
+// walkForm does the work of walk, setting *cur to each form it evaluates in
+// turn as it makes tail calls.
+func walkForm(exprArg Sexpr, e *Env, cur *Sexpr) (Sexpr, error) {
 	expr := exprArg
 	var err error
+	lim := e.limits()
+	// Whether a call of a lambda function has been counted against the
+	// limit on the depth of calls; tail calls replace it:
+	called := false
 top:
+	*cur = expr
+	if lim != nil {
+		if err := lim.step(); err != nil {
+			return nil, err
+		}
+	}
 	if isMacroCall(expr, e) {
 		expr, err = macroexpand(expr, e)
 		if err != nil {
@@ -388,8 +788,12 @@ top:
 			return extractCxrLambda(t, e)
 		}
 		return evAtom(t, e)
-	case Number:
+	case Number, String:
 		return expr, nil
+	case *HashMap:
+		return evalHashMap(t, e)
+	case *Vector:
+		return evalVector(t, e)
 	case *ConsCell:
 		if t == Nil {
 			return Nil, nil
@@ -410,14 +814,10 @@ top:
 				if cdrCons == Nil {
 					return nil, baseError("syntax-quote needs an argument")
 				}
-				expr = syntaxQuote(cdrCons.car)
+				expr = syntaxQuote(cdrCons.car, e)
 				goto top
 			case carAtom.s == "test":
-				_, err := evalTest(cdrCons, e)
-				if err != nil {
-					return nil, extendError("test", err)
-				}
-				return Nil, nil
+				return evTest(cdrCons, e)
 			case carAtom.s == "cond":
 				pairList := cdrCons
 				if pairList == Nil {
@@ -488,6 +888,11 @@ top:
 			case carAtom.s == "loop":
 				body := cdrCons
 				for {
+					if lim != nil {
+						if err := lim.step(); err != nil {
+							return nil, extendError("loop operator", err)
+						}
+					}
 					start := body
 				bodyLoop:
 					for {
@@ -508,6 +913,9 @@ top:
 						return Nil, nil
 					}
 					_, err := eval(start.car, e)
+					if isEscape(err) {
+						return nil, err
+					}
 					if err != nil {
 						return True, nil
 					}
@@ -529,74 +937,32 @@ top:
 				if err != nil {
 					return nil, extendError("error operator", err)
 				}
-				return nil, Cons(errorExpr, Nil)
+				return nil, NewCondition("error", errorExpr, Nil)
 			case carAtom.s == "errors":
 				return evErrors(cdrCons, e)
-			case carAtom.s == "try":
-				var ret Sexpr = Nil
-				var err error = nil
-				var hadError bool = false
-				for {
-					if cdrCons == Nil {
-						return ret, err
-					}
-					car, ok := cdrCons.car.(*ConsCell)
-					if ok && car != Nil {
-						carCarAtom, ok := car.car.(Atom)
-						if ok && carCarAtom.s == "catch" {
-							if !hadError {
-								return ret, err
-							}
-							cdr, ok := car.cdr.(*ConsCell)
-							if !ok {
-								return nil, baseError("catch body must be a list with a binding name")
-							}
-							bindingName := cdr.car
-							symStr, ok := bindingName.(Atom)
-							if !ok {
-								return nil, baseError("catch binding name must be a symbol")
-							}
-							eInner := mkEnv(e)
-							errCons, ok := err.(*ConsCell)
-							if !ok {
-								return nil, baseError("catch body must be a list with a binding name")
-							}
-							eInner.Set(symStr.s, errCons)
-
-							cdr, ok = cdr.cdr.(*ConsCell)
-							if !ok {
-								return nil, baseError("catch body must be a list with a binding name")
-							}
-							err = nil
-							for {
-								if cdr == Nil {
-									return ret, err
-								}
-								ret, err = eval(cdr.car, &eInner)
-								if err != nil {
-									return nil, extendError("catch body", err)
-								}
-								cdr, ok = cdr.cdr.(*ConsCell)
-								if !ok {
-									return nil, baseError("catch body must be a list with a binding name")
-								}
-							}
-						}
-					}
-					var ev Sexpr
-					if !hadError {
-						ev, err = eval(cdrCons.car, e)
-						if err != nil {
-							hadError = true
-						} else {
-							ret = ev
-						}
-					}
-					cdrCons, ok = cdrCons.cdr.(*ConsCell)
-					if !ok {
-						return nil, baseError("try requires a list of expressions")
-					}
+			case carAtom.s == "raise":
+				return evRaise(cdrCons, e)
+			case carAtom.s == "rethrow":
+				if cdrCons == Nil || cdrCons.cdr != Nil {
+					return nil, typedError("arity-error", Nil, "rethrow expects a single argument")
+				}
+				c, err := eval(cdrCons.car, e)
+				if err != nil {
+					return nil, extendError("evaluating rethrow argument", err)
+				}
+				cond, err := conditionArg(c)
+				if err != nil {
+					return nil, err
 				}
+				return nil, cond
+			case carAtom.s == "try":
+				return evTry(cdrCons, e)
+			case carAtom.s == "module":
+				return evModule(cdrCons, e)
+			case carAtom.s == "export":
+				return evExport(cdrCons, e)
+			case carAtom.s == "import":
+				return evImport(cdrCons, e)
 			case carAtom.s == "let":
 				args := cdrCons
 				if args == Nil {
@@ -616,8 +982,8 @@ top:
 					if !ok || binding == Nil {
 						return nil, baseError("a let binding must be a list of binding pairs")
 					}
-					carAtom, ok := binding.car.(Atom)
-					if !ok {
+					pattern := binding.car
+					if _, ok := pattern.(Atom); !ok && !isPattern(pattern) {
 						return nil, baseError("a let binding must be a list of binding pairs")
 					}
 					asCons, ok := binding.cdr.(*ConsCell)
@@ -631,7 +997,7 @@ top:
 					if err != nil {
 						return nil, extendError("evaluating let bindings", err)
 					}
-					err = newEnv.Set(carAtom.s, val)
+					err = bindPattern(&newEnv, pattern, val)
 					if err != nil {
 						return nil, extendError("setting let bindings", err)
 					}
@@ -682,8 +1048,16 @@ top:
 		// User-defined functions:
 		lambda, ok := evalCar.(*lambdaFn)
 		if ok {
+			if lim != nil && !called {
+				if err := lim.enter(e.task); err != nil {
+					return nil, err
+				}
+				defer lim.leave(e.task, 1)
+				called = true
+			}
 			var err error
 			newEnv := mkEnv(lambda.env)
+			newEnv.task = e.task
 			err = setLambdaArgsInEnv(&newEnv, lambda, evaledList)
 			if err != nil {
 				return nil, extendError("lambda env setup", err)
@@ -702,9 +1076,7 @@ top:
 				}
 				ret, err = eval(body.car, &newEnv)
 				if err != nil {
-					return nil, extendWithList(
-						Cons(Atom{"lambda"}, Cons(body.car, Nil)),
-						err)
+					return nil, extendWithList(lambdaFrame(body.car), err)
 				}
 				body = body.cdr.(*ConsCell)
 			}
@@ -712,7 +1084,7 @@ top:
 		// Built-in functions:
 		builtin, ok := evalCar.(*Builtin)
 		if !ok {
-			return nil, baseErrorf("%s is not a function", evalCar)
+			return nil, typedErrorf("type-error", evalCar, "%s is not a function", evalCar)
 		}
 		biResult, err := builtin.Fn(evaledList, e)
 		if err != nil {
@@ -731,12 +1103,12 @@ func EvalExprs(exprs []Sexpr, e *Env, doPrint bool) error {
 		res, err := eval(g, e)
 		if err != nil {
 			if doPrint {
-				fmt.Printf("ERROR:\n%v\n", err)
+				fmt.Fprintf(e.stdout(), "ERROR:\n%v\n", err)
 			}
 			return err
 		}
 		if doPrint {
-			fmt.Printf("%v\n", res)
+			fmt.Fprintln(e.stdout(), res.String())
 		}
 	}
 	return nil
@@ -744,7 +1116,12 @@ func EvalExprs(exprs []Sexpr, e *Env, doPrint bool) error {
 
 // LexParseEval lexes, parses, and evaluates the given string.
 func LexParseEval(s string, e *Env) error {
-	got, err := lexAndParse(strings.Split(s, "\n"))
+	return lexParseEvalFile("", s, e)
+}
+
+// lexParseEvalFile is like LexParseEval, for the contents of the named file.
+func lexParseEvalFile(filename, s string, e *Env) error {
+	got, err := lexAndParseFile(filename, strings.Split(s, "\n"))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,811 @@
+package lisp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eigenhombre/lexutil"
+)
+
+// ServeLSP serves the Language Server Protocol over in and out (normally
+// standard input and output), so that editors can check l1 source files as
+// they're edited, find and document the functions in them, and indent them.
+// It returns when the client says to exit.
+//
+// Code is never evaluated: definitions are found by reading the files open
+// in the editor and the .l1 files in its workspace.  Documentation for the
+// forms built in and those of the core library comes from an interpreter
+// made with opts.
+func ServeLSP(in io.Reader, out io.Writer, opts ...Option) error {
+	interp, err := NewInterpreter(append(opts, WithPolicy(StrictPolicy))...)
+	if err != nil {
+		return err
+	}
+	s := &lspServer{
+		interp: interp,
+		in:     textproto.NewReader(bufio.NewReader(in)),
+		out:    out,
+		docs:   map[string]*lspDoc{},
+		files:  map[string]*lspDoc{},
+	}
+	return s.serve()
+}
+
+// lspServer is the state of a Language Server Protocol session.
+type lspServer struct {
+	interp *Interpreter
+	in     *textproto.Reader
+	out    io.Writer
+	// The documents open in the editor, and the workspace's files as they
+	// are on disk, by URI:
+	docs     map[string]*lspDoc
+	files    map[string]*lspDoc
+	shutdown bool
+}
+
+// lspDoc is a source file, as analyzed for the editor.
+type lspDoc struct {
+	uri         string
+	lines       []string
+	tokens      []Token
+	defs        []lspDef
+	diagnostics []lspDiagnostic
+}
+
+// lspDef is a def, defn or defmacro form in a source file.
+type lspDef struct {
+	name, kind string
+	// The tokens giving the name, and starting and ending the form:
+	nameTok, start, end Token
+	// The form, if it could be parsed:
+	form Sexpr
+}
+
+// The messages of the Language Server Protocol, as far as they're used
+// here.  Lines and characters count from zero, characters being UTF-16
+// code units.
+
+type lspMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string { return e.Message }
+
+// Error codes of JSON-RPC and the Language Server Protocol:
+const (
+	lspInvalidParams  = -32602
+	lspMethodNotFound = -32601
+	lspInvalidRequest = -32600
+	lspParseError     = -32700
+)
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspSymbol struct {
+	Name           string   `json:"name"`
+	Detail         string   `json:"detail,omitempty"`
+	Kind           int      `json:"kind"`
+	Range          lspRange `json:"range"`
+	SelectionRange lspRange `json:"selectionRange"`
+}
+
+type lspCompletionItem struct {
+	Label string `json:"label"`
+	Kind  int    `json:"kind"`
+}
+
+type lspMarkup struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type lspHover struct {
+	Contents lspMarkup `json:"contents"`
+	Range    lspRange  `json:"range"`
+}
+
+type lspDocumentID struct {
+	URI string `json:"uri"`
+}
+
+type lspDocumentPosition struct {
+	TextDocument lspDocumentID `json:"textDocument"`
+	Position     lspPosition   `json:"position"`
+}
+
+// Kinds of symbols and completions:
+const (
+	lspSymbolFunction   = 12
+	lspSymbolVariable   = 13
+	lspCompleteFunction = 3
+	lspCompleteVariable = 6
+	lspCompleteKeyword  = 14
+)
+
+// lspMethods handle the requests and notifications from the client, by
+// method, returning the result of a request.  Methods not listed here are
+// ignored if they're notifications.
+var lspMethods map[string]func(s *lspServer, params json.RawMessage) (interface{}, error)
+
+// Defined in init, since the methods refer to lspMethods:
+func init() {
+	lspMethods = map[string]func(s *lspServer, params json.RawMessage) (interface{}, error){
+		"initialize": func(s *lspServer, params json.RawMessage) (interface{}, error) {
+			var p struct {
+				RootURI          string          `json:"rootUri"`
+				WorkspaceFolders []lspDocumentID `json:"workspaceFolders"`
+			}
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, &lspError{lspInvalidParams, err.Error()}
+			}
+			roots := []string{}
+			for _, folder := range p.WorkspaceFolders {
+				roots = append(roots, folder.URI)
+			}
+			if len(roots) == 0 && p.RootURI != "" {
+				roots = append(roots, p.RootURI)
+			}
+			for _, root := range roots {
+				s.indexWorkspace(root)
+			}
+			return map[string]interface{}{
+				"capabilities": map[string]interface{}{
+					// Documents are sent whole when they change:
+					"textDocumentSync":           1,
+					"hoverProvider":              true,
+					"definitionProvider":         true,
+					"documentSymbolProvider":     true,
+					"documentFormattingProvider": true,
+					"completionProvider":         map[string]interface{}{},
+				},
+				"serverInfo": map[string]string{"name": "l1", "version": Version},
+			}, nil
+		},
+		"shutdown": func(s *lspServer, params json.RawMessage) (interface{}, error) {
+			s.shutdown = true
+			return nil, nil
+		},
+		"textDocument/didOpen": func(s *lspServer, params json.RawMessage) (interface{}, error) {
+			var p struct {
+				TextDocument struct {
+					URI  string `json:"uri"`
+					Text string `json:"text"`
+				} `json:"textDocument"`
+			}
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, err
+			}
+			return nil, s.update(p.TextDocument.URI, p.TextDocument.Text)
+		},
+		"textDocument/didChange": func(s *lspServer, params json.RawMessage) (interface{}, error) {
+			var p struct {
+				TextDocument   lspDocumentID `json:"textDocument"`
+				ContentChanges []struct {
+					Text string `json:"text"`
+				} `json:"contentChanges"`
+			}
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, err
+			}
+			if len(p.ContentChanges) == 0 {
+				return nil, nil
+			}
+			return nil, s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+		},
+		"textDocument/didClose": func(s *lspServer, params json.RawMessage) (interface{}, error) {
+			var p struct {
+				TextDocument lspDocumentID `json:"textDocument"`
+			}
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, err
+			}
+			uri := p.TextDocument.URI
+			delete(s.docs, uri)
+			// Its definitions are still there, if it's been saved:
+			if _, ok := s.files[uri]; ok {
+				s.indexFile(uriPath(uri))
+			}
+			return nil, s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+				"uri":         uri,
+				"diagnostics": []lspDiagnostic{},
+			})
+		},
+		"textDocument/hover": func(s *lspServer, params json.RawMessage) (interface{}, error) {
+			d, tok, err := s.symbolAt(params)
+			if err != nil || d == nil {
+				return nil, err
+			}
+			doc := s.describe(tok.lexeme.Val)
+			if doc == "" {
+				return nil, nil
+			}
+			return lspHover{lspMarkup{"plaintext", doc}, d.tokenRange(tok)}, nil
+		},
+		"textDocument/definition": func(s *lspServer, params json.RawMessage) (interface{}, error) {
+			d, tok, err := s.symbolAt(params)
+			if err != nil || d == nil {
+				return nil, err
+			}
+			return s.definitions(tok.lexeme.Val), nil
+		},
+		"textDocument/completion": func(s *lspServer, params json.RawMessage) (interface{}, error) {
+			var p lspDocumentPosition
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, &lspError{lspInvalidParams, err.Error()}
+			}
+			d := s.docs[p.TextDocument.URI]
+			if d == nil || p.Position.Line >= len(d.lines) {
+				return []lspCompletionItem{}, nil
+			}
+			line := []rune(d.lines[p.Position.Line])
+			end := runeIndex(d.lines[p.Position.Line], p.Position.Character)
+			start := end
+			for start > 0 && isWordRune(line[start-1]) {
+				start--
+			}
+			return s.completions(string(line[start:end])), nil
+		},
+		"textDocument/documentSymbol": func(s *lspServer, params json.RawMessage) (interface{}, error) {
+			var p struct {
+				TextDocument lspDocumentID `json:"textDocument"`
+			}
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, &lspError{lspInvalidParams, err.Error()}
+			}
+			symbols := []lspSymbol{}
+			d := s.docs[p.TextDocument.URI]
+			if d == nil {
+				return symbols, nil
+			}
+			for _, def := range d.defs {
+				kind := lspSymbolFunction
+				if def.kind == "def" {
+					kind = lspSymbolVariable
+				}
+				symbols = append(symbols, lspSymbol{
+					Name:           def.name,
+					Detail:         def.kind,
+					Kind:           kind,
+					Range:          lspRange{d.tokenRange(def.start).Start, d.tokenRange(def.end).End},
+					SelectionRange: d.tokenRange(def.nameTok),
+				})
+			}
+			return symbols, nil
+		},
+		"textDocument/formatting": func(s *lspServer, params json.RawMessage) (interface{}, error) {
+			var p struct {
+				TextDocument lspDocumentID `json:"textDocument"`
+			}
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, &lspError{lspInvalidParams, err.Error()}
+			}
+			edits := []lspTextEdit{}
+			d := s.docs[p.TextDocument.URI]
+			if d == nil {
+				return edits, nil
+			}
+			text := strings.Join(d.lines, "\n")
+			// Code which can't be parsed yet is just re-indented:
+			formatted, err := Format(text)
+			if err != nil {
+				formatted = reindent(text)
+			}
+			if formatted != text {
+				last := len(d.lines) - 1
+				edits = append(edits, lspTextEdit{
+					Range:   lspRange{End: lspPosition{last, utf16Len(d.lines[last])}},
+					NewText: formatted,
+				})
+			}
+			return edits, nil
+		},
+	}
+}
+
+// serve handles the messages from the client until it says to exit.
+func (s *lspServer) serve() error {
+	for {
+		msg, err := s.read()
+		if lerr, ok := err.(*lspError); ok {
+			// The message was read, but isn't JSON; the next may be:
+			resp := map[string]interface{}{"jsonrpc": "2.0", "id": nil, "error": lerr}
+			if err := s.write(resp); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit before shutdown")
+			}
+			return nil
+		}
+		method, ok := lspMethods[msg.Method]
+		isRequest := len(msg.ID) > 0
+		var result interface{}
+		switch {
+		case s.shutdown && isRequest:
+			err = &lspError{lspInvalidRequest, "the server has been shut down"}
+		case !ok:
+			err = &lspError{lspMethodNotFound, "unknown method " + msg.Method}
+		default:
+			result, err = method(s, msg.Params)
+		}
+		if !isRequest {
+			// Notifications get no response, even to report errors.
+			continue
+		}
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID}
+		if err != nil {
+			lerr, ok := err.(*lspError)
+			if !ok {
+				lerr = &lspError{lspInvalidParams, err.Error()}
+			}
+			resp["error"] = lerr
+		} else {
+			resp["result"] = result
+		}
+		if err := s.write(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// read reads a message from the client: a header giving its length, a
+// blank line, and the message, in JSON.  It returns an *lspError if the
+// message can't be parsed.
+func (s *lspServer) read() (*lspMessage, error) {
+	header, err := s.in.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length: %v", err)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(s.in.R, body); err != nil {
+		return nil, err
+	}
+	var msg lspMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, &lspError{lspParseError, err.Error()}
+	}
+	return &msg, nil
+}
+
+// write writes a message to the client.
+func (s *lspServer) write(msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *lspServer) notify(method string, params interface{}) error {
+	return s.write(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// update analyzes the text of an open document, and sends the client what's
+// wrong with it.
+func (s *lspServer) update(uri, text string) error {
+	d := analyze(uri, text)
+	s.docs[uri] = d
+	return s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri":         uri,
+		"diagnostics": d.diagnostics,
+	})
+}
+
+// maxWorkspaceFiles limits how many files are read from a workspace.
+const maxWorkspaceFiles = 2000
+
+// indexWorkspace reads the definitions in the .l1 files in the directory
+// with the given URI, and those in it, skipping hidden directories.
+func (s *lspServer) indexWorkspace(root string) {
+	dir := uriPath(root)
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return nil
+		case len(s.files) >= maxWorkspaceFiles:
+			return filepath.SkipAll
+		case entry.IsDir() && path != dir && strings.HasPrefix(entry.Name(), "."):
+			return filepath.SkipDir
+		case !entry.IsDir() && strings.HasSuffix(path, ".l1"):
+			s.indexFile(path)
+		}
+		return nil
+	})
+}
+
+func (s *lspServer) indexFile(path string) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	uri := pathURI(path)
+	s.files[uri] = analyze(uri, string(bytes))
+}
+
+// allDocs returns the open documents and the workspace's other files, in
+// order of URI, open documents first.
+func (s *lspServer) allDocs() []*lspDoc {
+	open, closed := []string{}, []string{}
+	for uri := range s.docs {
+		open = append(open, uri)
+	}
+	for uri := range s.files {
+		if s.docs[uri] == nil {
+			closed = append(closed, uri)
+		}
+	}
+	sort.Strings(open)
+	sort.Strings(closed)
+	ret := []*lspDoc{}
+	for _, uri := range open {
+		ret = append(ret, s.docs[uri])
+	}
+	for _, uri := range closed {
+		ret = append(ret, s.files[uri])
+	}
+	return ret
+}
+
+// lookup returns the definition of name, and the document it's in, if
+// there is one.
+func (s *lspServer) lookup(name string) (*lspDoc, *lspDef) {
+	for _, d := range s.allDocs() {
+		for i := range d.defs {
+			if d.defs[i].name == name {
+				return d, &d.defs[i]
+			}
+		}
+	}
+	return nil, nil
+}
+
+// definitions returns where name is defined.
+func (s *lspServer) definitions(name string) []lspLocation {
+	ret := []lspLocation{}
+	for _, d := range s.allDocs() {
+		for _, def := range d.defs {
+			if def.name == name {
+				ret = append(ret, lspLocation{d.uri, d.tokenRange(def.nameTok)})
+			}
+		}
+	}
+	return ret
+}
+
+// describe returns the documentation for name, from its definition in the
+// workspace, or as built in or in the core library; or "" if there is none.
+func (s *lspServer) describe(name string) string {
+	d, def := s.lookup(name)
+	if def == nil {
+		doc, err := describe(name, &s.interp.globals)
+		if err != nil {
+			return ""
+		}
+		return doc
+	}
+	where := fmt.Sprintf("Defined at %s:%d", filepath.Base(uriPath(d.uri)), def.nameTok.line)
+	form, ok := def.form.(*ConsCell)
+	if !ok {
+		return where
+	}
+	if def.kind == "def" {
+		return fmt.Sprintf("%s: variable\n%s\n%s", name, abbreviate(form.String(), 200), where)
+	}
+	// The examples are shown without evaluating them, like the rest of
+	// the code being edited:
+	l, err := mkLambda(form.cdr.(*ConsCell), def.kind == "defmacro", &s.interp.globals)
+	if err != nil {
+		return where
+	}
+	f := lambdaForm(name, l, nil)
+	return f.description() + "\n" + where
+}
+
+// completions returns the names starting with prefix.
+func (s *lspServer) completions(prefix string) []lspCompletionItem {
+	kinds := map[string]int{}
+	for _, d := range s.allDocs() {
+		for _, def := range d.defs {
+			if _, ok := kinds[def.name]; ok || !strings.HasPrefix(def.name, prefix) {
+				continue
+			}
+			kinds[def.name] = lspCompleteFunction
+			if def.kind == "def" {
+				kinds[def.name] = lspCompleteVariable
+			}
+		}
+	}
+	for _, name := range completions(&s.interp.globals, prefix) {
+		if _, ok := kinds[name]; ok {
+			continue
+		}
+		if _, ok := replCommands[name]; ok {
+			continue
+		}
+		v, _ := s.interp.globals.Lookup(name)
+		switch t := v.(type) {
+		case *Builtin:
+			kinds[name] = lspCompleteFunction
+		case *lambdaFn:
+			kinds[name] = lspCompleteFunction
+			if t.isMacro {
+				kinds[name] = lspCompleteKeyword
+			}
+		case nil:
+			// A special form, or a builtin of a module not imported:
+			kinds[name] = lspCompleteKeyword
+			if builtins[name] != nil {
+				kinds[name] = lspCompleteFunction
+			}
+		default:
+			kinds[name] = lspCompleteVariable
+		}
+	}
+	names := []string{}
+	for name := range kinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	items := make([]lspCompletionItem, len(names))
+	for i, name := range names {
+		items[i] = lspCompletionItem{name, kinds[name]}
+	}
+	return items
+}
+
+// symbolAt returns the open document and the atom at the position given
+// by params, if there is one.
+func (s *lspServer) symbolAt(params json.RawMessage) (*lspDoc, Token, error) {
+	var p lspDocumentPosition
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, Token{}, &lspError{lspInvalidParams, err.Error()}
+	}
+	d := s.docs[p.TextDocument.URI]
+	if d == nil {
+		return nil, Token{}, nil
+	}
+	for _, tok := range d.tokens {
+		if tok.lexeme.Typ != itemAtom {
+			continue
+		}
+		r := d.tokenRange(tok)
+		if r.Start.Line == p.Position.Line &&
+			r.Start.Character <= p.Position.Character && p.Position.Character <= r.End.Character {
+			return d, tok, nil
+		}
+	}
+	return nil, Token{}, nil
+}
+
+// analyze lexes and parses the text of a document, finding what's wrong
+// with it and what it defines.
+func analyze(uri, text string) *lspDoc {
+	d := &lspDoc{uri: uri, lines: strings.Split(text, "\n"), diagnostics: []lspDiagnostic{}}
+	d.tokens = LexItemsFrom(filepath.Base(uriPath(uri)), 1, d.lines)
+	for _, tok := range d.tokens {
+		if tok.lexeme.Typ == itemError {
+			d.diagnose(tok, tok, tok.lexeme.Val)
+		}
+	}
+	// Lexing stops at an error in a line, so the rest of it can't be
+	// checked.  The index of the token closing each list, by that of its
+	// opener:
+	lexErrors := len(d.diagnostics) > 0
+	closers := map[int]int{}
+	open := []int{}
+	for i, tok := range d.tokens {
+		switch {
+		case isOpener(tok.lexeme.Typ):
+			open = append(open, i)
+		case isCloser(tok.lexeme.Typ):
+			if len(open) == 0 {
+				if !lexErrors {
+					d.diagnose(tok, tok, fmt.Sprintf("unexpected '%s'", tok.lexeme.Val))
+				}
+				continue
+			}
+			j := open[len(open)-1]
+			open = open[:len(open)-1]
+			if tok.lexeme.Typ != closerOf(d.tokens[j]) && !lexErrors {
+				d.diagnose(tok, tok, fmt.Sprintf("mismatched '%s'", tok.lexeme.Val))
+			}
+			closers[j] = i
+		}
+	}
+	for _, j := range open {
+		if !lexErrors {
+			d.diagnose(d.tokens[j], d.tokens[j], fmt.Sprintf("unclosed '%s'", d.tokens[j].lexeme.Val))
+		}
+	}
+	// end returns the index of the last token of the form starting at
+	// tokens[i], or the last token if it's not closed:
+	end := func(i int) int {
+		if j, ok := closers[i]; ok {
+			return j
+		}
+		if isOpener(d.tokens[i].lexeme.Typ) {
+			return len(d.tokens) - 1
+		}
+		return i
+	}
+	if len(d.diagnostics) == 0 {
+		// Parse each top-level form, to find any other problems with it:
+		for i := 0; i < len(d.tokens); {
+			j := i
+			for j < len(d.tokens)-1 && isPrefix(d.tokens[j].lexeme.Typ) {
+				j++
+			}
+			j = end(j)
+			if _, err := Parse(d.tokens[i : j+1]); err != nil {
+				msg := err.Error()
+				// Without the parser's functions in the way:
+				if m, ok := asCondition(err).Message().(*ConsCell); ok && m != Nil {
+					msg = unwrapList(m)
+				}
+				d.diagnose(d.tokens[i], d.tokens[j], msg)
+			}
+			i = j + 1
+		}
+	}
+	for i := 0; i+2 < len(d.tokens); i++ {
+		if d.tokens[i].lexeme.Typ != itemLeftParen || d.tokens[i+2].lexeme.Typ != itemAtom {
+			continue
+		}
+		switch kind := d.tokens[i+1].lexeme.Val; kind {
+		case "def", "defn", "defmacro":
+			j := end(i)
+			def := lspDef{name: d.tokens[i+2].lexeme.Val, kind: kind,
+				nameTok: d.tokens[i+2], start: d.tokens[i], end: d.tokens[j]}
+			if _, ok := closers[i]; ok {
+				if forms, err := Parse(d.tokens[i : j+1]); err == nil && len(forms) == 1 {
+					def.form = forms[0]
+				}
+			}
+			d.defs = append(d.defs, def)
+		}
+	}
+	return d
+}
+
+// closerOf returns the type of token which closes the list opened by tok.
+func closerOf(tok Token) lexutil.ItemType {
+	switch tok.lexeme.Typ {
+	case itemLeftBrace:
+		return itemRightBrace
+	case itemLeftBracket:
+		return itemRightBracket
+	}
+	return itemRightParen
+}
+
+// isPrefix is true for tokens applying to the form after them.
+func isPrefix(t lexutil.ItemType) bool {
+	switch t {
+	case itemForwardQuote, itemSyntaxQuote, itemUnquote, itemSplicingUnquote, itemCommentNext:
+		return true
+	}
+	return false
+}
+
+// diagnose records an error in the tokens from start to end.
+func (d *lspDoc) diagnose(start, end Token, msg string) {
+	d.diagnostics = append(d.diagnostics, lspDiagnostic{
+		Range:    lspRange{d.tokenRange(start).Start, d.tokenRange(end).End},
+		Severity: 1,
+		Source:   "l1",
+		Message:  msg,
+	})
+}
+
+// tokenRange returns where tok is in the document.  An error token covers
+// the rest of its line.
+func (d *lspDoc) tokenRange(tok Token) lspRange {
+	line := tok.line - 1
+	text := []rune(d.lines[line])
+	col := tok.col - 1
+	if col > len(text) {
+		col = len(text)
+	}
+	start := utf16Len(string(text[:col]))
+	end := start + utf16Len(tok.lexeme.Val)
+	if tok.lexeme.Typ == itemError {
+		end = utf16Len(string(text))
+	}
+	return lspRange{lspPosition{line, start}, lspPosition{line, end}}
+}
+
+// utf16Len returns the length of s in UTF-16 code units, in which the
+// protocol counts characters.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n++
+		if r >= 0x10000 {
+			n++
+		}
+	}
+	return n
+}
+
+// runeIndex returns the index of the rune in s at the given UTF-16 offset.
+func runeIndex(s string, offset int) int {
+	i, n := 0, 0
+	for _, r := range s {
+		if n >= offset {
+			break
+		}
+		n += utf16Len(string(r))
+		i++
+	}
+	return i
+}
+
+// uriPath returns the path of the file with the given URI.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// pathURI returns the URI of the file with the given path.
+func pathURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
@@ -0,0 +1,218 @@
+package lisp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lspClient talks to a language server over pipes.
+type lspClient struct {
+	t      *testing.T
+	w      io.Writer
+	r      *textproto.Reader
+	nextID int
+	done   chan error
+}
+
+func startLSP(t *testing.T) *lspClient {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &lspClient{t: t, w: inW, r: textproto.NewReader(bufio.NewReader(outR)),
+		done: make(chan error, 1)}
+	go func() {
+		c.done <- ServeLSP(inR, outW)
+		outW.Close()
+	}()
+	t.Cleanup(func() { inW.Close() })
+	return c
+}
+
+func (c *lspClient) send(msg map[string]interface{}) {
+	msg["jsonrpc"] = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// lspReply is a message from the server.
+type lspReply struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *lspError       `json:"error"`
+}
+
+// recv returns the next message from the server.
+func (c *lspClient) recv() lspReply {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	n, _ := strconv.Atoi(header.Get("Content-Length"))
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		c.t.Fatal(err)
+	}
+	var msg lspReply
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// call makes a request, returning its result, as JSON.
+func (c *lspClient) call(method string, params interface{}) string {
+	c.nextID++
+	c.send(map[string]interface{}{"id": c.nextID, "method": method, "params": params})
+	msg := c.recv()
+	if msg.ID != c.nextID {
+		c.t.Fatalf("%s: got %+v", method, msg)
+	}
+	if msg.Error != nil {
+		return msg.Error.Message
+	}
+	return string(msg.Result)
+}
+
+// open opens a document, returning the diagnostics published for it.
+func (c *lspClient) open(uri, text string) string {
+	c.send(map[string]interface{}{"method": "textDocument/didOpen",
+		"params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri, "languageId": "l1", "version": 1, "text": text}}})
+	msg := c.recv()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("got %+v", msg)
+	}
+	var params struct {
+		Diagnostics json.RawMessage `json:"diagnostics"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatal(err)
+	}
+	return string(params.Diagnostics)
+}
+
+func at(uri string, line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": char},
+	}
+}
+
+func TestLSP(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.l1")
+	if err := os.WriteFile(lib, []byte(`(defn square (x)
+  (doc (square a number)
+       (examples (square 3)))
+  (* x x))
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := startLSP(t)
+	if got := c.call("initialize", map[string]interface{}{"rootUri": pathURI(dir)}); !strings.Contains(got, `"hoverProvider":true`) {
+		t.Errorf("initialize: got %s", got)
+	}
+	c.send(map[string]interface{}{"method": "initialized", "params": map[string]interface{}{}})
+
+	uri := pathURI(filepath.Join(dir, "main.l1"))
+	src := "(def limit 10)\n(defn main ()\n(print (square limit)))\n(car 1"
+	want := `[{"range":{"start":{"line":3,"character":0},"end":{"line":3,"character":1}},` +
+		`"severity":1,"source":"l1","message":"unclosed '('"}]`
+	if got := c.open(uri, src); got != want {
+		t.Errorf("diagnostics: got %s, want %s", got, want)
+	}
+	for _, test := range []struct {
+		method string
+		params interface{}
+		want   string
+	}{
+		{"textDocument/hover", at(uri, 2, 10), "square: function, arity 1\\nArgs: (x)\\nSquare a number\\nExamples:\\n\\u003e (square 3)\\nDefined at lib.l1:1"},
+		{"textDocument/hover", at(uri, 3, 2), "car: native function, arity 1"},
+		{"textDocument/hover", at(uri, 2, 16), "limit: variable\\n(def limit 10)\\nDefined at main.l1:1"},
+		{"textDocument/definition", at(uri, 2, 10),
+			`[{"uri":"` + pathURI(lib) + `","range":{"start":{"line":0,"character":6},"end":{"line":0,"character":12}}}]`},
+		{"textDocument/completion", at(uri, 2, 11), `[{"label":"square","kind":3}]`},
+		{"textDocument/completion", at(uri, 2, 3), `{"label":"print","kind":3}`},
+		{"textDocument/completion", at(uri, 3, 2), `{"label":"car","kind":3}`},
+		{"textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]string{"uri": uri}},
+			`[{"name":"limit","detail":"def","kind":13,"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":14}},` +
+				`"selectionRange":{"start":{"line":0,"character":5},"end":{"line":0,"character":10}}},` +
+				`{"name":"main","detail":"defn","kind":12,"range":{"start":{"line":1,"character":0},"end":{"line":2,"character":23}},` +
+				`"selectionRange":{"start":{"line":1,"character":6},"end":{"line":1,"character":10}}}]`},
+		{"textDocument/formatting", map[string]interface{}{"textDocument": map[string]string{"uri": uri}},
+			`"newText":"(def limit 10)\n(defn main ()\n  (print (square limit)))\n(car 1"`},
+		{"workspace/frobnicate", map[string]interface{}{}, "unknown method workspace/frobnicate"},
+	} {
+		if got := c.call(test.method, test.params); !strings.Contains(got, test.want) {
+			t.Errorf("%s %v: got\n%s\nwant it to contain\n%s", test.method, test.params, got, test.want)
+		}
+	}
+	if got := c.open(uri, "(def limit   10)"); got != "[]" {
+		t.Errorf("diagnostics: got %s", got)
+	}
+	want = `"newText":"(def limit 10)\n"`
+	if got := c.call("textDocument/formatting", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri}}); !strings.Contains(got, want) {
+		t.Errorf("formatting: got %s, want it to contain %s", got, want)
+	}
+	c.call("shutdown", nil)
+	c.send(map[string]interface{}{"method": "exit"})
+	if err := <-c.done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLSPParseError(t *testing.T) {
+	c := startLSP(t)
+	fmt.Fprintf(c.w, "Content-Length: 9\r\n\r\n{\"id\": 1,")
+	if msg := c.recv(); msg.Error == nil || msg.Error.Code != lspParseError {
+		t.Errorf("got %+v", msg)
+	}
+	// The server carries on:
+	if got := c.call("initialize", map[string]interface{}{}); !strings.Contains(got, `"hoverProvider":true`) {
+		t.Errorf("initialize: got %s", got)
+	}
+}
+
+func TestLSPDiagnostics(t *testing.T) {
+	var tests = []struct {
+		src  string
+		want []string
+	}{
+		{"(+ 1 2)\n[1 2]", nil},
+		{"(+ 1 2))", []string{"1:7-1:8 unexpected ')'"}},
+		{"(list [1 2)]", []string{"1:10-1:11 mismatched ')'", "1:11-1:12 mismatched ']'"}},
+		{"(print \"abc)", []string{"1:6-1:12 unterminated string"}},
+		{"(ok)\n{1 2 3}", []string{"2:0-2:7 hash map literal on line 2 has an odd number of forms"}},
+		{"(λ \"😀\" (", []string{"1:0-1:1 unclosed '('", "1:8-1:9 unclosed '('"}},
+	}
+	for _, test := range tests {
+		got := []string{}
+		for _, diag := range analyze("file:///test.l1", test.src).diagnostics {
+			r := diag.Range
+			got = append(got, fmt.Sprintf("%d:%d-%d:%d %s", r.Start.Line+1, r.Start.Character,
+				r.End.Line+1, r.End.Character, diag.Message))
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%q: got %q, want %q", test.src, got, test.want)
+			continue
+		}
+		for i := range got {
+			if !strings.HasPrefix(got[i], test.want[i]) {
+				t.Errorf("%q: got %q, want %q", test.src, got, test.want)
+			}
+		}
+	}
+}
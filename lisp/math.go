@@ -2,93 +2,295 @@ package lisp
 
 import (
 	"fmt"
+	"math"
 	"math/big"
+	"strconv"
+	"strings"
 )
 
-// Number wraps a big.Int (for now)
+// numKind tells which of a Number's fields holds its value.
+type numKind int
+
+const (
+	intNum numKind = iota
+	ratNum
+	floatNum
+)
+
+// Number is an integer of arbitrary magnitude, an exact rational, or a
+// floating-point value.  The zero value is the integer 0.  Rationals are
+// kept in lowest terms, and those with a denominator of 1 are stored as
+// integers, so `(/ 4 2)` is the integer 2.  When numbers of different kinds
+// are combined, integers are promoted to rationals, and rationals to floats.
 type Number struct {
-	bi big.Int
+	kind numKind
+	bi   big.Int
+	rat  *big.Rat // shared between copies, so never modified
+	fl   float64
 }
 
 // String returns the string representation of the number.
 func (n Number) String() string {
-	return n.bi.Text(10)
+	switch n.kind {
+	case ratNum:
+		return n.rat.RatString()
+	case floatNum:
+		s := strconv.FormatFloat(n.fl, 'g', -1, 64)
+		// Make sure floats which happen to be whole numbers still read
+		// back as floats:
+		if !strings.ContainsAny(s, ".eIN") {
+			s += ".0"
+		}
+		return s
+	default:
+		return n.bi.Text(10)
+	}
+}
+
+// ratNumber returns r as a Number, as an integer if its denominator is 1.
+func ratNumber(r *big.Rat) Number {
+	if r.IsInt() {
+		var n Number
+		n.bi.Set(r.Num())
+		return n
+	}
+	return Number{kind: ratNum, rat: r}
+}
+
+// floatNumber returns f as a Number.
+func floatNumber(f float64) Number {
+	return Number{kind: floatNum, fl: f}
+}
+
+// isInt returns true if the number is an integer (not a rational or float).
+func (n Number) isInt() bool {
+	return n.kind == intNum
+}
+
+// toRat returns the value of an integer or rational as a new big.Rat.
+func (n Number) toRat() *big.Rat {
+	if n.kind == ratNum {
+		return new(big.Rat).Set(n.rat)
+	}
+	return new(big.Rat).SetInt(&n.bi)
+}
+
+// toFloat returns the (nearest) floating-point value of the number.
+func (n Number) toFloat() float64 {
+	switch n.kind {
+	case ratNum:
+		f, _ := n.rat.Float64()
+		return f
+	case floatNum:
+		return n.fl
+	default:
+		f, _ := new(big.Float).SetInt(&n.bi).Float64()
+		return f
+	}
+}
+
+// arith applies one of three versions of an arithmetic operation to n and
+// o, depending on the kinds of the two numbers.
+func (n Number) arith(o Number,
+	intOp func(z, x, y *big.Int) *big.Int,
+	ratOp func(z, x, y *big.Rat) *big.Rat,
+	floatOp func(x, y float64) float64) Number {
+	switch {
+	case n.kind == floatNum || o.kind == floatNum:
+		return floatNumber(floatOp(n.toFloat(), o.toFloat()))
+	case n.kind == ratNum || o.kind == ratNum:
+		return ratNumber(ratOp(new(big.Rat), n.toRat(), o.toRat()))
+	default:
+		var ret Number
+		intOp(&ret.bi, &n.bi, &o.bi)
+		return ret
+	}
 }
 
 // Add returns the sum of the two numbers.
 func (n Number) Add(o Number) Number {
-	var ni big.Int = n.bi
-	result := big.NewInt(0)
-	result.Add(&ni, &o.bi)
-	return Number{*result}
+	return n.arith(o, (*big.Int).Add, (*big.Rat).Add,
+		func(x, y float64) float64 { return x + y })
 }
 
 // Sub returns the difference of the two numbers.
 func (n Number) Sub(o Number) Number {
-	var ni big.Int = n.bi
-	result := big.NewInt(0)
-	result.Sub(&ni, &o.bi)
-	return Number{*result}
+	return n.arith(o, (*big.Int).Sub, (*big.Rat).Sub,
+		func(x, y float64) float64 { return x - y })
 }
 
 // Mul returns the product of the two numbers.
 func (n Number) Mul(o Number) Number {
-	var ni big.Int = n.bi
-	result := big.NewInt(0)
-	result.Mul(&ni, &o.bi)
-	return Number{*result}
+	return n.arith(o, (*big.Int).Mul, (*big.Rat).Mul,
+		func(x, y float64) float64 { return x * y })
 }
 
-// Div returns the (integer) quotient of the two numbers.
+// Div returns the quotient of the two numbers, which is exact (possibly a
+// rational) unless either is a float.  o must not be zero.
 func (n Number) Div(o Number) Number {
-	var ni big.Int = n.bi
-	result := big.NewInt(0)
-	result.Div(&ni, &o.bi)
-	return Number{*result}
+	if n.kind != floatNum && o.kind != floatNum {
+		return ratNumber(new(big.Rat).Quo(n.toRat(), o.toRat()))
+	}
+	return floatNumber(n.toFloat() / o.toFloat())
 }
 
-// Rem returns the remainder of the division of two numbers.
+// Rem returns the remainder of the division of two integers.
 func (n Number) Rem(o Number) Number {
-	var ni big.Int = n.bi
-	result := big.NewInt(0)
-	result.Rem(&ni, &o.bi)
-	return Number{*result}
+	var ret Number
+	ret.bi.Rem(&n.bi, &o.bi)
+	return ret
+}
+
+// cmp compares n and o, returning -1, 0 or 1, and false if they cannot be
+// compared (because one of them is NaN).
+func (n Number) cmp(o Number) (int, bool) {
+	switch {
+	case n.kind == intNum && o.kind == intNum:
+		return n.bi.Cmp(&o.bi), true
+	case n.kind == floatNum && o.kind == floatNum:
+		if math.IsNaN(n.fl) || math.IsNaN(o.fl) {
+			return 0, false
+		}
+		switch {
+		case n.fl < o.fl:
+			return -1, true
+		case n.fl > o.fl:
+			return 1, true
+		}
+		return 0, true
+	case o.kind == floatNum:
+		c, ok := o.cmp(n)
+		return -c, ok
+	case n.kind == floatNum:
+		// Compare exactly, rather than rounding o to a float:
+		if math.IsNaN(n.fl) {
+			return 0, false
+		}
+		if math.IsInf(n.fl, 0) {
+			return int(math.Copysign(1, n.fl)), true
+		}
+		return new(big.Rat).SetFloat64(n.fl).Cmp(o.toRat()), true
+	default:
+		return n.toRat().Cmp(o.toRat()), true
+	}
 }
 
-// Equal returns true if the two numbers are equal.
+// Equal returns true if the two numbers are equal; integers, rationals and
+// floats with the same value are equal.
 func (n Number) Equal(o Sexpr) bool {
 	if o, ok := o.(Number); ok {
-		return n.bi.Cmp(&o.bi) == 0
+		c, ok := n.cmp(o)
+		return ok && c == 0
 	}
 	return false
 }
 
 // Less returns true if the first number is less than the second.
 func (n Number) Less(o Number) bool {
-	return n.bi.Cmp(&o.bi) < 0
+	c, ok := n.cmp(o)
+	return ok && c < 0
 }
 
 // LessEqual returns true if the first number is <= the second.
 func (n Number) LessEqual(o Number) bool {
-	return n.bi.Cmp(&o.bi) <= 0
+	c, ok := n.cmp(o)
+	return ok && c <= 0
 }
 
 // Greater returns true if the first number is greater than the second.
 func (n Number) Greater(o Number) bool {
-	return n.bi.Cmp(&o.bi) > 0
+	c, ok := n.cmp(o)
+	return ok && c > 0
 }
 
 // GreaterEqual returns true if the first number is >= the second.
 func (n Number) GreaterEqual(o Number) bool {
-	return n.bi.Cmp(&o.bi) >= 0
+	c, ok := n.cmp(o)
+	return ok && c >= 0
 }
 
 // Neg returns the negative of the number.
 func (n Number) Neg() Number {
-	var ni big.Int = n.bi
-	result := big.NewInt(0)
-	result.Neg(&ni)
-	return Number{*result}
+	switch n.kind {
+	case ratNum:
+		return ratNumber(new(big.Rat).Neg(n.rat))
+	case floatNum:
+		return floatNumber(-n.fl)
+	default:
+		var ret Number
+		ret.bi.Neg(&n.bi)
+		return ret
+	}
+}
+
+// Floor returns the greatest integer not greater than the number.
+func (n Number) Floor() (Number, error) {
+	switch n.kind {
+	case ratNum:
+		var ret Number
+		// Div on big.Int rounds toward negative infinity for positive
+		// denominators, which rationals always have:
+		ret.bi.Div(n.rat.Num(), n.rat.Denom())
+		return ret, nil
+	case floatNum:
+		return floatToInt(math.Floor(n.fl))
+	default:
+		return n, nil
+	}
+}
+
+// Round returns the integer nearest the number, rounding halves away from
+// zero.
+func (n Number) Round() (Number, error) {
+	switch n.kind {
+	case ratNum:
+		// Add or subtract 1/2, then truncate toward zero:
+		half := big.NewRat(1, 2)
+		if n.rat.Sign() < 0 {
+			half.Neg(half)
+		}
+		r := new(big.Rat).Add(n.rat, half)
+		var ret Number
+		ret.bi.Quo(r.Num(), r.Denom())
+		return ret, nil
+	case floatNum:
+		return floatToInt(math.Round(n.fl))
+	default:
+		return n, nil
+	}
+}
+
+// floatToInt converts a whole-numbered float to an integer.
+func floatToInt(f float64) (Number, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return Number{}, baseErrorf("cannot convert %s to an integer", floatNumber(f))
+	}
+	var ret Number
+	new(big.Float).SetFloat64(f).Int(&ret.bi)
+	return ret, nil
+}
+
+// parseNumber parses an integer (`12`), rational (`-1/3`) or float (`1.5`,
+// `1e-9`), returning false if s is not a valid number.
+func parseNumber(s string) (Number, bool) {
+	var n Number
+	switch {
+	case strings.Contains(s, "/"):
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return n, false
+		}
+		return ratNumber(r), true
+	case strings.ContainsAny(s, ".eE"):
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return n, false
+		}
+		return floatNumber(f), true
+	default:
+		_, ok := n.bi.SetString(s, 10)
+		return n, ok
+	}
 }
 
 // Num is a `num` constructor, which can take a string or a
@@ -97,9 +299,13 @@ func Num(ob interface{}) Number {
 	var n Number
 	switch s := ob.(type) {
 	case string:
-		n.bi.SetString(s, 10)
+		n, _ = parseNumber(s)
 	case int:
 		n.bi.SetInt64(int64(s))
+	case int64:
+		n.bi.SetInt64(s)
+	case float64:
+		n = floatNumber(s)
 	default:
 		panic(fmt.Sprintf("Num: unknown type %T\n", ob))
 	}
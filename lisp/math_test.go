@@ -15,6 +15,13 @@ func TestNumConstruction(t *testing.T) {
 		{"12948712498129877", "12948712498129877"},
 		{-1, "-1"},
 		{"-1", "-1"},
+		{"+7", "7"},
+		{"2/4", "1/2"},
+		{"-6/3", "-2"},
+		{"1.5", "1.5"},
+		{"1e-9", "1e-09"},
+		{"2E3", "2000.0"},
+		{-0.25, "-0.25"},
 	}
 	for _, test := range tests {
 		if Num(test.input).String() != test.output {
@@ -37,6 +44,12 @@ func TestBinaryOps(t *testing.T) {
 		{Num(1), "-", Num(2), Num(-1)},
 		{Num(9999), "*", Num(666), Num(666 * 9999)},
 		{Num(2), "/", Num(2), Num(1)},
+		{Num(1), "/", Num(3), Num("1/3")},
+		{Num("1/3"), "+", Num("2/3"), Num(1)},
+		{Num("1/2"), "*", Num(4), Num(2)},
+		{Num("1/2"), "-", Num(0.25), Num(0.25)},
+		{Num(1), "/", Num(4.0), Num(0.25)},
+		{Num(3), "*", Num(0.5), Num(1.5)},
 	}
 	for _, test := range tests {
 		f := map[string](func(Number, Number) Number){
@@ -72,3 +85,63 @@ func TestUnaryOps(t *testing.T) {
 		t.Logf("Num(9999999999999).Neg() == Num(-9999999999999)")
 	}
 }
+
+func TestNumberKinds(t *testing.T) {
+	if got := Num(1).Div(Num(3)); got.isInt() || got.String() != "1/3" {
+		t.Errorf("1 / 3 = %s, want exact 1/3", got)
+	}
+	if got := Num(4).Div(Num(2)); !got.isInt() {
+		t.Errorf("4 / 2 = %s, want an integer", got)
+	}
+	if got := Num("1/2").Add(Num("1/2")); !got.isInt() {
+		t.Errorf("1/2 + 1/2 = %s, want an integer", got)
+	}
+	if got := Num(1).Add(Num(0.5)); got.kind != floatNum {
+		t.Errorf("1 + 0.5 = %s, want a float", got)
+	}
+	if !Num(1).Equal(Num(1.0)) || !Num("1/2").Equal(Num(0.5)) {
+		t.Error("numbers of different kinds with the same value should be equal")
+	}
+	if !Num("1/3").Less(Num(0.34)) || !Num(0.33).Less(Num("1/3")) {
+		t.Error("comparisons between rationals and floats are wrong")
+	}
+	// 2^53 + 1 can't be represented as a float, but is still greater than
+	// 2^53:
+	if !Num("9007199254740993").Greater(Num(9007199254740992.0)) {
+		t.Error("comparisons between big integers and floats should be exact")
+	}
+	nan := Num(0.0).Div(Num(0.0))
+	if nan.Equal(nan) || nan.Less(Num(1)) || Num(1).Less(nan) {
+		t.Error("NaN should not be equal to, or ordered with, anything")
+	}
+}
+
+func TestRounding(t *testing.T) {
+	var tests = []struct {
+		input Number
+		floor string
+		round string
+	}{
+		{Num(3), "3", "3"},
+		{Num("7/2"), "3", "4"},
+		{Num("-7/2"), "-4", "-4"},
+		{Num("-5/3"), "-2", "-2"},
+		{Num(2.5), "2", "3"},
+		{Num(-2.5), "-3", "-3"},
+		{Num(1e20), "100000000000000000000", "100000000000000000000"},
+	}
+	for _, test := range tests {
+		floor, err := test.input.Floor()
+		if err != nil || floor.String() != test.floor || !floor.isInt() {
+			t.Errorf("floor of %s = %s (%v), want %s", test.input, floor, err, test.floor)
+		}
+		round, err := test.input.Round()
+		if err != nil || round.String() != test.round || !round.isInt() {
+			t.Errorf("round of %s = %s (%v), want %s", test.input, round, err, test.round)
+		}
+	}
+	inf := Num(1).Div(Num(0.0))
+	if _, err := inf.Floor(); err == nil {
+		t.Error("expected error taking floor of infinity")
+	}
+}
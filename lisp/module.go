@@ -0,0 +1,362 @@
+package lisp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Modules keep the definitions made by different libraries apart.  The body
+// of a `module` form runs in a top-level environment of its own, so its
+// `def`s and `defn`s don't touch anyone else's, though it can use the
+// definitions made where it's defined (such as those of the core library).
+// The names a module exports with `export` can be used elsewhere once the
+// module is imported with `import`, as alias/name; they can also be used as
+// module/name, as in the code macros expand to.  The templates of macros
+// defined outside modules likewise call top-level functions as l1/name
+// (see qualifyTemplates), so that local bindings where the macros are used
+// don't capture them.
+//
+// `import` finds a module it doesn't know yet in a file named for it, in
+// one of the directories on the search path: those given to the interpreter
+// (see WithSearchPath), then those in the L1PATH environment variable, then
+// the current directory.  Each file is evaluated once, however many times
+// it is imported, or loaded with `load` (though the REPL's :load and
+// :reload evaluate files again, on purpose).
+
+// topLevel is the qualifier of top-level definitions, which can't be the
+// name of a module.
+const topLevel = "l1"
+
+// Module is a namespace made by a `module` form.
+type Module struct {
+	name    string
+	env     *Env // the module's top-level environment
+	mu      sync.Mutex
+	exports map[symbol]bool
+}
+
+// String returns a representation of the module.
+func (m *Module) String() string {
+	return "<module " + m.name + ">"
+}
+
+// Equal returns true if o is the same module.
+func (m *Module) Equal(o Sexpr) bool {
+	o2, ok := o.(*Module)
+	return ok && m == o2
+}
+
+func (m *Module) export(name symbol) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exports[name] = true
+}
+
+// lookup returns the value of name in m, which must export it unless it's
+// looked up from within m.
+func (m *Module) lookup(name string, from *globals) (Sexpr, error) {
+	s, ok := lookupSymbol(name)
+	m.mu.Lock()
+	exported := ok && m.exports[s]
+	m.mu.Unlock()
+	if !exported && from.module != m {
+		return nil, typedErrorf("unknown-symbol", Atom{m.name + "/" + name},
+			"module %s does not export %s", m.name, name)
+	}
+	if v := m.env.lookup(s); v != nil {
+		return v, nil
+	}
+	return nil, typedErrorf("unknown-symbol", Atom{m.name + "/" + name},
+		"module %s exports %s, but does not define it", m.name, name)
+}
+
+// modules is the registry of the modules known to an interpreter, shared by
+// its top-level environment and those of its modules.
+type modules struct {
+	mu      sync.Mutex
+	byName  map[string]*Module
+	files   map[string]*Module // by the resolved path of the file which defined them
+	loaded  map[string]bool    // files evaluated by load
+	loading map[string]bool    // files being evaluated
+	path    []string           // the directories to search for modules' files
+	top     *globals           // where modules' files are evaluated
+}
+
+func newModules(top *globals) *modules {
+	return &modules{
+		byName:  map[string]*Module{},
+		files:   map[string]*Module{},
+		loaded:  map[string]bool{},
+		loading: map[string]bool{},
+		path:    searchPath(nil),
+		top:     top,
+	}
+}
+
+// WithSearchPath adds directories to search for the files of modules, ahead
+// of those in L1PATH.
+func WithSearchPath(dirs ...string) Option {
+	return func(i *Interpreter) {
+		i.searchPath = append(i.searchPath, dirs...)
+	}
+}
+
+// searchPath returns the directories to search for modules' files: dirs,
+// then those in L1PATH, then the current directory.
+func searchPath(dirs []string) []string {
+	path := append([]string{}, dirs...)
+	if env := os.Getenv("L1PATH"); env != "" {
+		path = append(path, filepath.SplitList(env)...)
+	}
+	return append(path, ".")
+}
+
+// evModule evaluates `(module name . body)`, making the module if it
+// doesn't exist yet, and evaluating the body in it.
+func evModule(args *ConsCell, e *Env) (Sexpr, error) {
+	if args == Nil {
+		return nil, baseError("module requires a name")
+	}
+	name, ok := args.car.(Atom)
+	if !ok || strings.Contains(name.s, "/") || name.s == topLevel {
+		return nil, typedErrorf("type-error", args.car, "'%s' is not a module name", args.car)
+	}
+	body, err := consToExprs(args.cdr)
+	if err != nil {
+		return nil, baseError("module body must be a list")
+	}
+	reg := e.globals.modules
+	reg.mu.Lock()
+	m := reg.byName[name.s]
+	if m == nil {
+		m = &Module{name: name.s, exports: map[symbol]bool{}}
+		m.env = &Env{
+			globals: &globals{parent: e.globals, module: m, modules: reg},
+			interp:  e.interp,
+		}
+		reg.byName[name.s] = m
+	}
+	reg.mu.Unlock()
+	for _, form := range body {
+		if _, err := eval(form, m.env); err != nil {
+			return nil, extendError("module "+name.s, err)
+		}
+	}
+	return m, nil
+}
+
+// evExport evaluates `(export . names)`, exporting the names from the
+// module it's evaluated in.
+func evExport(args *ConsCell, e *Env) (Sexpr, error) {
+	m := e.globals.module
+	if m == nil {
+		return nil, baseError("export outside a module")
+	}
+	names, err := consToExprs(args)
+	if err != nil {
+		return nil, baseError("export requires a list of names")
+	}
+	for _, name := range names {
+		a, ok := name.(Atom)
+		if !ok {
+			return nil, typedErrorf("type-error", name, "'%s' is not a name", name)
+		}
+		m.export(intern(a.s))
+	}
+	return Nil, nil
+}
+
+// evImport evaluates `(import name)` or `(import name :as alias)`, making
+// the module's exports available where it's evaluated as alias/name (or
+// name/name, without an alias).
+func evImport(args *ConsCell, e *Env) (Sexpr, error) {
+	parts, err := consToExprs(args)
+	if err != nil || (len(parts) != 1 && len(parts) != 3) ||
+		(len(parts) == 3 && !parts[1].Equal(Atom{":as"})) {
+		return nil, baseError("import requires a module name, and optionally :as and an alias")
+	}
+	name, ok := parts[0].(Atom)
+	if !ok {
+		return nil, typedErrorf("type-error", parts[0], "'%s' is not a module name", parts[0])
+	}
+	alias := name
+	if len(parts) == 3 {
+		if alias, ok = parts[2].(Atom); !ok || strings.Contains(alias.s, "/") || alias.s == topLevel {
+			return nil, typedErrorf("type-error", parts[2], "'%s' is not an alias", parts[2])
+		}
+	}
+	m, err := e.globals.modules.find(name.s, e)
+	if err != nil {
+		return nil, extendError("import", err)
+	}
+	e.globals.importAs(alias.s, m)
+	return Nil, nil
+}
+
+// find returns the module with the given name, evaluating the file which
+// defines it if need be.
+func (reg *modules) find(name string, e *Env) (*Module, error) {
+	reg.mu.Lock()
+	m := reg.byName[name]
+	reg.mu.Unlock()
+	if m != nil {
+		return m, nil
+	}
+	if e.interp != nil && e.interp.policy.denies(Filesystem) {
+		return nil, typedErrorf("capability-denied", Atom{string(Filesystem)},
+			"import of module %s from a file needs the filesystem capability, which is denied", name)
+	}
+	path, err := reg.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	reg.mu.Lock()
+	if m := reg.files[path]; m != nil {
+		reg.mu.Unlock()
+		return m, nil
+	}
+	if reg.loading[path] {
+		reg.mu.Unlock()
+		return nil, baseErrorf("circular import of module %s", name)
+	}
+	reg.loading[path] = true
+	reg.mu.Unlock()
+	err = reg.evalFile(path, e)
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.loading, path)
+	if err != nil {
+		return nil, err
+	}
+	if m = reg.byName[name]; m == nil {
+		return nil, baseErrorf("%s does not define module %s", path, name)
+	}
+	reg.files[path] = m
+	return m, nil
+}
+
+// load evaluates the named file in e, unless it has been loaded or
+// imported already.
+func (reg *modules) load(filename string, e *Env) error {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	reg.mu.Lock()
+	if reg.loaded[path] || reg.files[path] != nil {
+		reg.mu.Unlock()
+		return nil
+	}
+	if reg.loading[path] {
+		reg.mu.Unlock()
+		return baseErrorf("circular load of %s", filename)
+	}
+	reg.loading[path] = true
+	reg.mu.Unlock()
+	err = LoadFile(e, filename)
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.loading, path)
+	if err == nil {
+		reg.loaded[path] = true
+	}
+	return err
+}
+
+// resolve returns the path of the file defining the named module.
+func (reg *modules) resolve(name string) (string, error) {
+	for _, dir := range reg.path {
+		path, err := filepath.Abs(filepath.Join(dir, name+".l1"))
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+		return path, nil
+	}
+	return "", baseErrorf("module %s not found in %s", name, strings.Join(reg.path, string(filepath.ListSeparator)))
+}
+
+// evalFile evaluates the file at path in the top-level environment.
+func (reg *modules) evalFile(path string, e *Env) error {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return lexParseEvalFile(path, string(bytes), &Env{globals: reg.top, interp: e.interp})
+}
+
+// importAs makes m available in g as alias.
+func (g *globals) importAs(alias string, m *Module) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.imports == nil {
+		g.imports = map[string]*Module{}
+	}
+	g.imports[alias] = m
+}
+
+// imported returns the module imported into g, or a parent, as alias.
+func (g *globals) imported(alias string) *Module {
+	for ; g != nil; g = g.parent {
+		g.mu.Lock()
+		m := g.imports[alias]
+		g.mu.Unlock()
+		if m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+// qualifier splits s, if it's of the form alias/name, returning the module
+// alias names and name: a module imported into g as alias or, failing
+// that, the module called alias (as in the code templates of macros make;
+// see syntaxQuote).  It returns a nil module if s isn't of that form.
+func (g *globals) qualifier(s string) (*Module, string) {
+	i := strings.IndexByte(s, '/')
+	if i <= 0 || i == len(s)-1 {
+		return nil, ""
+	}
+	m := g.imported(s[:i])
+	if m == nil {
+		g.modules.mu.Lock()
+		m = g.modules.byName[s[:i]]
+		g.modules.mu.Unlock()
+	}
+	return m, s[i+1:]
+}
+
+// topLevelName returns name, if s is of the form l1/name.
+func topLevelName(s string) (string, bool) {
+	name, ok := strings.CutPrefix(s, topLevel+"/")
+	return name, ok && name != ""
+}
+
+// qualified returns the value of s, if it's of the form alias/name, where
+// alias names a module, or l1/name; it returns nil, with no error, if it's
+// not.
+func (e *Env) qualified(s string) (Sexpr, error) {
+	if name, ok := topLevelName(s); ok {
+		if sym, ok := lookupSymbol(name); ok {
+			if v := e.globals.modules.top.get(sym); v != nil {
+				return v, nil
+			}
+		}
+		return nil, typedErrorf("unknown-symbol", Atom{s}, "unknown symbol: %s", s)
+	}
+	m, name := e.globals.qualifier(s)
+	if m == nil {
+		return nil, nil
+	}
+	return m.lookup(name, e.globals)
+}
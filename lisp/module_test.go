@@ -0,0 +1,121 @@
+package lisp
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"util.l1": `
+(printl '(loading util))
+(module util
+  (export double)
+  (defn double (x) (* 2 x)))`,
+		"shapes.l1": `
+(module shapes
+  (import util)
+  (export area)
+  (defn area (r) (util/double (* r r))))`,
+		"empty.l1": "(def x 1)",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "util.l1"), filepath.Join(dir, "utils.l1")); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	interp, err := NewInterpreter(WithStdout(&out), WithSearchPath(t.TempDir(), dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := interp.Eval(`
+(import shapes :as s)
+(import util :as u)
+(import utils)
+(list (s/area 3) (u/double 4))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "(18 8)" {
+		t.Errorf("got %s, want (18 8)", got)
+	}
+	// util.l1 was evaluated once, though it was imported three times, once
+	// through a link:
+	if n := strings.Count(out.String(), "loading util"); n != 1 {
+		t.Errorf("util.l1 was evaluated %d times", n)
+	}
+	_, err = interp.Eval("(import empty)")
+	if err == nil || !strings.Contains(err.Error(), "does not define module empty") {
+		t.Errorf("got %v", err)
+	}
+	_, err = interp.Eval("(import missing)")
+	if err == nil || !strings.Contains(err.Error(), "module missing not found") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestSearchPathFromEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	src := "(module greeting (export hello) (def hello 'hi))"
+	if err := os.WriteFile(filepath.Join(dir, "greeting.l1"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("L1PATH", dir)
+	interp, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := interp.Eval("(import greeting :as g) g/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "hi" {
+		t.Errorf("got %s, want hi", got)
+	}
+	// Importing files needs the filesystem:
+	strict, err := NewInterpreter(WithPolicy(StrictPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = strict.Eval("(import greeting)")
+	if c, ok := err.(*Condition); !ok || c.Type().s != "capability-denied" {
+		t.Errorf("got %v, want capability-denied", err)
+	}
+}
+
+func TestLoadOnce(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.l1")
+	if err := os.WriteFile(lib, []byte("(printl '(loading lib))\n(def n (inc n))\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	interp, err := NewInterpreter(WithStdout(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := interp.Eval(fmt.Sprintf("(def n 0) (load %q) (load %q) (load %q) n",
+		lib, filepath.Join(dir, ".", "lib.l1"), lib))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "1" || out.String() != "loading lib\n" {
+		t.Errorf("got %s, with output %q; lib.l1 should be evaluated once", got, out.String())
+	}
+	// The REPL's :load, however, evaluates the file again:
+	if err := interp.replLoad(lib); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := interp.Eval("n"); got.String() != "2" {
+		t.Errorf(":load: got n = %s, want 2", got)
+	}
+}
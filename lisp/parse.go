@@ -1,27 +1,45 @@
 package lisp
 
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/eigenhombre/lexutil"
+)
+
 func handleQuoteItem(tokens []Token, i int, operatorName string) (Sexpr, int, error) {
 	if i >= len(tokens) {
-		return nil, 0, baseErrorf("unexpected end of input; index=%d, tokens=%v", i, tokens)
+		return nil, 0, typedErrorf("parse-error", Nil, "unexpected end of input; index=%d, tokens=%v", i, tokens)
 	}
 	nextParsed, incr, err := parseNext(tokens, i)
 	if err != nil {
 		return nil, 0, extendError("handleQuoteItem parseNext", err)
 	}
 	item := Cons(Atom{operatorName}, Cons(nextParsed, Nil))
+	setPos(item, tokens[i-1])
 	return item, incr, nil
 }
 
 func parseNext(tokens []Token, i int) (Sexpr, int, error) {
 	if i >= len(tokens) {
-		return nil, 0, baseErrorf("unexpected end of input; index=%d, tokens=%v", i, tokens)
+		return nil, 0, typedErrorf("parse-error", Nil, "unexpected end of input; index=%d, tokens=%v", i, tokens)
 	}
 	token := tokens[i]
 	switch token.lexeme.Typ {
 	case itemNumber:
-		return Num(token.lexeme.Val), 1, nil
+		n, ok := parseNumber(token.lexeme.Val)
+		if !ok {
+			return nil, 0, typedErrorf("parse-error", Nil, "bad number %s on line %d", token.lexeme.Val, token.line)
+		}
+		return n, 1, nil
 	case itemAtom:
 		return Atom{token.lexeme.Val}, 1, nil
+	case itemString:
+		str, err := strconv.Unquote(token.lexeme.Val)
+		if err != nil {
+			return nil, 0, typedErrorf("parse-error", Nil, "bad string %s on line %d", token.lexeme.Val, token.line)
+		}
+		return String{str}, 1, nil
 	case itemForwardQuote:
 		item, incr, err := handleQuoteItem(tokens, i+1, "quote")
 		if err != nil {
@@ -59,9 +77,29 @@ func parseNext(tokens []Token, i int) (Sexpr, int, error) {
 		}
 		return item, incr, nil
 	case itemRightParen:
-		return nil, 0, baseErrorf("unexpected right paren on line %d", token.line)
+		return nil, 0, typedErrorf("parse-error", Nil, "unexpected right paren on line %d", token.line)
+	case itemLeftBrace:
+		item, incr, err := parseHashMap(tokens[i:])
+		if err != nil {
+			return nil, 0, extendError("parseNext itemLeftBrace parseHashMap", err)
+		}
+		return item, incr, nil
+	case itemRightBrace:
+		return nil, 0, typedErrorf("parse-error", Nil, "unexpected right brace on line %d", token.line)
+	case itemLeftBracket:
+		chunkEnd, err := closingChunk(tokens[i:], itemRightBracket)
+		if err != nil {
+			return nil, 0, extendError("parseNext itemLeftBracket closingChunk", err)
+		}
+		items, err := Parse(tokens[i+1 : i+chunkEnd])
+		if err != nil {
+			return nil, 0, extendError("parseNext itemLeftBracket", err)
+		}
+		return &Vector{items}, chunkEnd + 1, nil
+	case itemRightBracket:
+		return nil, 0, typedErrorf("parse-error", Nil, "unexpected right bracket on line %d", token.line)
 	default:
-		return nil, 0, baseErrorf("unexpected lexeme '%s' on line %d", token.lexeme.Val, token.line)
+		return nil, 0, typedErrorf("parse-error", Nil, "unexpected lexeme '%s' on line %d", token.lexeme.Val, token.line)
 	}
 }
 
@@ -106,51 +144,112 @@ func parseList(tokens []Token) (Sexpr, int, error) {
 		if err != nil {
 			return nil, 0, err
 		}
-		return mkListAsConsWithCdr(carList, cdrList[0]), chunkEnd + chunk2End + 1, nil
+		ret := mkListAsConsWithCdr(carList, cdrList[0])
+		setPos(ret, tokens[0])
+		return ret, chunkEnd + chunk2End + 1, nil
+	}
+	contents, err := Parse(tokens[1:chunkEnd])
+	if err != nil {
+		return nil, 0, err
+	}
+	ret := mkListAsConsWithCdr(contents, Nil)
+	setPos(ret, tokens[0])
+	return ret, chunkEnd + 1, nil
+}
+
+// parseHashMap is used when a hash map literal has been detected in a slice
+// of tokens.
+func parseHashMap(tokens []Token) (Sexpr, int, error) {
+	chunkEnd, err := closingChunk(tokens, itemRightBrace)
+	if err != nil {
+		return nil, 0, err
 	}
 	contents, err := Parse(tokens[1:chunkEnd])
 	if err != nil {
 		return nil, 0, err
 	}
-	return mkListAsConsWithCdr(contents, Nil), chunkEnd + 1, nil
+	if len(contents)%2 != 0 {
+		return nil, 0, typedErrorf("parse-error", Nil, "hash map literal on line %d has an odd number of forms",
+			tokens[0].line)
+	}
+	h := mkHashMap()
+	for i := 0; i < len(contents); i += 2 {
+		if err := h.set(contents[i], contents[i+1]); err != nil {
+			return nil, 0, extendError(fmt.Sprintf("hash map literal on line %d", tokens[0].line), err)
+		}
+	}
+	return h, chunkEnd + 1, nil
+}
+
+// closingChunk returns the index of the token which closes the collection
+// opened by the first token; that token must be of type closer.
+func closingChunk(tokens []Token, closer lexutil.ItemType) (int, error) {
+	level := 0
+	for i, token := range tokens {
+		switch {
+		case isOpener(token.lexeme.Typ):
+			level++
+		case isCloser(token.lexeme.Typ):
+			level--
+			if level == 0 {
+				if token.lexeme.Typ != closer {
+					return 0, typedErrorf("parse-error", Nil, "mismatched '%s' on line %d", token.lexeme.Val, token.line)
+				}
+				return i, nil
+			}
+		}
+	}
+	if closer == itemRightBracket {
+		return 0, typedError("parse-error", Nil, "unbalanced brackets")
+	}
+	return 0, typedError("parse-error", Nil, "unbalanced braces")
 }
 
 func lexAndParse(ss []string) ([]Sexpr, error) {
 	return Parse(LexItems(ss))
 }
 
+// lexAndParseFile is like lexAndParse, but records the file name in the
+// positions of the parsed forms.
+func lexAndParseFile(filename string, ss []string) ([]Sexpr, error) {
+	return Parse(LexItemsFrom(filename, 1, ss))
+}
+
 func listChunk(tokens []Token) (int, Token, error) {
 	level := 0
 	for i, token := range tokens {
-		switch token.lexeme.Typ {
-		case itemLeftParen:
+		switch {
+		case isOpener(token.lexeme.Typ):
 			level++
-		case itemRightParen:
+		case isCloser(token.lexeme.Typ):
 			level--
 			if level == 0 {
+				if token.lexeme.Typ != itemRightParen {
+					return 0, Token{}, typedErrorf("parse-error", Nil, "mismatched '%s' on line %d", token.lexeme.Val, token.line)
+				}
 				return i, token, nil
 			}
-		case itemDot:
+		case token.lexeme.Typ == itemDot:
 			if level == 1 {
 				return i, token, nil
 			}
 		}
 	}
-	return 0, Token{}, baseError("unbalanced parens")
+	return 0, Token{}, typedError("parse-error", Nil, "unbalanced parens")
 }
 
 func dotChunk(tokens []Token) (int, error) {
 	level := 1
 	for i, token := range tokens {
-		switch token.lexeme.Typ {
-		case itemLeftParen:
+		switch {
+		case isOpener(token.lexeme.Typ):
 			level++
-		case itemRightParen:
+		case isCloser(token.lexeme.Typ):
 			level--
 			if level == 0 {
 				return i, nil
 			}
 		}
 	}
-	return 0, baseError("unbalanced parens")
+	return 0, typedError("parse-error", Nil, "unbalanced parens")
 }
@@ -8,6 +8,16 @@ import (
 
 func TestParse(t *testing.T) {
 	OK := ""
+	H := func(xs ...Sexpr) *HashMap {
+		h := mkHashMap()
+		for i := 0; i < len(xs); i += 2 {
+			h.set(xs[i], xs[i+1])
+		}
+		return h
+	}
+	V := func(xs ...Sexpr) *Vector {
+		return &Vector{append([]Sexpr{}, xs...)}
+	}
 	var tests = []struct {
 		input string
 		want  Sexpr
@@ -15,7 +25,16 @@ func TestParse(t *testing.T) {
 	}{
 		{"()", Nil, OK},
 		{"a", Atom{"a"}, OK},
+		{`"a b"`, String{"a b"}, OK},
+		{`"tab\there \"quoted\" \u00e9"`, String{"tab\there \"quoted\" é"}, OK},
+		{`("x" y)`, Cons(String{"x"}, Cons(Atom{"y"}, Nil)), OK},
+		{`"\q"`, Nil, "bad string"},
+		{`"unterminated`, Nil, "unterminated string"},
 		{"(1)", Cons(Num(1), Nil), OK},
+		{"2/6", Num("1/3"), OK},
+		{"1e3", Num(1000.0), OK},
+		{"1/0", Nil, "bad number"},
+		{"1e999", Nil, "bad number"},
 		{"(a b)", Cons(Atom{"a"}, Cons(Atom{"b"}, Nil)), OK},
 		{"(a . b)", Cons(Atom{"a"}, Atom{"b"}), OK},
 		{"((a . b))", Cons(Cons(Atom{"a"}, Atom{"b"}), Nil), OK},
@@ -52,6 +71,23 @@ func TestParse(t *testing.T) {
 		// ... and that it reports line number correctly:
 		{"1\n#!/bin/bash", Nil, "on line 2"},
 		{")", Nil, "unexpected right paren"},
+		{"{}", H(), OK},
+		{"{a 1 (b c) {}}", H(Atom{"a"}, Num(1), Cons(Atom{"b"}, Cons(Atom{"c"}, Nil)), H()), OK},
+		{"({a\n1})", Cons(H(Atom{"a"}, Num(1)), Nil), OK},
+		{"{a 1 a 2}", H(Atom{"a"}, Num(2)), OK},
+		{"{a}", Nil, "odd number of forms"},
+		{"{a 1", Nil, "unbalanced braces"},
+		{"(a}", Nil, "mismatched '}'"},
+		{"{a)", Nil, "mismatched ')'"},
+		{"}", Nil, "unexpected right brace"},
+		{"{{} 1}", Nil, "cannot be used as a hash key"},
+		{"[]", V(), OK},
+		{"[a 1 (b c) []]", V(Atom{"a"}, Num(1), Cons(Atom{"b"}, Cons(Atom{"c"}, Nil)), V()), OK},
+		{"([a\n1] {b [2]})", Cons(V(Atom{"a"}, Num(1)), Cons(H(Atom{"b"}, V(Num(2))), Nil)), OK},
+		{"[a 1", Nil, "unbalanced brackets"},
+		{"(a]", Nil, "mismatched ']'"},
+		{"[a)", Nil, "mismatched ')'"},
+		{"]", Nil, "unexpected right bracket"},
 		// line numbers in parse errors:
 		{"1\n2\n3\n)", Nil, "unexpected right paren on line 4"},
 	}
@@ -0,0 +1,101 @@
+package lisp
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"weak"
+)
+
+// srcPos is the place in the source code a form was read from.  It is an
+// Sexpr so that it can appear in error traces.
+type srcPos struct {
+	file      string
+	line, col int
+}
+
+// String returns the position as `file:line:col`, or `line:col` if the
+// source had no file name.
+func (p *srcPos) String() string {
+	if p.file == "" {
+		return fmt.Sprintf("%d:%d", p.line, p.col)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.file, p.line, p.col)
+}
+
+// Equal returns true if o is the same position.
+func (p *srcPos) Equal(o Sexpr) bool {
+	o2, ok := o.(*srcPos)
+	return ok && *p == *o2
+}
+
+// positions maps lists read by the parser to where they were read from.  The
+// table doesn't keep the lists alive; entries are removed when the lists
+// they describe are garbage collected.
+var positions = struct {
+	sync.Mutex
+	m map[weak.Pointer[ConsCell]]*srcPos
+}{m: map[weak.Pointer[ConsCell]]*srcPos{}}
+
+// setPos records the position of the token which began list x.
+func setPos(x Sexpr, tok Token) {
+	c, ok := x.(*ConsCell)
+	if !ok || c == Nil {
+		return
+	}
+	wp := weak.Make(c)
+	positions.Lock()
+	positions.m[wp] = &srcPos{tok.file, tok.line, tok.col}
+	positions.Unlock()
+	runtime.AddCleanup(c, func(wp weak.Pointer[ConsCell]) {
+		positions.Lock()
+		delete(positions.m, wp)
+		positions.Unlock()
+	}, wp)
+}
+
+// posOf returns the position x was read from, or nil if it wasn't read by
+// the parser (or isn't a list).
+func posOf(x Sexpr) *srcPos {
+	c, ok := x.(*ConsCell)
+	if !ok || c == Nil {
+		return nil
+	}
+	positions.Lock()
+	defer positions.Unlock()
+	return positions.m[weak.Make(c)]
+}
+
+// locateError records the innermost form which failed in the condition for
+// err, and adds the position of the first of forms which has one to its
+// trace, unless the trace already has the position of a more deeply nested
+// form.
+func locateError(err error, forms ...Sexpr) error {
+	if isEscape(err) {
+		return err
+	}
+	c := asCondition(err)
+	if c.form == nil && len(forms) > 0 {
+		c.form = forms[0]
+	}
+	if c.located {
+		return c
+	}
+	for _, form := range forms {
+		if p := posOf(form); p != nil {
+			ret := c.withFrame(list(Atom{"at"}, p))
+			ret.located = true
+			return ret
+		}
+	}
+	return c
+}
+
+// lambdaFrame returns the frame added to an error trace when form, in the
+// body of a lambda, fails.
+func lambdaFrame(form Sexpr) *ConsCell {
+	if p := posOf(form); p != nil {
+		return list(Atom{"lambda"}, form, Atom{"at"}, p)
+	}
+	return list(Atom{"lambda"}, form)
+}
@@ -0,0 +1,85 @@
+package lisp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePositions(t *testing.T) {
+	exprs, err := lexAndParseFile("f.l1", strings.Split("(a\n  (b 'c)\n  . (d))", "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer := exprs[0].(*ConsCell)
+	inner := outer.cdr.(*ConsCell).car.(*ConsCell)
+	quoted := inner.cdr.(*ConsCell).car
+	dotted := outer.cdr.(*ConsCell).cdr
+	var tests = []struct {
+		x    Sexpr
+		want string
+	}{
+		{outer, "f.l1:1:1"},
+		{inner, "f.l1:2:3"},
+		{quoted, "f.l1:2:6"},
+		{dotted, "f.l1:3:5"},
+	}
+	for _, test := range tests {
+		p := posOf(test.x)
+		if p == nil {
+			t.Errorf("no position for %s", test.x)
+			continue
+		}
+		if p.String() != test.want {
+			t.Errorf("position of %s is %s, want %s", test.x, p, test.want)
+		}
+	}
+	if p := posOf(list(Atom{"a"})); p != nil {
+		t.Errorf("constructed list should have no position, got %s", p)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "bad.l1")
+	src := "(defn f (x)\n  (car x)\n  x)\n(defn g (y)\n  (f y)\n  y)\n(g 3)\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	interp, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		run  func() (Sexpr, error)
+		want []string
+	}{
+		{func() (Sexpr, error) { return interp.EvalFile(file) },
+			[]string{"(lambda (f y) at " + file + ":5:3)",
+				"(lambda (car x) at " + file + ":2:3)",
+				"(at " + file + ":2:3)"}},
+		{func() (Sexpr, error) { return interp.Eval("(load \"" + file + "\")") },
+			[]string{"(load file) (lambda (f y) at " + file + ":5:3)"}},
+		{func() (Sexpr, error) { return interp.Eval("(+ 1\n   (car 1))") },
+			[]string{"(at 2:4) (builtin function car)"}},
+		// Tail calls are reported at the innermost form:
+		{func() (Sexpr, error) { return interp.Eval("(let ((x 1))\n  (when t\n    (car x)))") },
+			[]string{"((at 3:5) (builtin function car)"}},
+	}
+	for _, test := range tests {
+		_, err := test.run()
+		if err == nil {
+			t.Errorf("expected error containing %q", test.want)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q should contain %q", err, want)
+			}
+		}
+		if strings.Count(err.Error(), "(at ") != 1 {
+			t.Errorf("error %q should have one innermost position", err)
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package lisp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// The pretty-printer lays out code to fit in a given width, indenting it as
+// reindent does (see indent.go).  What fits on a line is printed on one;
+// otherwise the first arguments of forms like `defn`, `let`, `lambda`,
+// `when` and `test` stay on the first line, and their bodies go on the
+// lines after, indented two spaces; the arguments of a function call, or
+// the clauses of `cond`, line up under the first; and the elements of a
+// list of data line up under its first element.  Quoted lists, lists of
+// atoms and the prose of `doc` forms are filled.  Definitions (with
+// `defn`, `defmacro` and the like) and tests always get a line for each
+// form in their bodies, and `cond` forms a line for each clause.
+
+// pprintWidth is the width the pretty-printer fits code to, unless told
+// otherwise.
+const pprintWidth = 80
+
+// A ppNode is something to pretty-print: an atom (or other thing printed
+// as is), a prefix such as ' with the node it applies to, or a list or
+// other collection.
+type ppNode struct {
+	// An atom's text, a prefix, or a collection's opening paren:
+	text string
+	// A collection's closing paren, or "" for other nodes:
+	close string
+	// A collection's elements, or the node a prefix applies to:
+	kids []*ppNode
+	// The comments before the node, and before a collection's closing
+	// paren:
+	comments, closeComments []ppComment
+	// Whether a blank line came before the node (after its comments) in
+	// the source:
+	blank bool
+}
+
+// A ppComment is a comment in source code being formatted.
+type ppComment struct {
+	text string
+	// Whether it follows code on the same line, and whether a blank line
+	// came before it:
+	trailing, blank bool
+}
+
+// isCollection is true for collections, and those quoted.
+func (n *ppNode) isCollection() bool {
+	return n.close != "" || len(n.kids) == 1 && n.kids[0].isCollection()
+}
+
+func (n *ppNode) isAtom() bool {
+	return n.close == "" && len(n.kids) == 0
+}
+
+// isSymbol is true for atoms which could name a function or macro, as for
+// elementIndent.
+func (n *ppNode) isSymbol() bool {
+	if !n.isAtom() || n.text == "" {
+		return false
+	}
+	r := []rune(n.text)[0]
+	return isWordRune(r) && !unicode.IsDigit(r)
+}
+
+// op returns the name of the function or macro n calls, if it's a list
+// which could be a call, or else "".
+func (n *ppNode) op() string {
+	if n.text == "(" && len(n.kids) > 0 && n.kids[0].isSymbol() && len(n.kids[0].comments) == 0 {
+		return n.kids[0].text
+	}
+	return ""
+}
+
+// breaks is true if n must be broken over lines, whether it fits on one
+// or not, because it is or contains a definition or test with a body, or
+// a cond or examples form with more than one clause or example.  If
+// quoted is true, n is data (unless it's an examples form).
+func (n *ppNode) breaks(quoted bool) bool {
+	if !n.isCollection() {
+		return false
+	}
+	if n.close == "" {
+		return n.kids[0].breaks(quoted || n.text == "'")
+	}
+	op := n.op()
+	if !quoted || op == "examples" {
+		isDef := strings.HasPrefix(op, "def") && op != "def"
+		if isDef && len(n.kids) > 3 ||
+			(op == "test" || op == "cond" || op == "examples") && len(n.kids) > 2 {
+			return true
+		}
+	}
+	switch op {
+	case "quote", "doc":
+		quoted = true
+	case "examples":
+		quoted = false
+	}
+	for _, k := range n.kids {
+		if k.breaks(quoted) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasComments is true if there are comments within n.
+func (n *ppNode) hasComments() bool {
+	if len(n.closeComments) > 0 {
+		return true
+	}
+	for _, k := range n.kids {
+		if len(k.comments) > 0 || k.hasComments() {
+			return true
+		}
+	}
+	return false
+}
+
+// flat returns n printed on one line.
+func (n *ppNode) flat() string {
+	switch {
+	case n.isCollection():
+		parts := make([]string, len(n.kids))
+		for i, k := range n.kids {
+			parts[i] = k.flat()
+		}
+		return n.text + strings.Join(parts, " ") + n.close
+	case len(n.kids) == 1:
+		return n.text + n.kids[0].flat()
+	}
+	return n.text
+}
+
+// sexprNode returns the node to pretty-print x with.
+func sexprNode(x Sexpr) *ppNode {
+	switch t := x.(type) {
+	case *ConsCell:
+		if t == Nil {
+			break
+		}
+		if t.car.Equal(Atom{"quote"}) {
+			if arg, ok := t.cdr.(*ConsCell); ok && arg != Nil && arg.cdr == Nil {
+				return &ppNode{text: "'", kids: []*ppNode{sexprNode(arg.car)}}
+			}
+		}
+		n := &ppNode{text: "(", close: ")"}
+		for {
+			n.kids = append(n.kids, sexprNode(t.car))
+			next, ok := t.cdr.(*ConsCell)
+			if !ok {
+				n.kids = append(n.kids, &ppNode{text: "."}, sexprNode(t.cdr))
+				return n
+			}
+			if next == Nil {
+				return n
+			}
+			t = next
+		}
+	case *Vector:
+		n := &ppNode{text: "[", close: "]"}
+		for _, item := range t.items {
+			n.kids = append(n.kids, sexprNode(item))
+		}
+		return n
+	case *HashMap:
+		n := &ppNode{text: "{", close: "}"}
+		for _, entry := range t.entries() {
+			n.kids = append(n.kids, sexprNode(entry.key), sexprNode(entry.val))
+		}
+		return n
+	}
+	return &ppNode{text: x.String()}
+}
+
+// pprint returns x laid out to fit in width columns (if it can be).
+func pprint(x Sexpr, width int) string {
+	p := &printer{width: width, fresh: true}
+	p.print(sexprNode(x))
+	return string(p.out)
+}
+
+// printer lays out nodes.
+type printer struct {
+	out   []byte
+	width int
+	col   int // the column of the next thing written
+	// Whether nothing but indentation has been written on the current
+	// line, and whether the line must end before anything else is
+	// written on it (after a comment):
+	fresh, needNewline bool
+	// Whether what's being printed is quoted (or documentation), and so is
+	// data rather than code, and whether the next list must be broken over
+	// lines:
+	quoted, breakNext bool
+	// Whether the last element printed was broken over lines, and so
+	// mustn't be followed by another on its last line:
+	broke bool
+	// The width of the closing parens which will follow what's being
+	// printed:
+	trail int
+}
+
+func (p *printer) write(s string) {
+	p.out = append(p.out, s...)
+	p.col += runewidth.StringWidth(s)
+	p.fresh = false
+}
+
+// newline starts a new line, indented by indent, after a blank line if
+// blank is true.
+func (p *printer) newline(indent int, blank bool) {
+	p.out = bytes.TrimRight(p.out, " ")
+	if !p.fresh {
+		p.out = append(p.out, '\n')
+	}
+	if blank && len(p.out) > 0 && !bytes.HasSuffix(p.out, []byte("\n\n")) {
+		p.out = append(p.out, '\n')
+	}
+	p.out = append(p.out, strings.Repeat(" ", indent)...)
+	p.col = indent
+	p.fresh, p.needNewline = true, false
+}
+
+// comments writes comments, at the end of the current line if they
+// followed code on their line in the source, or else on lines of their
+// own, indented by indent.
+func (p *printer) comments(cs []ppComment, indent int) {
+	for _, c := range cs {
+		if c.trailing && !p.fresh && !p.needNewline {
+			p.write(" " + c.text)
+		} else {
+			p.newline(indent, c.blank)
+			p.write(c.text)
+		}
+		p.needNewline = true
+	}
+}
+
+// fits is true if n fits, flat, on the current line after sep.
+func (p *printer) fits(n *ppNode, sep string) bool {
+	return !n.hasComments() && !n.breaks(p.quoted) &&
+		p.col+len(sep)+runewidth.StringWidth(n.flat())+p.trail <= p.width
+}
+
+// element writes k after sep, if sameLine is true and nothing prevents it,
+// or else on a new line indented by indent.
+func (p *printer) element(k *ppNode, sep string, sameLine bool, indent int) {
+	p.comments(k.comments, indent)
+	if p.needNewline || !sameLine || p.broke {
+		p.newline(indent, k.blank)
+	} else {
+		p.write(sep)
+	}
+	start := len(p.out)
+	p.print(k)
+	p.broke = bytes.IndexByte(p.out[start:], '\n') >= 0
+}
+
+func (p *printer) print(n *ppNode) {
+	switch {
+	case n.isCollection():
+		p.collection(n)
+	case len(n.kids) == 1:
+		p.write(n.text)
+		if n.text == "'" {
+			defer func(quoted bool) { p.quoted = quoted }(p.quoted)
+			p.quoted = true
+		}
+		p.element(n.kids[0], "", true, p.col)
+	default:
+		p.write(n.text)
+	}
+}
+
+func (p *printer) collection(n *ppNode) {
+	col := p.col
+	kids := n.kids
+	op := n.op()
+	// Whether n is data; its elements may be, too:
+	data := p.quoted && op != "examples"
+	defer func(quoted bool) { p.quoted = quoted }(p.quoted)
+	switch op {
+	case "quote", "doc":
+		p.quoted = true
+	case "examples":
+		p.quoted = false
+	}
+	// The number of arguments before the body, for forms with bodies:
+	distinguished, hasBody := indentSpecs[op]
+	isDef := strings.HasPrefix(op, "def") && op != "def"
+	if isDef {
+		distinguished, hasBody = 2, true
+	}
+	if op == "lambda" && len(kids) > 1 && kids[1].isSymbol() {
+		distinguished = 2
+	}
+	breakNext := p.breakNext
+	p.breakNext = false
+	if !breakNext && p.fits(n, "") {
+		p.write(n.flat())
+		return
+	}
+	p.write(n.text)
+	p.broke = false
+	trail := p.trail
+	defer func() { p.trail = trail }()
+	// setTrail notes that the closing paren follows the last element:
+	setTrail := func(k *ppNode) {
+		p.trail = 0
+		if k == kids[len(kids)-1] && len(n.closeComments) == 0 {
+			p.trail = trail + len(n.close)
+		}
+	}
+	// The indentation of the elements on lines after the first:
+	indent := col + 1
+	switch {
+	case op == "":
+		// A list of data, or a call of a function which isn't named:
+		fill := data || allAtoms(kids)
+		for i, k := range kids {
+			setTrail(k)
+			sameLine := i == 0 || (fill && p.fits(k, " ")) ||
+				(n.text == "{" && i%2 == 1)
+			p.element(k, sep(i), sameLine, indent)
+		}
+	case hasBody:
+		setTrail(kids[0])
+		p.element(kids[0], "", true, indent)
+		indent = col + 4
+		for i, k := range kids[1:] {
+			setTrail(k)
+			sameLine := i < distinguished || data && p.fits(k, " ")
+			if i == distinguished {
+				indent = col + 2
+			}
+			if (i == 0 || i == distinguished && !isDef) && sameLine &&
+				!p.needNewline && len(k.comments) == 0 {
+				// Later elements line up with this one:
+				indent = p.col + 1
+			}
+			if i == 0 && distinguished > 0 && !data {
+				// Bindings go on lines of their own:
+				p.breakNext = (op == "let" || op == "let*") && len(k.kids) > 1
+			}
+			p.element(k, " ", sameLine, indent)
+		}
+	default:
+		// A function call:
+		setTrail(kids[0])
+		p.element(kids[0], "", true, indent)
+		args := kids[1:]
+		if len(args) > 0 {
+			setTrail(args[0])
+		}
+		if len(args) > 0 && len(args[0].comments) == 0 && op != "examples" &&
+			(p.fits(args[0], " ") || (args[0].isCollection() && p.col-col < p.width/4)) {
+			indent = p.col + 1
+			p.element(args[0], " ", true, indent)
+			args = args[1:]
+		}
+		fill := data || allAtoms(args)
+		for i, k := range args {
+			setTrail(k)
+			// The last argument can hang from a line of atoms, as in
+			// (def x (f ...)) or (error (list ...)):
+			hang := i == len(args)-1 && !p.needNewline && op != "examples" &&
+				allAtoms(kids[:len(kids)-1]) && len(k.comments) == 0 &&
+				k.isCollection() && p.col-col < p.width/4
+			p.element(k, " ", hang || fill && p.fits(k, " "), indent)
+		}
+	}
+	p.trail = trail
+	p.comments(n.closeComments, indent)
+	if p.needNewline {
+		p.newline(indent, false)
+	}
+	p.write(n.close)
+}
+
+// sep returns what goes before the ith element of a list on the same line.
+func sep(i int) string {
+	if i == 0 {
+		return ""
+	}
+	return " "
+}
+
+// allAtoms is true if nodes are all atoms (or empty lists).
+func allAtoms(nodes []*ppNode) bool {
+	for _, n := range nodes {
+		if !n.isAtom() && !(n.close != "" && len(n.kids) == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Format returns l1 source code, formatted by the pretty-printer, keeping
+// its comments, and single blank lines between forms.  It fails if the
+// source can't be parsed.
+func Format(src string) (string, error) {
+	tokens, trailing := lexWithTrivia("", 1, strings.Split(src, "\n"))
+	for _, tok := range tokens {
+		if tok.lexeme.Typ == itemError {
+			return "", typedErrorf("parse-error", Nil, "%s on line %d", tok.lexeme.Val, tok.line)
+		}
+	}
+	balanced, err := IsBalanced(tokens)
+	if err == nil && !balanced {
+		err = fmt.Errorf("unbalanced parens")
+	}
+	if err != nil {
+		return "", typedError("parse-error", Nil, err.Error())
+	}
+	before, err := Parse(tokens)
+	if err != nil {
+		return "", err
+	}
+	r := &nodeReader{tokens: tokens}
+	p := &printer{width: pprintWidth, fresh: true}
+	for r.i < len(tokens) {
+		n := r.next()
+		if r.i > 1 {
+			if len(n.comments) == 0 || !n.comments[0].trailing {
+				p.needNewline = true
+			}
+		}
+		p.element(n, "", true, 0)
+	}
+	p.comments(r.comments(trailing), 0)
+	out := string(bytes.TrimRight(p.out, " \n"))
+	if out != "" {
+		out += "\n"
+	}
+	// The formatted code must mean the same as the original:
+	after, err := Parse(LexItems(strings.Split(out, "\n")))
+	if err == nil && len(after) != len(before) {
+		err = fmt.Errorf("formatting changed the number of forms")
+	}
+	for i := 0; err == nil && i < len(before); i++ {
+		if !before[i].Equal(after[i]) {
+			err = fmt.Errorf("formatting changed %s", before[i])
+		}
+	}
+	if err != nil {
+		return "", extendError("format", err)
+	}
+	return out, nil
+}
+
+// nodeReader reads the nodes of source code being formatted from its
+// tokens.
+type nodeReader struct {
+	tokens []Token
+	i      int
+	// The line of the last token or comment read:
+	line int
+}
+
+// next reads the next node.
+func (r *nodeReader) next() *ppNode {
+	tok := r.tokens[r.i]
+	r.i++
+	n := &ppNode{text: tok.lexeme.Val, comments: r.comments(tok.trivia)}
+	n.blank = r.line > 0 && tok.line > r.line+1
+	r.line = tok.line
+	switch {
+	case isOpener(tok.lexeme.Typ):
+		for !isCloser(r.tokens[r.i].lexeme.Typ) {
+			n.kids = append(n.kids, r.next())
+		}
+		closer := r.tokens[r.i]
+		r.i++
+		n.closeComments = r.comments(closer.trivia)
+		n.close = closer.lexeme.Val
+		r.line = closer.line
+	case isPrefix(tok.lexeme.Typ):
+		n.kids = []*ppNode{r.next()}
+	}
+	return n
+}
+
+// comments converts comment tokens coming next.
+func (r *nodeReader) comments(trivia []Token) []ppComment {
+	ret := []ppComment{}
+	for _, c := range trivia {
+		ret = append(ret, ppComment{
+			text:     strings.TrimRight(c.lexeme.Val, " \t\r"),
+			trailing: c.line == r.line,
+			blank:    r.line > 0 && c.line > r.line+1,
+		})
+		r.line = c.line
+	}
+	return ret
+}
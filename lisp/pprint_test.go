@@ -0,0 +1,101 @@
+package lisp
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPprint(t *testing.T) {
+	var tests = []struct {
+		src   string
+		width int
+		want  string
+	}{
+		{"(a b c)", 80, "(a b c)"},
+		{"'(a b c)", 80, "'(a b c)"},
+		{"(a b . c)", 80, "(a b . c)"},
+		{"[1 2 3 4 5 6]", 8, "[1 2 3 4\n 5 6]"},
+		{"{:a 1 :b (1 2 3)}", 10, "{:a 1\n :b (1 2\n     3)}"},
+		{"(some-function argument-one (g x) 3)", 20,
+			"(some-function\n argument-one\n (g x)\n 3)"},
+		{"(+ (* 1 2) (* 3 4))", 12, "(+ (* 1 2)\n   (* 3 4))"},
+		{"(defn f (x) x)", 80, "(defn f (x)\n  x)"},
+		{"(defn f (x) (doc (the identity function, returning whatever it is given)) x)", 40,
+			"(defn f (x)\n  (doc (the identity function, returning\n" +
+				"            whatever it is given))\n  x)"},
+		{"(let ((a 1) (b 2)) (+ a b))", 15, "(let ((a 1)\n      (b 2))\n  (+ a b))"},
+		{"(cond ((= x 1) 'one) (t 'many))", 80, "(cond ((= x 1) 'one)\n      (t 'many))"},
+		{"(lambda (x) (* x x))", 10, "(lambda (x)\n  (* x x))"},
+		{"(when (ready?) (go) (stop))", 20, "(when (ready?)\n  (go)\n  (stop))"},
+		{"(test '(truth) (is t))", 80, "(test '(truth)\n  (is t))"},
+		{"(quote (defn f (x) x))", 80, "'(defn f (x) x)"},
+		{`(error "a string")`, 12, "(error\n \"a string\")"},
+	}
+	for _, test := range tests {
+		x, err := Parse(LexItems([]string{test.src}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pprint(x[0], test.width); got != test.want {
+			t.Errorf("%s at %d: got\n%s\nwant\n%s", test.src, test.width, got, test.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	var tests = []struct {
+		src, want string
+	}{
+		{"", ""},
+		{"(a   b)", "(a b)\n"},
+		{"#!/usr/bin/env l1\n(a)\n", "#!/usr/bin/env l1\n(a)\n"},
+		{"(def a 1) (def b 2)", "(def a 1)\n(def b 2)\n"},
+		{"(a)\n\n\n\n(b)\n", "(a)\n\n(b)\n"},
+		{";; heading\n\n(defn f (x)   ;; note\n  ;; inside\n x ; after\n )\n;; the end",
+			";; heading\n\n(defn f (x) ;; note\n  ;; inside\n  x ; after\n  )\n;; the end\n"},
+		{"(list 1 ;; one\n 2)", "(list 1 ;; one\n      2)\n"},
+		{"#_(ignored   form) (a)", "#_(ignored form)\n(a)\n"},
+	}
+	for _, test := range tests {
+		got, err := Format(test.src)
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+		} else if got != test.want {
+			t.Errorf("%q: got\n%s\nwant\n%s", test.src, got, test.want)
+		}
+	}
+	for _, src := range []string{"(a", "(a))", "(a]", `(a "b`} {
+		if got, err := Format(src); err == nil {
+			t.Errorf("%q: got %q, want an error", src, got)
+		}
+	}
+}
+
+// TestFormatL1Code formats the l1 code in the repository, which must
+// format the same way again, and be indented as reindent would indent it.
+func TestFormatL1Code(t *testing.T) {
+	srcs := map[string]string{"l1.l1": RawCore}
+	src, err := os.ReadFile("../tests.l1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcs["tests.l1"] = string(src)
+	for name, src := range srcs {
+		once, err := Format(src)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if twice, err := Format(once); err != nil || twice != once {
+			t.Errorf("%s: formatting again changed it (%v)", name, err)
+		}
+		if reindent(once) != once {
+			t.Errorf("%s: formatted code isn't indented as reindent indents it", name)
+		}
+		for i, line := range strings.Split(once, "\n") {
+			if len([]rune(line)) > pprintWidth && !strings.Contains(line, ";") {
+				t.Errorf("%s:%d is too long: %s", name, i+1, line)
+			}
+		}
+	}
+}
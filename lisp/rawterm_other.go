@@ -0,0 +1,17 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package lisp
+
+func isTerminal(fd int) bool {
+	return false
+}
+
+// rawMode is not supported here, so the REPL reads whole lines, without
+// editing.
+func rawMode(fd int) (func(), error) {
+	return nil, baseError("raw terminal mode is not supported")
+}
+
+func termWidth(fd int) int {
+	return 0
+}
@@ -0,0 +1,268 @@
+package lisp
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// What's typed at the REPL is evaluated by EvalREPL, which prints the value
+// of each form, and keeps the last three in *1, *2 and *3 (*1 being the
+// latest) and the last error, as a condition, in *e.  A line starting with
+// one of the commands below, such as `:doc map`, is a command to the REPL
+// rather than code to evaluate; one starting with any other word after a
+// colon is an unknown command.
+
+// replCommand is a command to the REPL.
+type replCommand struct {
+	args string // the arguments it takes, as shown by :help
+	doc  string
+	// run runs the command with the forms typed after it, returning false
+	// if the REPL should stop:
+	run func(i *Interpreter, args []Sexpr) (bool, error)
+}
+
+var replCommands map[string]replCommand
+
+// Defined in init, since :help refers to replCommands:
+func init() {
+	replCommands = map[string]replCommand{
+		":doc": {"name", "show the documentation of a function, macro or special form",
+			func(i *Interpreter, args []Sexpr) (bool, error) {
+				name, err := nameArg(":doc", args)
+				if err != nil {
+					return true, err
+				}
+				doc, err := describe(name, &i.globals)
+				if err != nil {
+					return true, err
+				}
+				fmt.Fprintln(i.stdout, doc)
+				return true, nil
+			}},
+		":source": {"name", "show the source of a function or macro",
+			func(i *Interpreter, args []Sexpr) (bool, error) {
+				name, err := nameArg(":source", args)
+				if err != nil {
+					return true, err
+				}
+				f, err := evAtom(Atom{name}, &i.globals)
+				if err != nil {
+					return true, err
+				}
+				src, err := builtins["source"].Fn([]Sexpr{f}, &i.globals)
+				if err != nil {
+					return true, err
+				}
+				fmt.Fprintln(i.stdout, pprint(src, pprintWidth))
+				return true, nil
+			}},
+		":time": {"form ...", "evaluate forms, and show how long it took",
+			func(i *Interpreter, args []Sexpr) (bool, error) {
+				start := time.Now()
+				err := i.evalPrinting(args)
+				fmt.Fprintf(i.stdout, ";; elapsed: %v\n", time.Since(start))
+				return true, err
+			}},
+		":expand": {"form ...", "show each step in the expansion of the macro calls in forms",
+			func(i *Interpreter, args []Sexpr) (bool, error) {
+				for _, x := range args {
+					fmt.Fprintln(i.stdout, pprint(x, pprintWidth))
+					steps, err := i.ExpansionSteps(x)
+					for _, step := range steps {
+						fmt.Fprintf(i.stdout, ";; %s =>\n%s\n", step.Macro, pprint(step.Form, pprintWidth))
+					}
+					if err != nil {
+						return true, err
+					}
+					if len(steps) == 0 {
+						fmt.Fprintln(i.stdout, ";; no macro calls to expand")
+					}
+				}
+				return true, nil
+			}},
+		":load": {"file", "load a file, remembering it for :reload",
+			func(i *Interpreter, args []Sexpr) (bool, error) {
+				if len(args) != 1 {
+					return true, typedError("arity-error", Nil, "usage: :load file")
+				}
+				var filename string
+				switch t := args[0].(type) {
+				case Atom:
+					filename = t.s
+				case String:
+					filename = t.s
+				default:
+					return true, typedErrorf("type-error", args[0], "'%s' is not a file name", args[0])
+				}
+				if err := i.replLoad(filename); err != nil {
+					return true, err
+				}
+				for _, f := range i.replFiles {
+					if f == filename {
+						return true, nil
+					}
+				}
+				i.replFiles = append(i.replFiles, filename)
+				return true, nil
+			}},
+		":reload": {"", "load the files loaded with :load again",
+			func(i *Interpreter, args []Sexpr) (bool, error) {
+				if len(i.replFiles) == 0 {
+					return true, baseError("no files have been loaded with :load")
+				}
+				for _, filename := range i.replFiles {
+					if err := i.replLoad(filename); err != nil {
+						return true, err
+					}
+				}
+				return true, nil
+			}},
+		":env": {"", "list the names defined at the REPL, and their values",
+			func(i *Interpreter, args []Sexpr) (bool, error) {
+				names := []string{}
+				for _, s := range i.globals.globals.setSince(i.core) {
+					if !isResultVar(s.String()) {
+						names = append(names, s.String())
+					}
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					v, _ := i.globals.Lookup(name)
+					fmt.Fprintf(i.stdout, "%s: %s\n", name, abbreviate(v.String(), 60))
+				}
+				return true, nil
+			}},
+		":quit": {"", "leave the REPL",
+			func(i *Interpreter, args []Sexpr) (bool, error) {
+				return false, nil
+			}},
+		":help": {"", "list these commands",
+			func(i *Interpreter, args []Sexpr) (bool, error) {
+				for _, name := range replCommandNames() {
+					cmd := replCommands[name]
+					fmt.Fprintf(i.stdout, "%-20s %s\n", strings.TrimSpace(name+" "+cmd.args), cmd.doc)
+				}
+				fmt.Fprintln(i.stdout, "*1, *2 and *3 hold the last three values, and *e the last error.")
+				return true, nil
+			}},
+	}
+}
+
+func replCommandNames() []string {
+	names := []string{}
+	for name := range replCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func nameArg(cmd string, args []Sexpr) (string, error) {
+	if len(args) != 1 {
+		return "", typedErrorf("arity-error", Nil, "usage: %s name", cmd)
+	}
+	a, ok := args[0].(Atom)
+	if !ok {
+		return "", typedErrorf("type-error", args[0], "'%s' is not a name", args[0])
+	}
+	return a.s, nil
+}
+
+func isResultVar(name string) bool {
+	return name == "*1" || name == "*2" || name == "*3" || name == "*e"
+}
+
+// abbreviate shortens s to at most n characters.
+func abbreviate(s string, n int) string {
+	rs := []rune(s)
+	if len(rs) <= n {
+		return s
+	}
+	return string(rs[:n-3]) + "..."
+}
+
+// EvalREPL evaluates exprs, as typed at the REPL, printing their values, or
+// runs the REPL command they give.  It returns false if the REPL should
+// stop.
+func (i *Interpreter) EvalREPL(exprs []Sexpr) bool {
+	if i.globals.globals.own(intern("*1")) == nil {
+		for _, name := range []string{"*1", "*2", "*3", "*e"} {
+			i.globals.SetTopLevel(name, Nil)
+		}
+	}
+	var err error
+	more := true
+	if cmd, ok := i.replCommand(exprs); ok {
+		more, err = cmd.run(i, exprs[1:])
+	} else {
+		err = i.evalPrinting(exprs)
+	}
+	if err != nil {
+		i.globals.SetTopLevel("*e", asCondition(err))
+		fmt.Fprintf(i.stderr, "ERROR:\n%v\n", err)
+	}
+	return more
+}
+
+// replCommand returns the REPL command exprs give, if they give one.
+func (i *Interpreter) replCommand(exprs []Sexpr) (replCommand, bool) {
+	if len(exprs) == 0 {
+		return replCommand{}, false
+	}
+	a, ok := exprs[0].(Atom)
+	if !ok {
+		return replCommand{}, false
+	}
+	cmd, ok := replCommands[a.s]
+	if !ok && replCommandRe.MatchString(a.s) {
+		return replCommand{run: func(i *Interpreter, args []Sexpr) (bool, error) {
+			return true, baseErrorf("unknown command %s (the commands are %s)",
+				a.s, strings.Join(replCommandNames(), ", "))
+		}}, true
+	}
+	return cmd, ok
+}
+
+// replCommandRe matches what looks like a REPL command.
+var replCommandRe = regexp.MustCompile(`^:[a-zA-Z][a-zA-Z0-9-]*$`)
+
+// evalPrinting evaluates exprs, printing the value of each and keeping it in
+// *1.
+func (i *Interpreter) evalPrinting(exprs []Sexpr) error {
+	if i.limits != nil {
+		defer i.limits.start()()
+	}
+	for _, expr := range exprs {
+		res, err := eval(expr, &i.globals)
+		if err != nil {
+			return err
+		}
+		v2, _ := i.globals.Lookup("*2")
+		v1, _ := i.globals.Lookup("*1")
+		i.globals.SetTopLevel("*3", v2)
+		i.globals.SetTopLevel("*2", v1)
+		i.globals.SetTopLevel("*1", res)
+		fmt.Fprintln(i.stdout, res.String())
+	}
+	return nil
+}
+
+// replLoad loads a file for the :load and :reload commands, which are
+// denied the filesystem if the `load` function is.
+func (i *Interpreter) replLoad(filename string) error {
+	if i.policy.denies(Filesystem) {
+		return typedErrorf("capability-denied", Atom{string(Filesystem)},
+			"loading %s needs the filesystem capability, which is denied", filename)
+	}
+	if i.limits != nil {
+		defer i.limits.start()()
+	}
+	if err := LoadFile(&i.globals, filename); err != nil {
+		return extendError("load file", err)
+	}
+	fmt.Fprintf(i.stdout, ";; loaded %s\n", filename)
+	return nil
+}
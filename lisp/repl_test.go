@@ -0,0 +1,94 @@
+package lisp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// replSession evaluates each line of src at the REPL, returning what was
+// printed.
+func replSession(t *testing.T, interp *Interpreter, out *bytes.Buffer, src string) string {
+	out.Reset()
+	for _, line := range strings.Split(src, "\n") {
+		exprs, err := Parse(LexItems([]string{line}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !interp.EvalREPL(exprs) {
+			break
+		}
+	}
+	return out.String()
+}
+
+func TestREPLResultVars(t *testing.T) {
+	var out bytes.Buffer
+	interp, err := NewInterpreter(WithStdout(&out), WithStderr(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := replSession(t, interp, &out, `*1
+(+ 1 2) (* 3 4)
+(list *1 *2 *3)
+(car 1)
+(condition-type *e)
+:quit
+(print 'unreached)`)
+	want := `()
+3
+12
+(12 3 ())
+ERROR:
+((at 1:1) (builtin function car) ('1' is not a list))
+type-error
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestREPLCommands(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "defs.l1")
+	if err := os.WriteFile(path, []byte("(def x 1)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	interp, err := NewInterpreter(WithStdout(&out), WithStderr(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		src, want string
+	}{
+		{":doc car", "car: native function, arity 1\nArgs: (x)\nReturn the first element of a list"},
+		{"(defn f (a &optional b) a)\n:doc f", "f: function, arity 1+\nArgs: (a &optional b)\n(no documentation)"},
+		{":doc undefined-thing", "unknown symbol: undefined-thing"},
+		{":source f", "(lambda (a &optional b) a)"},
+		{":time (+ 1 1)", "2\n;; elapsed: "},
+		{":expand (when x y)", "(when x y)\n;; when =>\n(cond (x (progn y)))"},
+		{":expand (+ 1 2)", ";; no macro calls to expand"},
+		{":reload", "no files have been loaded"},
+		{":load " + path, ";; loaded " + path},
+		{"(def x 2)\n:reload\nx", ";; loaded " + path + "\n1"},
+		{":env", "f: <lambda(a &optional b)>\nx: 1\n"},
+		{":help", ":quit                leave the REPL"},
+		{":bogus", "unknown command :bogus (the commands are :doc, :env, :expand, :help, :load, :quit, :reload, :source, :time)"},
+		{"':sep", ":sep"},
+	} {
+		got := replSession(t, interp, &out, test.src)
+		if !strings.Contains(got, test.want) {
+			t.Errorf("%s: got\n%s\nwant it to contain\n%s", test.src, got, test.want)
+		}
+	}
+	strict, err := NewInterpreter(WithStdout(&out), WithStderr(&out), WithPolicy(StrictPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := replSession(t, strict, &out, ":load "+path); !strings.Contains(got, "capability") {
+		t.Errorf("got %s, want capability-denied", got)
+	}
+}
@@ -0,0 +1,429 @@
+package lisp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Server lets editors and other tools evaluate code in running
+// interpreters over a network connection, in the manner of Clojure's nREPL.
+// Clients send requests as JSON objects, one per line, and get responses
+// the same way:
+//
+//	{"id": "1", "op": "eval", "code": "(print 'hi) (+ 1 2)"}
+//	{"id": "1", "session": "4f0c...", "out": "hi", "value": "3", "status": ["done"]}
+//
+// Each request gives an "op" (see serverOps), and an "id", which is copied
+// into the response.  Code is evaluated in a session: an interpreter of its
+// own, whose definitions persist from one request to the next.  A request
+// names its session with "session"; without one, it uses the one made for
+// its connection.  Sessions are shared by all connections, so one
+// connection can interrupt the evaluation another is waiting for.  The
+// evaluations in a session run one at a time, in the order they were
+// requested.
+//
+// Anyone who can connect to a server can run code with its sessions'
+// capabilities, so, unless it's given a Token, a server only serves on
+// loopback addresses and Unix sockets; and its sessions' interpreters have
+// the ServerPolicy, unless it's given another.
+type Server struct {
+	// Token, if set, must be given as the "token" of each request:
+	Token    string
+	opts     []Option
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// ServerPolicy is the policy of a Server's sessions, unless it's given
+// another: clients can't read files, run programs, or take over the
+// terminal of the server's process.
+var ServerPolicy = Policy{Deny: []Capability{Filesystem, Process, Terminal}}
+
+// session is an interpreter serving requests.
+type session struct {
+	id     string
+	interp *Interpreter
+	mu     sync.Mutex
+	// Closed when the last evaluation requested is done, so the next can
+	// start:
+	last chan struct{}
+	// The number of evaluations requested and not yet done:
+	pending int
+	// Stops the evaluation running, if any:
+	cancel context.CancelFunc
+	// Whether an interrupt came while no evaluation was running, for the
+	// next one:
+	interrupted bool
+	closed      bool
+}
+
+// request is a request from a client.
+type request struct {
+	ID      string `json:"id"`
+	Op      string `json:"op"`
+	Session string `json:"session,omitempty"`
+	Token   string `json:"token,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	Symbol  string `json:"symbol,omitempty"`
+	File    string `json:"file,omitempty"`
+}
+
+// response is the response to a request.
+type response struct {
+	ID          string   `json:"id"`
+	Session     string   `json:"session,omitempty"`
+	Value       string   `json:"value,omitempty"`
+	Out         string   `json:"out,omitempty"`
+	Err         string   `json:"err,omitempty"`
+	ErrType     string   `json:"err-type,omitempty"`
+	Completions []string `json:"completions,omitempty"`
+	Doc         string   `json:"doc,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	NewSession  string   `json:"new-session,omitempty"`
+	Sessions    []string `json:"sessions,omitempty"`
+	Ops         []string `json:"ops,omitempty"`
+	Status      []string `json:"status"`
+}
+
+// serverOps are the operations a client can request, by name.
+var serverOps map[string]func(*Server, *session, request, func(response))
+
+// Defined in init, since "describe" refers to serverOps:
+func init() {
+	serverOps = map[string]func(*Server, *session, request, func(response)){
+		// eval evaluates "code", giving the value of the last form in it,
+		// and what it printed:
+		"eval": func(srv *Server, s *session, req request, reply func(response)) {
+			s.evaluate(req, "", reply)
+		},
+		// load-file evaluates the file named by "file", or "code", as the
+		// contents of that file, if it's given:
+		"load-file": func(srv *Server, s *session, req request, reply func(response)) {
+			if req.File == "" {
+				reply(errResponse(req, s, baseError("load-file requires a file")))
+				return
+			}
+			s.evaluate(req, req.File, reply)
+		},
+		// complete lists the names starting with "prefix":
+		"complete": func(srv *Server, s *session, req request, reply func(response)) {
+			reply(response{ID: req.ID, Session: s.id,
+				Completions: completions(&s.interp.globals, req.Prefix), Status: []string{"done"}})
+		},
+		// doc gives the documentation of "symbol":
+		"doc": func(srv *Server, s *session, req request, reply func(response)) {
+			doc, err := describe(req.Symbol, &s.interp.globals)
+			if err != nil {
+				reply(errResponse(req, s, err))
+				return
+			}
+			reply(response{ID: req.ID, Session: s.id, Doc: doc, Status: []string{"done"}})
+		},
+		// source gives the source of the function "symbol":
+		"source": func(srv *Server, s *session, req request, reply func(response)) {
+			f, err := evAtom(Atom{req.Symbol}, &s.interp.globals)
+			if err == nil {
+				f, err = builtins["source"].Fn([]Sexpr{f}, &s.interp.globals)
+			}
+			if err != nil {
+				reply(errResponse(req, s, err))
+				return
+			}
+			reply(response{ID: req.ID, Session: s.id, Source: f.String(), Status: []string{"done"}})
+		},
+		// interrupt stops the evaluation running in the session, or, if
+		// it's yet to start, the next one:
+		"interrupt": func(srv *Server, s *session, req request, reply func(response)) {
+			status := []string{"done"}
+			if !s.interrupt() {
+				status = []string{"session-idle", "done"}
+			}
+			reply(response{ID: req.ID, Session: s.id, Status: status})
+		},
+		// clone makes a new session:
+		"clone": func(srv *Server, s *session, req request, reply func(response)) {
+			s2, err := srv.newSession()
+			if err != nil {
+				reply(errResponse(req, s, err))
+				return
+			}
+			reply(response{ID: req.ID, Session: s.id, NewSession: s2.id, Status: []string{"done"}})
+		},
+		// close closes the session:
+		"close": func(srv *Server, s *session, req request, reply func(response)) {
+			srv.closeSession(s)
+			reply(response{ID: req.ID, Session: s.id, Status: []string{"session-closed", "done"}})
+		},
+		// ls-sessions lists the sessions:
+		"ls-sessions": func(srv *Server, s *session, req request, reply func(response)) {
+			srv.mu.Lock()
+			ids := []string{}
+			for id := range srv.sessions {
+				ids = append(ids, id)
+			}
+			srv.mu.Unlock()
+			sort.Strings(ids)
+			reply(response{ID: req.ID, Session: s.id, Sessions: ids, Status: []string{"done"}})
+		},
+		// describe lists the operations:
+		"describe": func(srv *Server, s *session, req request, reply func(response)) {
+			ops := []string{}
+			for op := range serverOps {
+				ops = append(ops, op)
+			}
+			sort.Strings(ops)
+			reply(response{ID: req.ID, Session: s.id, Ops: ops, Status: []string{"done"}})
+		},
+	}
+}
+
+// NewServer makes a Server whose sessions' interpreters are made with the
+// given options, after WithPolicy(ServerPolicy).
+func NewServer(opts ...Option) *Server {
+	return &Server{opts: opts, sessions: map[string]*session{}}
+}
+
+// Listen listens on addr: a Unix socket if it's of the form unix:path, or
+// else a TCP address, such as localhost:7888.  The host defaults to
+// 127.0.0.1, so that a port (like :7888, or 7888) is only reachable from
+// the same machine.
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return net.Listen("unix", path)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = "", addr
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.Listen("tcp", net.JoinHostPort(host, port))
+}
+
+// Serve serves the connections made to l, until it fails (as when it's
+// closed).  It refuses to serve on a TCP address other than a loopback one
+// unless srv has a Token.
+func (srv *Server) Serve(l net.Listener) error {
+	if addr, ok := l.Addr().(*net.TCPAddr); ok && !addr.IP.IsLoopback() && srv.Token == "" {
+		return fmt.Errorf("refusing to serve on %s, which isn't a loopback address, without a token", addr)
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.serveConn(conn)
+	}
+}
+
+// serveConn serves the requests made on conn, until it's closed.
+func (srv *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	var mu sync.Mutex // held while writing a response
+	enc := json.NewEncoder(conn)
+	reply := func(resp response) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(resp)
+	}
+	var own *session
+	defer func() {
+		if own != nil {
+			srv.closeSession(own)
+		}
+	}()
+	dec := json.NewDecoder(conn)
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			if err != io.EOF {
+				reply(response{Err: err.Error(), Status: []string{"error", "done"}})
+			}
+			return
+		}
+		if srv.Token != "" && subtle.ConstantTimeCompare([]byte(req.Token), []byte(srv.Token)) != 1 {
+			reply(response{ID: req.ID, Err: "missing or wrong token",
+				Status: []string{"unauthorized", "error", "done"}})
+			continue
+		}
+		op, ok := serverOps[req.Op]
+		if !ok {
+			reply(response{ID: req.ID, Err: "unknown op " + req.Op,
+				Status: []string{"unknown-op", "error", "done"}})
+			continue
+		}
+		var s *session
+		if req.Session == "" {
+			if own == nil {
+				var err error
+				if own, err = srv.newSession(); err != nil {
+					reply(response{ID: req.ID, Err: err.Error(), Status: []string{"error", "done"}})
+					continue
+				}
+			}
+			s = own
+		} else {
+			srv.mu.Lock()
+			s = srv.sessions[req.Session]
+			srv.mu.Unlock()
+			if s == nil {
+				reply(response{ID: req.ID, Err: "unknown session " + req.Session,
+					Status: []string{"unknown-session", "error", "done"}})
+				continue
+			}
+		}
+		op(srv, s, req, reply)
+	}
+}
+
+// newSession makes a session, with its own interpreter.
+func (srv *Server) newSession() (*session, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	opts := append([]Option{WithPolicy(ServerPolicy)}, srv.opts...)
+	opts = append(opts,
+		// Each evaluation's output is captured (see evaluate):
+		WithStdout(io.Discard),
+		WithStderr(io.Discard),
+		WithStdin(strings.NewReader("")),
+		// So evaluation can be interrupted:
+		WithContext(context.Background()))
+	interp, err := NewInterpreter(opts...)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		id:     hex.EncodeToString(id),
+		interp: interp,
+	}
+	srv.mu.Lock()
+	srv.sessions[s.id] = s
+	srv.mu.Unlock()
+	return s, nil
+}
+
+func (srv *Server) closeSession(s *session) {
+	srv.mu.Lock()
+	delete(srv.sessions, s.id)
+	srv.mu.Unlock()
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.interrupt()
+}
+
+// interrupt stops the evaluation running in s, or, if there is none, the
+// next one requested, if there is one; it returns false if there's none.
+func (s *session) interrupt() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.cancel != nil:
+		s.cancel()
+	case s.pending > 0:
+		s.interrupted = true
+	default:
+		return false
+	}
+	return true
+}
+
+// evaluate evaluates the code in req, from the named file (if any), once
+// the evaluations requested before it are done, replying with its value and
+// what it printed.
+func (s *session) evaluate(req request, filename string, reply func(response)) {
+	s.mu.Lock()
+	prev, done := s.last, make(chan struct{})
+	s.last = done
+	s.pending++
+	s.mu.Unlock()
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s.mu.Lock()
+		closed, interrupted := s.closed, s.interrupted
+		s.interrupted = false
+		s.cancel = cancel
+		s.mu.Unlock()
+		defer func() {
+			s.mu.Lock()
+			s.cancel = nil
+			s.pending--
+			s.mu.Unlock()
+		}()
+		switch {
+		case closed:
+			reply(response{ID: req.ID, Session: s.id, Err: "session closed",
+				Status: []string{"session-closed", "error", "done"}})
+			return
+		case interrupted:
+			cancel()
+			reply(errResponse(req, s, ctxError(ctx)))
+			return
+		}
+		// The evaluation gets a context and a writer of its own, so that
+		// neither an interrupt nor what's printed (as by tasks it spawns)
+		// reaches another:
+		s.interp.limits.ctx = ctx
+		w := &captureWriter{}
+		s.interp.out.Store(w)
+		v, err := s.evalCode(req, filename)
+		s.interp.out.Store(nil)
+		out := w.String()
+		if err != nil {
+			resp := errResponse(req, s, err)
+			resp.Out = out
+			reply(resp)
+			return
+		}
+		reply(response{ID: req.ID, Session: s.id, Value: v.String(), Out: out,
+			Status: []string{"done"}})
+	}()
+}
+
+func (s *session) evalCode(req request, filename string) (Sexpr, error) {
+	if filename == "" {
+		return s.interp.Eval(req.Code)
+	}
+	if req.Code != "" {
+		return s.interp.evalNamed(filename, req.Code)
+	}
+	if s.interp.policy.denies(Filesystem) {
+		return nil, typedErrorf("capability-denied", Atom{string(Filesystem)},
+			"loading %s needs the filesystem capability, which is denied", filename)
+	}
+	bytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return s.interp.evalNamed(filename, string(bytes))
+}
+
+// errResponse returns the response reporting err.
+func errResponse(req request, s *session, err error) response {
+	c := asCondition(err)
+	status := []string{"error", "done"}
+	if c.Type().s == "canceled" {
+		status = []string{"interrupted", "error", "done"}
+	}
+	return response{ID: req.ID, Session: s.id, Err: err.Error(), ErrType: c.Type().s,
+		Status: status}
+}
@@ -0,0 +1,235 @@
+package lisp
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient talks to a Server over a loopback connection.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	in   *bufio.Scanner
+}
+
+func startServer(t *testing.T, network, addr string, opts ...Option) net.Listener {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go NewServer(opts...).Serve(l)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func dial(t *testing.T, l net.Listener) *testClient {
+	conn, err := net.Dial(l.Addr().Network(), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t, conn, bufio.NewScanner(conn)}
+}
+
+func (c *testClient) send(req request) {
+	bytes, err := json.Marshal(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.conn.Write(append(bytes, '\n')); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) recv() response {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if !c.in.Scan() {
+		c.t.Fatalf("no response: %v", c.in.Err())
+	}
+	var resp response
+	if err := json.Unmarshal(c.in.Bytes(), &resp); err != nil {
+		c.t.Fatal(err)
+	}
+	return resp
+}
+
+func (c *testClient) call(req request) response {
+	c.send(req)
+	return c.recv()
+}
+
+func TestServer(t *testing.T) {
+	// Allow loading files:
+	l := startServer(t, "tcp", "127.0.0.1:0", WithPolicy(Policy{}))
+	c := dial(t, l)
+	resp := c.call(request{ID: "1", Op: "eval", Code: "(print 'hello) (defn sq (x) (* x x)) (sq 7)"})
+	if resp.ID != "1" || resp.Value != "49" || resp.Out != "hello" || resp.Session == "" {
+		t.Errorf("eval: got %+v", resp)
+	}
+	session := resp.Session
+	// Definitions persist in the session:
+	if resp := c.call(request{ID: "2", Op: "eval", Code: "(sq 3)"}); resp.Value != "9" || resp.Session != session {
+		t.Errorf("eval: got %+v", resp)
+	}
+	if resp := c.call(request{ID: "3", Op: "eval", Code: "(car 1)"}); resp.ErrType != "type-error" ||
+		strings.Join(resp.Status, " ") != "error done" {
+		t.Errorf("error: got %+v", resp)
+	}
+	if resp := c.call(request{ID: "4", Op: "complete", Prefix: "macroexpand-"}); strings.Join(resp.Completions, " ") != "macroexpand-1 macroexpand-all" {
+		t.Errorf("complete: got %+v", resp)
+	}
+	if resp := c.call(request{ID: "5", Op: "doc", Symbol: "car"}); !strings.HasPrefix(resp.Doc, "car: native function") {
+		t.Errorf("doc: got %+v", resp)
+	}
+	if resp := c.call(request{ID: "6", Op: "source", Symbol: "sq"}); resp.Source != "(lambda (x) (* x x))" {
+		t.Errorf("source: got %+v", resp)
+	}
+	path := filepath.Join(t.TempDir(), "defs.l1")
+	if err := os.WriteFile(path, []byte("(def y 10)\n(+ y 1)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if resp := c.call(request{ID: "7", Op: "load-file", File: path}); resp.Value != "11" {
+		t.Errorf("load-file: got %+v", resp)
+	}
+	if resp := c.call(request{ID: "8", Op: "load-file", File: "buffer.l1", Code: "(def z 1)\n(car z)"}); !strings.Contains(resp.Err, "at buffer.l1:2:1") {
+		t.Errorf("load-file: got %+v", resp)
+	}
+	if resp := c.call(request{ID: "9", Op: "frobnicate"}); resp.Err != "unknown op frobnicate" {
+		t.Errorf("unknown op: got %+v", resp)
+	}
+
+	// Another session doesn't see the first one's definitions:
+	resp = c.call(request{ID: "10", Op: "clone"})
+	other := resp.NewSession
+	if other == "" || other == session {
+		t.Fatalf("clone: got %+v", resp)
+	}
+	if resp := c.call(request{ID: "11", Op: "eval", Session: other, Code: "(sq 3)"}); resp.ErrType != "unknown-symbol" {
+		t.Errorf("eval in new session: got %+v", resp)
+	}
+	// Sessions can be used from other connections:
+	c2 := dial(t, l)
+	if resp := c2.call(request{ID: "12", Op: "eval", Session: session, Code: "y"}); resp.Value != "10" {
+		t.Errorf("eval from another connection: got %+v", resp)
+	}
+	if resp := c.call(request{ID: "13", Op: "close", Session: other}); resp.Status[0] != "session-closed" {
+		t.Errorf("close: got %+v", resp)
+	}
+	if resp := c.call(request{ID: "14", Op: "eval", Session: other, Code: "1"}); resp.Status[0] != "unknown-session" {
+		t.Errorf("eval in closed session: got %+v", resp)
+	}
+}
+
+func TestServerInterrupt(t *testing.T) {
+	l := startServer(t, "tcp", "127.0.0.1:0")
+	c := dial(t, l)
+	session := c.call(request{ID: "1", Op: "eval", Code: "1"}).Session
+	c.send(request{ID: "2", Op: "eval", Code: "(loop)"})
+	// Interrupt from another connection, once the loop has started:
+	c2 := dial(t, l)
+	for {
+		resp := c2.call(request{ID: "3", Op: "interrupt", Session: session})
+		if resp.Status[0] == "done" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp := c.recv()
+	if resp.ID != "2" || resp.ErrType != "canceled" || resp.Status[0] != "interrupted" {
+		t.Errorf("got %+v", resp)
+	}
+	// The session carries on:
+	if resp := c.call(request{ID: "4", Op: "eval", Code: "(+ 1 1)"}); resp.Value != "2" {
+		t.Errorf("got %+v", resp)
+	}
+	// An interrupt which comes before the evaluation starts isn't lost:
+	c.send(request{ID: "5", Op: "eval", Code: "(loop)"})
+	c.send(request{ID: "6", Op: "interrupt"})
+	for i := 0; i < 2; i++ {
+		resp := c.recv()
+		if resp.ID == "5" && resp.ErrType != "canceled" || resp.ID == "6" && resp.Status[0] != "done" {
+			t.Errorf("got %+v", resp)
+		}
+	}
+	if resp := c.call(request{ID: "7", Op: "interrupt"}); resp.Status[0] != "session-idle" {
+		t.Errorf("got %+v", resp)
+	}
+}
+
+func TestServerOutputPerEval(t *testing.T) {
+	l := startServer(t, "tcp", "127.0.0.1:0")
+	c := dial(t, l)
+	code := "(spawn (lambda () (sleep 100) (print 'late))) (print 'now)"
+	if resp := c.call(request{ID: "1", Op: "eval", Code: code}); resp.Out != "now" {
+		t.Errorf("got %+v", resp)
+	}
+	// What the first evaluation's task prints isn't taken for the
+	// second's:
+	if resp := c.call(request{ID: "2", Op: "eval", Code: "(sleep 300) (print 'later)"}); resp.Out != "later" {
+		t.Errorf("got %+v", resp)
+	}
+}
+
+func TestServerSecurity(t *testing.T) {
+	// Sessions can't run programs by default:
+	c := dial(t, startServer(t, "tcp", "127.0.0.1:0"))
+	if resp := c.call(request{ID: "1", Op: "eval", Code: `(shell "ls")`}); resp.ErrType != "capability-denied" {
+		t.Errorf("got %+v", resp)
+	}
+	// Listen defaults to the loopback address:
+	l, err := Listen(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if !l.Addr().(*net.TCPAddr).IP.IsLoopback() {
+		t.Errorf("listening on %s", l.Addr())
+	}
+	// Other addresses need a token:
+	l2, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l2.Close()
+	if err := NewServer().Serve(l2); err == nil || !strings.Contains(err.Error(), "without a token") {
+		t.Errorf("got %v", err)
+	}
+	// Which requests must give:
+	srv := NewServer()
+	srv.Token = "secret"
+	go srv.Serve(l)
+	c = dial(t, l)
+	if resp := c.call(request{ID: "2", Op: "eval", Code: "1"}); resp.Status[0] != "unauthorized" {
+		t.Errorf("got %+v", resp)
+	}
+	if resp := c.call(request{ID: "3", Op: "eval", Code: "1", Token: "guess"}); resp.Status[0] != "unauthorized" {
+		t.Errorf("got %+v", resp)
+	}
+	if resp := c.call(request{ID: "4", Op: "eval", Code: "1", Token: "secret"}); resp.Value != "1" {
+		t.Errorf("got %+v", resp)
+	}
+}
+
+func TestServerOnUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "l1.sock")
+	l, err := Listen("unix:" + path)
+	if err != nil {
+		t.Skip(err)
+	}
+	go NewServer(WithPolicy(StrictPolicy)).Serve(l)
+	defer l.Close()
+	c := dial(t, l)
+	if resp := c.call(request{ID: "1", Op: "eval", Code: "(+ 1 2)"}); resp.Value != "3" {
+		t.Errorf("got %+v", resp)
+	}
+	// Sessions get the server's options:
+	if resp := c.call(request{ID: "2", Op: "load-file", File: path}); resp.ErrType != "capability-denied" {
+		t.Errorf("got %+v", resp)
+	}
+}
@@ -0,0 +1,99 @@
+package lisp
+
+import (
+	"math/big"
+)
+
+// Constructors and accessors for use by Go programs which embed l1.
+
+// Sym returns the atom with the given name.
+func Sym(s string) Atom {
+	return Atom{s}
+}
+
+// List returns a list of the given items.
+func List(xs ...Sexpr) *ConsCell {
+	return list(xs...)
+}
+
+// Int returns a number with the value of n.  n is copied, so later changes to
+// it do not affect the result.
+func Int(n *big.Int) Number {
+	var ret Number
+	ret.bi.Set(n)
+	return ret
+}
+
+// Float returns a floating-point number with the value f.
+func Float(f float64) Number {
+	return floatNumber(f)
+}
+
+// Car returns the first element of a list, and whether x was a list.  As in
+// l1, the car of () is ().
+func Car(x Sexpr) (Sexpr, bool) {
+	c, ok := x.(*ConsCell)
+	if !ok {
+		return nil, false
+	}
+	if c == Nil {
+		return Nil, true
+	}
+	return c.car, true
+}
+
+// Cdr returns all but the first element of a list (or the second element of a
+// dotted pair), and whether x was a list.  As in l1, the cdr of () is ().
+func Cdr(x Sexpr) (Sexpr, bool) {
+	c, ok := x.(*ConsCell)
+	if !ok {
+		return nil, false
+	}
+	if c == Nil {
+		return Nil, true
+	}
+	return c.cdr, true
+}
+
+// AsBigInt returns (a copy of) the value of an integer, and whether x was
+// one.
+func AsBigInt(x Sexpr) (*big.Int, bool) {
+	n, ok := x.(Number)
+	if !ok || !n.isInt() {
+		return nil, false
+	}
+	return new(big.Int).Set(&n.bi), true
+}
+
+// AsFloat returns the (nearest) floating-point value of any number, and
+// whether x was a number.
+func AsFloat(x Sexpr) (float64, bool) {
+	n, ok := x.(Number)
+	if !ok {
+		return 0, false
+	}
+	return n.toFloat(), true
+}
+
+// Symbol returns the name of an atom, and whether x was one.
+func Symbol(x Sexpr) (string, bool) {
+	a, ok := x.(Atom)
+	if !ok {
+		return "", false
+	}
+	return a.s, true
+}
+
+// Str returns a string with the given contents.
+func Str(s string) String {
+	return String{s}
+}
+
+// AsString returns the contents of a string, and whether x was one.
+func AsString(x Sexpr) (string, bool) {
+	s, ok := x.(String)
+	if !ok {
+		return "", false
+	}
+	return s.s, true
+}
@@ -1,6 +1,7 @@
 package lisp
 
 import (
+	"math/big"
 	"reflect"
 	"testing"
 )
@@ -130,3 +131,44 @@ func TestStrToSexprs(T *testing.T) {
 		}
 	}
 }
+
+func TestConstructorsAndAccessors(T *testing.T) {
+	l := List(Sym("a"), Int(big.NewInt(2)), List())
+	if l.String() != "(a 2 ())" {
+		T.Errorf("List(...) = %s", l)
+	}
+	car, ok := Car(l)
+	if !ok || !car.Equal(Sym("a")) {
+		T.Errorf("Car(%s) = %s, %v", l, car, ok)
+	}
+	cdr, ok := Cdr(l)
+	if !ok || cdr.String() != "(2 ())" {
+		T.Errorf("Cdr(%s) = %s, %v", l, cdr, ok)
+	}
+	if x, ok := Car(Nil); !ok || x != Nil {
+		T.Errorf("Car(()) = %s, %v", x, ok)
+	}
+	if _, ok := Cdr(Sym("a")); ok {
+		T.Error("Cdr of an atom should fail")
+	}
+	if s, ok := Symbol(car); !ok || s != "a" {
+		T.Errorf("Symbol(%s) = %q, %v", car, s, ok)
+	}
+	if _, ok := Symbol(Num(1)); ok {
+		T.Error("Symbol of a number should fail")
+	}
+	big1 := big.NewInt(7)
+	n := Int(big1)
+	big1.SetInt64(8)
+	bi, ok := AsBigInt(n)
+	if !ok || bi.Int64() != 7 {
+		T.Errorf("AsBigInt(%s) = %v, %v", n, bi, ok)
+	}
+	bi.SetInt64(9)
+	if n.String() != "7" {
+		T.Errorf("AsBigInt should return a copy; got %s", n)
+	}
+	if _, ok := AsBigInt(Sym("seven")); ok {
+		T.Error("AsBigInt of an atom should fail")
+	}
+}
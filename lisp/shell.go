@@ -7,15 +7,15 @@ import (
 	"syscall"
 )
 
-func splitOnSpace(c rune) bool {
-	return c == ' ' || c == '\t'
-}
-
+// shapeOutput returns the lines of a subprocess's output, as strings.
 func shapeOutput(s string) *ConsCell {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return Nil
+	}
 	ret := []Sexpr{}
-	lines := strings.Split(strings.Trim(s, "\n"), "\n")
-	for _, l := range lines {
-		ret = append(ret, stringsToList(strings.FieldsFunc(l, splitOnSpace)...))
+	for _, l := range strings.Split(s, "\n") {
+		ret = append(ret, String{strings.TrimSuffix(l, "\r")})
 	}
 	return list(ret...)
 }
@@ -30,8 +30,10 @@ func doShell(arg Sexpr) (Sexpr, error) {
 		switch t := cmdCons.car.(type) {
 		case Atom:
 			cmdStrings = append(cmdStrings, t.s)
+		case String:
+			cmdStrings = append(cmdStrings, t.s)
 		case Number:
-			cmdStrings = append(cmdStrings, t.bi.String())
+			cmdStrings = append(cmdStrings, t.String())
 		default:
 			return nil, baseErrorf("shell argument must be a nonempty list of strings")
 		}
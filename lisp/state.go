@@ -1,12 +1,13 @@
 package lisp
 
-import "fmt"
+import (
+	"fmt"
+	"sync/atomic"
+)
 
-var gensymCounter = 0
+// gensymCounter is shared by all tasks, so is updated atomically.
+var gensymCounter atomic.Int64
 
-// Currently we only have one thread.  If we ever have concurrency, ensure
-// thread safety.
 func gensym(prefix string) string {
-	gensymCounter++
-	return fmt.Sprintf("<gensym%s-%d>", prefix, gensymCounter)
+	return fmt.Sprintf("<gensym%s-%d>", prefix, gensymCounter.Add(1))
 }
@@ -0,0 +1,33 @@
+package lisp
+
+import "strconv"
+
+// String is a sequence of characters, written in double quotes: "like this".
+type String struct {
+	s string
+}
+
+// String returns the string as it would be written in l1 source code, in
+// double quotes and with special characters escaped.
+func (s String) String() string {
+	return strconv.Quote(s.s)
+}
+
+// Equal returns true if the receiver and the arg are both strings and have the
+// same contents.
+func (s String) Equal(o Sexpr) bool {
+	if o, ok := o.(String); ok {
+		return s.s == o.s
+	}
+	return false
+}
+
+// display returns the representation of x used when printing it for people
+// to read: strings appear without quotes or escapes, everything else as
+// usual.
+func display(x Sexpr) string {
+	if s, ok := x.(String); ok {
+		return s.s
+	}
+	return x.String()
+}
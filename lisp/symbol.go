@@ -0,0 +1,174 @@
+package lisp
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// symbol is an interned atom name: the same name always has the same symbol,
+// so environments can be searched, and global values found, without hashing
+// or comparing strings.
+type symbol uint32
+
+// Symbols are never freed, so the table grows with each distinct name bound
+// or compiled by any interpreter in the process.  That's bounded by the code
+// run, unless the code makes up names as it runs and binds them, as by
+// evaluating `def` forms it builds.
+var symbols = struct {
+	sync.RWMutex
+	ids   map[string]symbol
+	names []string
+}{ids: map[string]symbol{}}
+
+var trueSymbol = intern("t")
+
+// intern returns the symbol for name, making it if need be.
+func intern(name string) symbol {
+	symbols.RLock()
+	id, ok := symbols.ids[name]
+	symbols.RUnlock()
+	if ok {
+		return id
+	}
+	symbols.Lock()
+	defer symbols.Unlock()
+	if id, ok := symbols.ids[name]; ok {
+		return id
+	}
+	id = symbol(len(symbols.names))
+	symbols.ids[name] = id
+	symbols.names = append(symbols.names, name)
+	return id
+}
+
+// lookupSymbol returns the symbol for name, if it has been interned; a name
+// which hasn't been can't be bound anywhere.
+func lookupSymbol(name string) (symbol, bool) {
+	symbols.RLock()
+	defer symbols.RUnlock()
+	id, ok := symbols.ids[name]
+	return id, ok
+}
+
+func (s symbol) String() string {
+	symbols.RLock()
+	defer symbols.RUnlock()
+	return symbols.names[s]
+}
+
+// builtinVals holds the builtins native to l1, indexed by the symbols for
+// their names, as the values every top-level environment starts with.  It's
+// made once, in init, and never changed after that.
+var builtinVals []*globalVal
+
+// globals holds the values of the symbols bound in a top-level environment,
+// indexed by symbol, starting with the builtins.  Tasks running at once (see
+// `spawn`) share it, so values are read without locking, and set one at a
+// time.  A module's top-level environment sees the values of the one it was
+// defined in, its parent.
+type globals struct {
+	mu      sync.Mutex // held while setting a value, or importing a module
+	vals    atomic.Pointer[[]atomic.Pointer[globalVal]]
+	parent  *globals
+	module  *Module            // the module these are the values of, if any
+	imports map[string]*Module // by alias
+	modules *modules           // shared with the parent
+}
+
+type globalVal struct {
+	v Sexpr
+}
+
+// newGlobals returns the values of a new top-level environment: the
+// builtins.
+func newGlobals() *globals {
+	g := &globals{}
+	vals := make([]atomic.Pointer[globalVal], len(builtinVals))
+	for i, v := range builtinVals {
+		vals[i].Store(v)
+	}
+	g.vals.Store(&vals)
+	return g
+}
+
+func (g *globals) get(s symbol) Sexpr {
+	for ; g != nil; g = g.parent {
+		if v := g.own(s); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// own returns the value of s set in g itself, rather than in a parent.
+func (g *globals) own(s symbol) Sexpr {
+	if vals := g.vals.Load(); vals != nil && int(s) < len(*vals) {
+		if p := (*vals)[s].Load(); p != nil {
+			return p.v
+		}
+	}
+	return nil
+}
+
+func (g *globals) set(s symbol, v Sexpr) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	vals := g.vals.Load()
+	if vals == nil || int(s) >= len(*vals) {
+		// Readers may still be using the old table, so make a new one,
+		// with room to spare so that growing it is amortized:
+		n := int(s) + 1
+		if vals != nil && n < 2*len(*vals) {
+			n = 2 * len(*vals)
+		}
+		grown := make([]atomic.Pointer[globalVal], n)
+		if vals != nil {
+			for i := range *vals {
+				grown[i].Store((*vals)[i].Load())
+			}
+		}
+		vals = &grown
+		g.vals.Store(vals)
+	}
+	(*vals)[s].Store(&globalVal{v})
+}
+
+// each calls f with each symbol bound in g, and its value.
+func (g *globals) each(f func(symbol, Sexpr)) {
+	vals := g.vals.Load()
+	if vals == nil {
+		return
+	}
+	for i := range *vals {
+		if p := (*vals)[i].Load(); p != nil {
+			f(symbol(i), p.v)
+		}
+	}
+}
+
+// snapshot returns the values bound in g, so that the symbols set since can
+// be found with setSince.
+func (g *globals) snapshot() map[symbol]*globalVal {
+	ret := map[symbol]*globalVal{}
+	if vals := g.vals.Load(); vals != nil {
+		for i := range *vals {
+			if p := (*vals)[i].Load(); p != nil {
+				ret[symbol(i)] = p
+			}
+		}
+	}
+	return ret
+}
+
+// setSince returns the symbols set in g since snap was taken.
+func (g *globals) setSince(snap map[symbol]*globalVal) []symbol {
+	ret := []symbol{}
+	if vals := g.vals.Load(); vals != nil {
+		for i := range *vals {
+			if p := (*vals)[i].Load(); p != nil && snap[symbol(i)] != p {
+				ret = append(ret, symbol(i))
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,176 @@
+package lisp
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+// Tasks are functions running at once, each in a goroutine of its own, made
+// by `spawn`; they pass values to each other through channels.  Tasks share
+// the top-level environment, which is safe for concurrent use, and whatever
+// lexical environments the functions they run close over, which aren't:
+// as in Go, values changed by one task and read by another should be passed
+// through a channel.
+
+// Task is a function running in a goroutine of its own.
+type Task struct {
+	done  chan struct{} // closed when the function returns
+	val   Sexpr
+	err   error
+	depth atomic.Int64 // calls being evaluated; see limits
+	// Where the task prints, if the output of the evaluation which spawned
+	// it was being captured:
+	out *captureWriter
+}
+
+// String returns a representation of the task.
+func (t *Task) String() string {
+	return "<task>"
+}
+
+// Equal returns true if o is the same task.
+func (t *Task) Equal(o Sexpr) bool {
+	o2, ok := o.(*Task)
+	return ok && t == o2
+}
+
+// wait waits for the task's function to return, and returns its result,
+// unless evaluation in e is stopped first.
+func (t *Task) wait(e *Env) (Sexpr, error) {
+	select {
+	case <-t.done:
+	case <-e.done():
+		return nil, e.stopped()
+	}
+	if t.err != nil {
+		return nil, extendError("await", t.err)
+	}
+	return t.val, nil
+}
+
+// Channel passes values from one task to another, as a Go channel does.
+type Channel struct {
+	ch chan Sexpr
+}
+
+// String returns a representation of the channel.
+func (c *Channel) String() string {
+	return "<chan>"
+}
+
+// Equal returns true if o is the same channel.
+func (c *Channel) Equal(o Sexpr) bool {
+	o2, ok := o.(*Channel)
+	return ok && c == o2
+}
+
+// send sends x on c, unless evaluation in e is stopped first.
+func (c *Channel) send(x Sexpr, e *Env) error {
+	select {
+	case c.ch <- x:
+		return nil
+	case <-e.done():
+		return e.stopped()
+	}
+}
+
+// recv receives a value from c, unless evaluation in e is stopped first.
+func (c *Channel) recv(e *Env) (Sexpr, error) {
+	select {
+	case x := <-c.ch:
+		return x, nil
+	case <-e.done():
+		return nil, e.stopped()
+	}
+}
+
+// taskArg returns the Task argument of a builtin.
+func taskArg(arg Sexpr) (*Task, error) {
+	t, ok := arg.(*Task)
+	if !ok {
+		return nil, typedErrorf("type-error", arg, "'%s' is not a task", arg)
+	}
+	return t, nil
+}
+
+// channelArg returns the Channel argument of a builtin.
+func channelArg(arg Sexpr) (*Channel, error) {
+	c, ok := arg.(*Channel)
+	if !ok {
+		return nil, typedErrorf("type-error", arg, "'%s' is not a channel", arg)
+	}
+	return c, nil
+}
+
+// spawn calls f with args in a new task.  Builtins called by the task are
+// given an environment of their own below e, so that tasks don't bind
+// names in each other's.
+func spawn(f Sexpr, args []Sexpr, e *Env) (*Task, error) {
+	switch f.(type) {
+	case *lambdaFn, *Builtin:
+	default:
+		return nil, typedErrorf("type-error", f, "%s is not a function", f)
+	}
+	t := &Task{done: make(chan struct{}), out: e.captured()}
+	env := mkFrame(e, nil, nil)
+	env.task = t
+	go func() {
+		defer close(t.done)
+		t.val, t.err = funcall(f, args, env)
+		if isEscape(t.err) {
+			// The call/cc is in the stack of another goroutine:
+			t.err = baseError("continuation called from another task")
+		}
+	}()
+	return t, nil
+}
+
+// selectChannels waits until one of the operations in args can proceed, and
+// carries it out: an argument which is a channel receives from it, and a
+// list `(ch value)` sends the value on ch.  It returns a list of the channel
+// and the value received or sent.
+func selectChannels(args []Sexpr, e *Env) (Sexpr, error) {
+	if len(args) == 0 {
+		return nil, typedError("arity-error", Nil, "select expects at least one argument")
+	}
+	cases := make([]reflect.SelectCase, len(args))
+	chans := make([]*Channel, len(args))
+	for i, arg := range args {
+		if l, ok := arg.(*ConsCell); ok && l != Nil {
+			items, err := consToExprs(l)
+			if err != nil || len(items) != 2 {
+				return nil, typedErrorf("type-error", arg, "select send case '%s' must be a channel and a value", arg)
+			}
+			c, err := channelArg(items[0])
+			if err != nil {
+				return nil, extendError("select", err)
+			}
+			chans[i] = c
+			cases[i] = reflect.SelectCase{
+				Dir:  reflect.SelectSend,
+				Chan: reflect.ValueOf(c.ch),
+				Send: reflect.ValueOf(&items[1]).Elem(),
+			}
+			continue
+		}
+		c, err := channelArg(arg)
+		if err != nil {
+			return nil, extendError("select", err)
+		}
+		chans[i] = c
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.ch)}
+	}
+	if done := e.done(); done != nil {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+	}
+	i, recv, _ := reflect.Select(cases)
+	if i == len(args) {
+		return nil, e.stopped()
+	}
+	if cases[i].Dir == reflect.SelectSend {
+		e.countConses(2)
+		return list(chans[i], cases[i].Send.Interface().(Sexpr)), nil
+	}
+	e.countConses(2)
+	return list(chans[i], recv.Interface().(Sexpr)), nil
+}
@@ -1,30 +1,47 @@
 package lisp
 
 import (
+	"sync"
+
 	"github.com/gdamore/tcell"
 	"github.com/mattn/go-runewidth"
 )
 
 // For now, there is a global screen object only visible to Go code, to avoid
 // leaking the screen object outside of the abstraction provided by this file.
-var screen tcell.Screen
+// Since tasks may use it at once, screenMu is held while it's started or
+// ended, or read; the tcell screen is safe for concurrent use itself.
+var (
+	screen   tcell.Screen
+	screenMu sync.Mutex
+)
+
+// currentScreen returns the screen, or nil if it isn't initialized.
+func currentScreen() tcell.Screen {
+	screenMu.Lock()
+	defer screenMu.Unlock()
+	return screen
+}
 
 func termStart() error {
-	var err error
+	screenMu.Lock()
+	defer screenMu.Unlock()
 	if screen != nil {
 		return baseError("screen already initialized")
 	}
-	screen, err = tcell.NewScreen()
+	s, err := tcell.NewScreen()
 	if err != nil {
 		return extendError("termStart NewScreen", err)
 	}
-	if err := screen.Init(); err != nil {
+	if err := s.Init(); err != nil {
 		return extendError("termStart Init", err)
 	}
+	screen = s
 	return nil
 }
 
 func termClear() error {
+	screen := currentScreen()
 	if screen == nil {
 		return baseError("screen not initialized")
 	}
@@ -34,6 +51,8 @@ func termClear() error {
 }
 
 func termEnd() error {
+	screenMu.Lock()
+	defer screenMu.Unlock()
 	if screen == nil {
 		// Do nothing -- already ended / not initialized
 		return nil
@@ -44,6 +63,7 @@ func termEnd() error {
 }
 
 func termDrawText(x, y int, str string) error {
+	screen := currentScreen()
 	if screen == nil {
 		return baseError("screen not initialized")
 	}
@@ -64,6 +84,7 @@ func termDrawText(x, y int, str string) error {
 }
 
 func termSize() (int, int, error) {
+	screen := currentScreen()
 	if screen == nil {
 		return 0, 0, baseError("screen not initialized")
 	}
@@ -72,6 +93,7 @@ func termSize() (int, int, error) {
 }
 
 func termGetKey() (string, error) {
+	screen := currentScreen()
 	if screen == nil {
 		return "", baseError("screen not initialized")
 	}
@@ -0,0 +1,86 @@
+package lisp
+
+import "strings"
+
+// Vector is a sequence of values with constant-time access by index,
+// written `[a b c]`.  Unlike lists, vectors can be changed in place (with
+// `vset!` and `vpush!`).
+type Vector struct {
+	items []Sexpr
+}
+
+// String returns the vector in the same `[a b c]` form used to write it.
+func (v *Vector) String() string {
+	parts := make([]string, len(v.items))
+	for i, item := range v.items {
+		parts[i] = item.String()
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// Equal returns true if o is a vector of the same length whose elements are
+// Equal to the receiver's.
+func (v *Vector) Equal(o Sexpr) bool {
+	o2, ok := o.(*Vector)
+	if !ok || len(v.items) != len(o2.items) {
+		return false
+	}
+	for i, item := range v.items {
+		if !item.Equal(o2.items[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// evalVector evaluates the elements of a vector literal, returning a new
+// vector.
+func evalVector(v *Vector, e *Env) (Sexpr, error) {
+	items := make([]Sexpr, len(v.items))
+	for i, item := range v.items {
+		evaled, err := eval(item, e)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = evaled
+	}
+	return &Vector{items}, nil
+}
+
+// vectorArg returns the Vector argument of a builtin.
+func vectorArg(arg Sexpr) (*Vector, error) {
+	v, ok := arg.(*Vector)
+	if !ok {
+		return nil, typedErrorf("type-error", arg, "'%s' is not a vector", arg)
+	}
+	return v, nil
+}
+
+// vectorIndex returns the value of arg as an index into v; if end is true,
+// the index may be one past the end of the vector.
+func vectorIndex(v *Vector, arg Sexpr, end bool) (int, error) {
+	n, ok := arg.(Number)
+	if !ok || !n.isInt() {
+		return 0, typedErrorf("type-error", arg, "'%s' is not an integer", arg)
+	}
+	limit := int64(len(v.items))
+	if !end {
+		limit--
+	}
+	if !n.bi.IsInt64() || n.bi.Int64() < 0 || n.bi.Int64() > limit {
+		return 0, typedErrorf("index-error", n, "index %s out of range for vector of length %d", n, len(v.items))
+	}
+	return int(n.bi.Int64()), nil
+}
+
+// seqItems returns the elements of a list or vector.
+func seqItems(arg Sexpr) ([]Sexpr, error) {
+	switch t := arg.(type) {
+	case *Vector:
+		return t.items, nil
+	case *ConsCell:
+		return consToExprs(t)
+	default:
+		return nil, typedErrorf("type-error", arg, "'%s' is not a list or vector", arg)
+	}
+}
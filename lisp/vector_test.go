@@ -0,0 +1,51 @@
+package lisp
+
+import "testing"
+
+func TestVectorIndex(t *testing.T) {
+	v := &Vector{[]Sexpr{Atom{"a"}, Atom{"b"}}}
+	var tests = []struct {
+		i    Sexpr
+		end  bool
+		want int
+		ok   bool
+	}{
+		{Num(0), false, 0, true},
+		{Num(1), false, 1, true},
+		{Num(2), false, 0, false},
+		{Num(2), true, 2, true},
+		{Num(3), true, 0, false},
+		{Num(-1), true, 0, false},
+		{Num("123456789012345678901234567890"), true, 0, false},
+		{Num(1.0), false, 0, false},
+		{Atom{"a"}, false, 0, false},
+	}
+	for _, test := range tests {
+		got, err := vectorIndex(v, test.i, test.end)
+		if (err == nil) != test.ok {
+			t.Errorf("vectorIndex(%s, %s, %v) error = %v, want ok: %v",
+				v, test.i, test.end, err, test.ok)
+			continue
+		}
+		if test.ok && got != test.want {
+			t.Errorf("vectorIndex(%s, %s, %v) = %d, want %d",
+				v, test.i, test.end, got, test.want)
+		}
+	}
+}
+
+func TestVectorEqual(t *testing.T) {
+	a := &Vector{[]Sexpr{Num(1), list(Atom{"x"})}}
+	b := &Vector{[]Sexpr{Num(1.0), list(Atom{"x"})}}
+	if !a.Equal(b) {
+		t.Errorf("%s should equal %s", a, b)
+	}
+	for _, o := range []Sexpr{&Vector{[]Sexpr{Num(1)}}, list(Num(1), list(Atom{"x"})), Nil} {
+		if a.Equal(o) {
+			t.Errorf("%s should not equal %s", a, o)
+		}
+	}
+	if got := a.String(); got != "[1 (x)]" {
+		t.Errorf("String() = %q, want %q", got, "[1 (x)]")
+	}
+}
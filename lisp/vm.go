@@ -0,0 +1,476 @@
+package lisp
+
+import (
+	"sync"
+)
+
+// frame is the activation of compiled code: a lambda function's body, or a
+// form being evaluated.
+type frame struct {
+	code *code
+	pc   int
+	env  *Env
+	sp   int // height of the stack when the frame was entered
+	// The innermost form in tail position in the frames this one replaced
+	// with tail calls, for locating errors as the walker does:
+	tailCur Sexpr
+	proto   *lambdaProto // of the function running, if any
+}
+
+// vm runs compiled code.
+type vm struct {
+	stack  []Sexpr
+	frames []frame
+	// After an error, the innermost form in tail position in the code run:
+	cur Sexpr
+	// The limits on evaluation, if any; each frame counts as a call by
+	// the task running the code:
+	lim  *limits
+	task *Task
+}
+
+var vmPool = sync.Pool{
+	New: func() any { return &vm{} },
+}
+
+// evalCode runs c, compiled from expr, in e.
+func evalCode(c *code, expr Sexpr, e *Env) (Sexpr, error) {
+	v := vmPool.Get().(*vm)
+	defer vmPool.Put(v)
+	ret, err := v.run(c, e)
+	if err != nil {
+		cur := v.cur
+		if cur == nil {
+			cur = expr
+		}
+		return nil, locateError(err, cur, expr)
+	}
+	return ret, nil
+}
+
+// runCode runs c in e; c must locate its own errors, as code compiled with
+// compileSeq does.
+func runCode(c *code, e *Env) (Sexpr, error) {
+	v := vmPool.Get().(*vm)
+	defer vmPool.Put(v)
+	return v.run(c, e)
+}
+
+// evalBody evaluates the body of f in e, in which its arguments are bound,
+// with no form in tail position.
+func evalBody(f *lambdaFn, e *Env) (Sexpr, error) {
+	if e.treeWalking() {
+		var ret Sexpr = Nil
+		for body := f.body; body != Nil; {
+			var err error
+			if ret, err = eval(body.car, e); err != nil {
+				return nil, err
+			}
+			var ok bool
+			if body, ok = body.cdr.(*ConsCell); !ok {
+				return nil, baseError("lambda body must be a list")
+			}
+		}
+		return ret, nil
+	}
+	c, err := f.seqCode(e)
+	if err != nil {
+		return nil, err
+	}
+	return runCode(c, e)
+}
+
+// callEnv makes the environment for a call of f with args by code running
+// in caller.  For compiled code, the arguments are laid out as f's compiled
+// body expects them; the walker binds them one by one, as it always has.
+func callEnv(f *lambdaFn, args []Sexpr, caller *Env) (*Env, error) {
+	if f.env.treeWalking() {
+		e := mkEnv(f.env)
+		e.task = caller.task
+		if err := setLambdaArgsInEnv(&e, f, args); err != nil {
+			return nil, err
+		}
+		return &e, nil
+	}
+	required, optional, more, err := f.splitArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range f.params {
+		if name == trueSymbol {
+			err := baseError("cannot bind or set t")
+			if i == 0 && f.restArg != noRestArg {
+				return nil, err
+			}
+			return nil, extendError("setting lambda arg", err)
+		}
+	}
+	names := f.params
+	if c := f.proto.body.Load(); c != nil {
+		names = c.names
+	} else if c := f.proto.seq.Load(); c != nil {
+		names = c.names
+	}
+	vals := make([]Sexpr, len(f.params))
+	if f.restArg != noRestArg {
+		caller.countConses(len(more))
+		vals[0] = mkListAsConsWithCdr(more, Nil)
+		vals = vals[:1]
+	} else {
+		vals = vals[:0]
+	}
+	if !f.patterns {
+		vals = append(vals, required...)
+	} else {
+		pattern := f.args
+		for _, arg := range required {
+			if vals, err = destructure(pattern.car, arg, vals); err != nil {
+				return nil, extendError("setting lambda arg", err)
+			}
+			pattern = pattern.cdr.(*ConsCell)
+		}
+	}
+	e := mkFrame(f.env, names, vals)
+	e.task = caller.task
+	if len(f.optional) > 0 || len(f.keys) > 0 {
+		if err := f.bindOptional(e, optional, more); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// get returns the value of the variable r in e, the environment the code
+// referring to it runs in.
+func (r *varRef) get(e *Env) (Sexpr, error) {
+	if r.depth < 0 {
+		if v := e.globals.get(r.sym); v != nil {
+			return v, nil
+		}
+	} else if v := r.local(e); v != nil {
+		return *v, nil
+	}
+	// The variable isn't bound where the compiler expected, as the name of
+	// a lambda before it's made, or one made by code given to eval; look it
+	// up as the walker would:
+	return evAtom(r.name, e)
+}
+
+// local returns where the value of the local variable r is kept in e, or
+// nil if it isn't bound there.
+func (r *varRef) local(e *Env) *Sexpr {
+	if r.depth < 0 {
+		return nil
+	}
+	for d := r.depth; d > 0 && e != nil; d-- {
+		e = e.parent
+	}
+	if e == nil || e.parent == nil || r.slot >= len(e.vals) || e.names[r.slot] != r.sym ||
+		e.vals[r.slot] == nil {
+		return nil
+	}
+	return &e.vals[r.slot]
+}
+
+// fresh returns a copy of the condition for err, so that raising it again
+// doesn't change it.
+func fresh(err error) error {
+	c := asCondition(err)
+	info := *c.conditionInfo
+	return &Condition{&info, c.trace, c.located}
+}
+
+func (v *vm) push(x Sexpr) {
+	v.stack = append(v.stack, x)
+}
+
+func (v *vm) pop() Sexpr {
+	x := v.stack[len(v.stack)-1]
+	v.stack = v.stack[:len(v.stack)-1]
+	return x
+}
+
+// run runs c in e, returning the value it returns.
+func (v *vm) run(c *code, e *Env) (Sexpr, error) {
+	base := len(v.frames)
+	v.task = e.task
+	if v.lim = e.limits(); v.lim != nil {
+		if err := v.lim.enter(v.task); err != nil {
+			return nil, err
+		}
+	}
+	v.frames = append(v.frames, frame{code: c, env: e, sp: len(v.stack)})
+	for {
+		f := &v.frames[len(v.frames)-1]
+		in := f.code.ops[f.pc]
+		f.pc++
+		var err error
+		if v.lim != nil {
+			if err = v.lim.step(); err != nil {
+				return nil, v.unwind(err, base)
+			}
+		}
+		switch in.op() {
+		case opConst:
+			v.push(f.code.consts[in.arg()])
+		case opVar:
+			var val Sexpr
+			if val, err = f.code.refs[in.arg()].get(f.env); err == nil {
+				v.push(val)
+			}
+		case opCxr:
+			l := *f.code.consts[in.arg()].(*lambdaFn)
+			l.env = f.env
+			v.push(&l)
+		case opCallee:
+			site := &f.code.calls[in.arg()]
+			var val Sexpr
+			if val, err = f.code.refs[site.ref].get(f.env); err != nil {
+				break
+			}
+			if l, ok := val.(*lambdaFn); ok && l.isMacro {
+				// The macro was defined after the code was compiled;
+				// expand the call as the walker would have:
+				f.pc = site.after
+				err = v.expand(f, site)
+				break
+			}
+			v.push(val)
+		case opExpand:
+			err = v.expand(f, &f.code.calls[in.arg()])
+		case opCall, opTailCall:
+			site := &f.code.calls[in.arg()]
+			top := len(v.stack)
+			fn := v.stack[top-site.argc-1]
+			args := v.stack[top-site.argc:]
+			switch t := fn.(type) {
+			case *lambdaFn:
+				var newEnv *Env
+				if newEnv, err = callEnv(t, args, f.env); err != nil {
+					err = extendError("lambda env setup", err)
+					break
+				}
+				clear(v.stack[top-site.argc-1:])
+				v.stack = v.stack[:top-site.argc-1]
+				body := t.bodyCode(newEnv)
+				if in.op() == opTailCall {
+					if n := f.code.nodes[f.pc-1]; n >= 0 && f.code.tree[n].kind == tailNode {
+						f.tailCur = f.code.tree[n].form
+					}
+					*f = frame{code: body, env: newEnv, sp: f.sp, tailCur: f.tailCur, proto: t.proto}
+				} else if err = v.enter(); err == nil {
+					v.frames = append(v.frames, frame{code: body, env: newEnv, sp: len(v.stack), proto: t.proto})
+				}
+			case *Builtin:
+				argv := make([]Sexpr, site.argc)
+				copy(argv, args)
+				v.stack = v.stack[:top-site.argc-1]
+				var ret Sexpr
+				if ret, err = t.Fn(argv, f.env); err != nil {
+					err = extendError("builtin function "+t.Name, err)
+					break
+				}
+				if in.op() == opTailCall {
+					if v.returnFrom(base) {
+						return ret, nil
+					}
+				}
+				v.push(ret)
+			default:
+				err = typedErrorf("type-error", fn, "%s is not a function", fn)
+			}
+		case opReturn:
+			ret := v.pop()
+			if v.returnFrom(base) {
+				return ret, nil
+			}
+			v.push(ret)
+		case opPop:
+			clear(v.stack[len(v.stack)-in.arg():])
+			v.stack = v.stack[:len(v.stack)-in.arg()]
+		case opJump:
+			f.pc = in.arg()
+		case opJumpIfNil:
+			if v.pop() == Nil {
+				f.pc = in.arg()
+			}
+		case opJumpUnlessNil:
+			if v.stack[len(v.stack)-1] != Nil {
+				f.pc = in.arg()
+			} else {
+				v.pop()
+			}
+		case opLet:
+			site := &f.code.lets[in.arg()]
+			n := site.n
+			if site.patterns != nil {
+				n = len(site.patterns)
+			}
+			vals := make([]Sexpr, n, site.n)
+			copy(vals, v.stack[len(v.stack)-n:])
+			clear(v.stack[len(v.stack)-n:])
+			v.stack = v.stack[:len(v.stack)-n]
+			if site.patterns != nil {
+				if vals, err = destructureAll(site.patterns, vals); err != nil {
+					err = extendError("setting let bindings", err)
+					break
+				}
+			}
+			f.env = mkFrame(f.env, site.names, vals)
+		case opPopEnv:
+			f.env = f.env.parent
+		case opLambda:
+			site := &f.code.lambdas[in.arg()]
+			var l *lambdaFn
+			if l, err = mkLambda(site.args, false, f.env); err == nil {
+				l.proto = site.proto
+				v.push(l)
+			}
+		case opDefn:
+			site := &f.code.lambdas[in.arg()]
+			l, lerr := mkLambda(site.args, site.isMacro, f.env)
+			if lerr != nil {
+				err = extendError("creating lambda function", lerr)
+				break
+			}
+			l.proto = site.proto
+			if err = f.env.SetTopLevel(site.name, l); err != nil {
+				err = extendError("setting defn result", err)
+				break
+			}
+			v.push(Nil)
+		case opDef:
+			name := f.code.consts[in.arg()].(Atom).s
+			if err = f.env.SetTopLevel(name, v.stack[len(v.stack)-1]); err != nil {
+				err = extendError("setting def result", err)
+			}
+		case opSet:
+			ref := &f.code.refs[in.arg()]
+			if p := ref.local(f.env); p != nil {
+				*p = v.stack[len(v.stack)-1]
+			} else if err = f.env.Update(ref.name.s, v.stack[len(v.stack)-1]); err != nil {
+				err = extendError("updating set result", err)
+			}
+		case opError:
+			err = NewCondition("error", v.pop(), Nil)
+		case opRaise:
+			err = raiseCondition(v.stack[len(v.stack)-in.arg():])
+		case opRethrow:
+			var c *Condition
+			if c, err = conditionArg(v.pop()); err == nil {
+				err = c
+			}
+		case opHash:
+			n := 2 * in.arg()
+			vals := v.stack[len(v.stack)-n:]
+			h := mkHashMap()
+			for i := 0; i < n && err == nil; i += 2 {
+				err = h.set(vals[i], vals[i+1])
+			}
+			v.stack = v.stack[:len(v.stack)-n]
+			v.push(h)
+		case opVector:
+			items := make([]Sexpr, in.arg())
+			copy(items, v.stack[len(v.stack)-in.arg():])
+			v.stack = v.stack[:len(v.stack)-in.arg()]
+			v.push(&Vector{items})
+		case opFail:
+			err = fresh(f.code.fails[in.arg()])
+		case opNative:
+			var val Sexpr
+			if val, err = f.code.natives[in.arg()](f.env); err == nil {
+				v.push(val)
+			}
+		}
+		if err != nil {
+			return nil, v.unwind(err, base)
+		}
+	}
+}
+
+// returnFrom leaves the current frame, returning true if it was the frame
+// run was called for.
+func (v *vm) returnFrom(base int) bool {
+	f := &v.frames[len(v.frames)-1]
+	clear(v.stack[f.sp:])
+	v.stack = v.stack[:f.sp]
+	v.frames = v.frames[:len(v.frames)-1]
+	if v.lim != nil {
+		v.lim.leave(v.task, 1)
+	}
+	return len(v.frames) == base
+}
+
+// expand evaluates the macro call at site, in frame f, as the walker does:
+// by expanding it, and evaluating the expansion in place of the call.
+func (v *vm) expand(f *frame, site *callSite) error {
+	expansion, err := macroexpand(site.form, f.env)
+	if err != nil {
+		return extendError("eval macroexpansion", err)
+	}
+	if f.proto != nil {
+		// Recompile the function, now that the macro is known, when it's
+		// next called:
+		f.proto.body.Store(nil)
+	}
+	c := compileExpansion(expansion, f.env)
+	if site.tail {
+		if n := f.code.nodes[site.after-1]; n >= 0 && f.code.tree[n].kind == tailNode {
+			f.tailCur = f.code.tree[n].form
+		}
+		*f = frame{code: c, env: f.env, sp: f.sp, tailCur: f.tailCur}
+		return nil
+	}
+	f.pc = site.after
+	if err := v.enter(); err != nil {
+		return err
+	}
+	v.frames = append(v.frames, frame{code: c, env: f.env, sp: len(v.stack)})
+	return nil
+}
+
+// enter counts a new frame against the limit on the depth of calls.
+func (v *vm) enter() error {
+	if v.lim == nil {
+		return nil
+	}
+	return v.lim.enter(v.task)
+}
+
+// unwind adds the frames to err that the walker would have as it returned
+// through the frames from the current one down to base, which it removes.
+func (v *vm) unwind(err error, base int) error {
+	var cur Sexpr
+	for i := len(v.frames) - 1; i >= base; i-- {
+		f := &v.frames[i]
+		for n := f.code.nodes[f.pc-1]; n >= 0; {
+			nd := &f.code.tree[n]
+			switch nd.kind {
+			case tailNode:
+				if cur == nil {
+					cur = nd.form
+				}
+			case frameNode:
+				err = extendWithList(nd.frame, err)
+			case evalNode:
+				if cur == nil {
+					cur = nd.form
+				}
+				err = locateError(err, cur, nd.form)
+				cur = nil
+			}
+			n = nd.parent
+		}
+		if cur == nil {
+			cur = f.tailCur
+		}
+	}
+	clear(v.stack[v.frames[base].sp:])
+	v.stack = v.stack[:v.frames[base].sp]
+	if v.lim != nil {
+		v.lim.leave(v.task, len(v.frames)-base)
+	}
+	v.frames = v.frames[:base]
+	v.cur = cur
+	return err
+}
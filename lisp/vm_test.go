@@ -0,0 +1,256 @@
+package lisp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestCompiledMatchesTreeWalker(t *testing.T) {
+	var tests = []string{
+		"(foo 1)",
+		"(+ 1\n (car 1))",
+		"(defn f (x)\n  (car x)\n  x)\n(defn g (y)\n  (f y)\n  y)\n(g 3)",
+		"(defn f (x) (car x))\n(defn g (y) (f y))\n(+ 1 (g 3))",
+		"(defn k (n) (if (zero? n) (car n) (k (- n 1))))\n(k 10)",
+		"(defn k (n) (if (zero? n) (progn (car n) 1) (k (- n 1))))\n(k 10)",
+		"(defn k (n) (cond ((zero? n) (car 0)) (t (k (- n 1)))))\n(defn outer () (k 3) 1)\n(outer)",
+		"(defn f (x) (let ((y x)) (g y)))\n(defn g (y) (car y))\n(f 1)",
+		"(let ((x 1))\n  (when t\n    (car x)))",
+		"(let ((t 1)) t)",
+		"(let ((a (car 1))) a)",
+		"(let ((a 1) (b)) a)",
+		"(let (a) a)",
+		"(let* ((a 1) (b (car a))) b)",
+		"(cond (1))",
+		"(cond ((car 1) 2))",
+		"(cond (() 1) (t (car 2)))",
+		"(def t 3)",
+		"(set! zzz 3)",
+		"(defn 3)",
+		"((lambda (x) x) 1 2)",
+		"(3 4)",
+		"(raise 'foo '(bar) 3)",
+		"(rethrow 3)",
+		"(try (car 1) (catch e (cdr 2)))",
+		"(try (car 1) (finally (cdr 2)))",
+		"(try (car 1) (catch (type-error e) (rethrow e)))",
+		"(try 1 (finally 2) (catch e 3))",
+		"(errors '(foo) (car 1))",
+		"(test (car 1))",
+		"{(lambda (x) x) 1}",
+		"[1 (car 2)]",
+		"(and 1 (car 2))",
+		"(or () (car 2))",
+		"(loop (car 1))",
+		"(defmacro m (x) (car x))\n(m 1)",
+		"(defn h () (m3 1))\n(h)",
+		"(map (lambda (x) (car x)) '(1 2))",
+		"(sort-by (lambda (x) (car x)) '(1 2))",
+		"`(a ~(car 1))",
+		"(eval '(car 1))",
+		"(caddr 3)",
+		"(swallow (car 1))",
+		"(foreach x '(1 2)\n  (car x))",
+		"(1 . 2)",
+		"(defn adder (n) (lambda (m) (+ n m)))\n((adder 2) 3)",
+		"(defn f (x) (let ((g (lambda g (n) (if (zero? n) x (g (- n 1)))))) (g 3)))\n(f 7)",
+		"(defn f (x) ((lambda g (n) (if (zero? n) x (g (- n 1)))) 3))\n(list (f 7) (f 8))",
+		"(defn f (x) (eval '(+ x 1)))\n(f 1)",
+		"(defn f (x) (eval '(lambda g () x)) (g))\n(f 1)",
+		"(defn f (x) (set! x (+ x 1)) x)\n(f 1)",
+		"(let ((x 1)) (let ((y 2)) (set! x (+ x y))) x)",
+		"(def g 1)\n(defn f (x) (set! g (+ g x)))\n(f 2)\ng",
+		"(let ((x 1) (x 2)) x)",
+		"((lambda (x x) x) 1 2)",
+		"((lambda (x . x) x) 1 2)",
+		"((lambda (t) t) 1)",
+		"((lambda t (x) x) 1)",
+		"(try (car 1) (catch e (let ((f (lambda f () e))) (message (f)))))",
+		"(call/cc (lambda (k) (k 1 2)))",
+		"(block b (try (car 1) (finally (return-from b 2))))",
+		"(block b (try (return-from b 1) (finally (car 2))))",
+	}
+	for _, src := range tests {
+		compiled, err := NewInterpreter()
+		if err != nil {
+			t.Fatal(err)
+		}
+		walker, err := NewInterpreter(withTreeWalker())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, gotErr := compiled.evalNamed("f.l1", src)
+		want, wantErr := walker.evalNamed("f.l1", src)
+		if (gotErr == nil) != (wantErr == nil) {
+			t.Errorf("%s: got error %v, want %v", src, gotErr, wantErr)
+			continue
+		}
+		if wantErr == nil {
+			if !got.Equal(want) {
+				t.Errorf("%s: got %s, want %s", src, got, want)
+			}
+			continue
+		}
+		if gotErr.Error() != wantErr.Error() {
+			t.Errorf("%s: got error\n%s\nwant\n%s", src, gotErr, wantErr)
+		}
+		gotForm := gotErr.(*Condition).Form()
+		wantForm := wantErr.(*Condition).Form()
+		if gotForm.String() != wantForm.String() {
+			t.Errorf("%s: error raised by %s, want %s", src, gotForm, wantForm)
+		}
+	}
+}
+
+func TestCompiledTestSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs tests.l1 twice")
+	}
+	src, err := os.ReadFile("../tests.l1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(opts ...Option) string {
+		var out bytes.Buffer
+		interp, err := NewInterpreter(append(opts, WithStdout(&out))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := interp.EvalFile("../tests.l1"); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	if got, want := run(), run(withTreeWalker()); got != want {
+		t.Errorf("output of %d bytes of tests differs from the tree-walker's", len(src))
+	}
+}
+
+// Functions are compiled when first called, and macros defined since then
+// are expanded when the code calling them is reached:
+func TestLateMacros(t *testing.T) {
+	interp, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		in, want string
+	}{
+		{"(defn f (x) (when x (twice x)))", "()"},
+		{"(defn g (x) (cond (x (+ 1 (tens x))) (t 0)))", "()"},
+		{"(list (f ()) (g ()))", "(() 0)"},
+		{"(defmacro twice (x) `(list ~x ~x))", "()"},
+		{"(defmacro tens (x) `(* ~x 10))", "()"},
+		{"(list (f 1) (f 2) (g 3) (g 4))", "((1 1) (2 2) 31 41)"},
+	}
+	for _, test := range tests {
+		got, err := interp.Eval(test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.in, err)
+			continue
+		}
+		if got.String() != test.want {
+			t.Errorf("%s: got %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+// benchmarkEval compares the compiler and VM with the tree-walker on expr,
+// evaluated after setup.
+func benchmarkEval(b *testing.B, setup, expr string) {
+	for _, bm := range []struct {
+		name string
+		opts []Option
+	}{
+		{"walk", []Option{withTreeWalker()}},
+		{"compiled", nil},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			interp, err := NewInterpreter(append(bm.opts, WithStdout(io.Discard))...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := interp.Eval(setup); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := interp.Eval(expr); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// As in examples/fact.l1:
+func BenchmarkFact(b *testing.B) {
+	benchmarkEval(b, `
+(defn fact (n)
+  (if (zero? n)
+    1
+    (* n (fact (- n 1)))))`,
+		"(fact 100)")
+}
+
+// As in examples/primes.l1:
+func BenchmarkPrimes(b *testing.B) {
+	benchmarkEval(b, `
+(defn divides? (d x)
+  (zero? (rem x d)))
+
+(defn square (n) (* n n))
+
+(defn find-divisor (n test-divisor)
+  (cond ((> (square test-divisor) n) n)
+        ((divides? test-divisor n) test-divisor)
+        (t (find-divisor n (+ test-divisor 1)))))
+
+(defn smallest-divisor (n)
+  (find-divisor n 2))
+
+(defn prime? (n)
+  (= n (smallest-divisor n)))
+
+(defn helper (ret count n)
+  (cond
+   ((zero? count) (reverse ret))
+   ((prime? n) (helper (cons n ret)
+                       (- count 1)
+                       (+ 1 n)))
+   (t (helper ret count (+ 1 n)))))
+
+(defn n-primes (num)
+  (helper () num 1))`,
+		"(n-primes 100)")
+}
+
+// As in examples/tco.l1:
+func BenchmarkTCO(b *testing.B) {
+	benchmarkEval(b, `
+(defn sum-to-acc (n acc)
+  (cond ((zero? n) acc)
+        (t (sum-to-acc (- n 1) (+ n acc)))))
+
+(defn sum-to-acc-with-let (n acc)
+  (let ((_ 1))
+    (cond ((zero? n) acc)
+          (t (sum-to-acc-with-let (- n 1) (+ n acc))))))`,
+		"(sum-to-acc 10000 0) (sum-to-acc-with-let 10000 0)")
+}
+
+// The core library's recursive functions mostly look up local variables and
+// call other functions:
+func BenchmarkRange(b *testing.B) {
+	benchmarkEval(b, "", "(range 1000)")
+}
+
+func BenchmarkMap(b *testing.B) {
+	benchmarkEval(b, "(def l (range 1000))", "(map inc l)")
+}
+
+func BenchmarkReverse(b *testing.B) {
+	benchmarkEval(b, "(def l (range 200))", "(reverse l)")
+}
@@ -5,55 +5,111 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"runtime/pprof"
+	"strings"
+	"time"
 
 	"github.com/eigenhombre/l1/lisp"
 )
 
-func repl(e *lisp.Env) {
-top:
+func repl(interp *lisp.Interpreter) {
+	ed := interp.NewLineEditor(historyPath())
+	lineNum := 0
 	for {
-		fmt.Print("> ")
-		tokens := []lisp.Token{}
-	Inner:
-		for {
-			s, err := lisp.ReadLine()
-			switch err {
-			case nil:
-				these := lisp.LexItems([]string{s})
-				tokens = append(tokens, these...)
-				bal, err := lisp.IsBalanced(tokens)
-				if err != nil {
-					fmt.Printf("ERROR:\n%v\n", err)
-					goto top
-				}
-				if bal {
-					break Inner
-				}
-			case io.EOF:
-				fmt.Println()
-				return
-			default:
-				panic(err)
-			}
+		text, err := ed.ReadForm("> ")
+		switch err {
+		case nil:
+		case io.EOF:
+			fmt.Println()
+			return
+		default:
+			panic(err)
+		}
+		lines := strings.Split(text, "\n")
+		// Positions in errors count lines from the start of the session:
+		tokens := lisp.LexItemsFrom("repl", lineNum+1, lines)
+		lineNum += len(lines)
+		if _, err := lisp.IsBalanced(tokens); err != nil {
+			fmt.Printf("ERROR:\n%v\n", err)
+			continue
 		}
 		exprs, err := lisp.Parse(tokens)
 		if err != nil {
 			fmt.Printf("%v\n", err)
 			continue
 		}
-		lisp.EvalExprs(exprs, e, true)
+		if !interp.EvalREPL(exprs) {
+			return
+		}
+	}
+}
+
+// historyPath returns the path of the file the REPL keeps its history in,
+// or "" if there's no home directory to keep it in.
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".l1_history")
+}
+
+// formatFiles reformats files in place, or standard input to standard
+// output if there are none, returning the exit status.
+func formatFiles(files []string) int {
+	if len(files) == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err == nil {
+			var out string
+			out, err = lisp.Format(string(src))
+			fmt.Print(out)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "<stdin>: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	status := 0
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err == nil {
+			var out string
+			out, err = lisp.Format(string(src))
+			if err == nil && out != string(src) {
+				err = os.WriteFile(file, []byte(out), 0644)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			status = 1
+		}
 	}
+	return status
 }
 
 func main() {
-	var versionFlag, docFlag, longDocFlag bool
-	var cpuProfile, evalExpr string
+	var versionFlag, docFlag, longDocFlag, sandboxFlag, trustClientsFlag, lspFlag, fmtFlag bool
+	var cpuProfile, evalExpr, searchPath, serverAddr string
+	var maxSteps, maxConses int64
+	var maxDepth int
+	var timeout time.Duration
 	flag.BoolVar(&versionFlag, "v", false, "Get l1 version")
 	flag.StringVar(&cpuProfile, "p", "", "Write CPU profile to file")
 	flag.StringVar(&evalExpr, "e", "", "Evaluate expression")
 	flag.BoolVar(&docFlag, "doc", false, "Print documentation")
 	flag.BoolVar(&longDocFlag, "longdoc", false, "Print documentation")
+	flag.Int64Var(&maxSteps, "max-steps", 0, "Limit evaluation steps (0 for no limit)")
+	flag.IntVar(&maxDepth, "max-depth", 0, "Limit depth of nested calls (0 for no limit)")
+	flag.Int64Var(&maxConses, "max-conses", 0, "Limit cons cells made (0 for no limit)")
+	flag.DurationVar(&timeout, "timeout", 0, "Limit time taken by each evaluation (0 for no limit)")
+	flag.BoolVar(&sandboxFlag, "sandbox", false, "Deny builtins access to files, processes, the terminal, time and randomness")
+	flag.StringVar(&searchPath, "path", "", "Directories to search for modules, ahead of those in L1PATH")
+	flag.StringVar(&serverAddr, "server", "", "Serve sessions to editors on a TCP address (on 127.0.0.1 unless a host is given; other hosts need a token in L1_SERVER_TOKEN), or unix:path for a Unix socket")
+	flag.BoolVar(&trustClientsFlag, "trust-clients", false, "Let server sessions use files, processes and the terminal")
+	flag.BoolVar(&lspFlag, "lsp", false, "Serve the Language Server Protocol on stdin and stdout, instead of running any files given")
+	flag.BoolVar(&fmtFlag, "fmt", false, "Reformat the files given in place (or stdin to stdout), instead of running them")
 
 	flag.Parse()
 
@@ -71,21 +127,61 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	globals := lisp.InitGlobals()
-
-	err := lisp.LexParseEval(lisp.RawCore, &globals)
+	opts := []lisp.Option{
+		lisp.WithMaxSteps(maxSteps),
+		lisp.WithMaxDepth(maxDepth),
+		lisp.WithMaxConses(maxConses),
+		lisp.WithTimeout(timeout),
+	}
+	if sandboxFlag {
+		opts = append(opts, lisp.WithPolicy(lisp.StrictPolicy))
+	}
+	if searchPath != "" {
+		opts = append(opts, lisp.WithSearchPath(filepath.SplitList(searchPath)...))
+	}
+	if serverAddr != "" {
+		if trustClientsFlag && !sandboxFlag {
+			opts = append(opts, lisp.WithPolicy(lisp.Policy{}))
+		}
+		srv := lisp.NewServer(opts...)
+		srv.Token = os.Getenv("L1_SERVER_TOKEN")
+		l, err := lisp.Listen(serverAddr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("l1 server listening on %s\n", l.Addr())
+		fmt.Fprintln(os.Stderr, srv.Serve(l))
+		os.Exit(1)
+	}
+	if lspFlag {
+		if err := lisp.ServeLSP(os.Stdin, os.Stdout, opts...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if fmtFlag {
+		os.Exit(formatFiles(flag.Args()))
+	}
+	interp, err := lisp.NewInterpreter(opts...)
 	if err != nil {
-		fmt.Println(err)
-		fmt.Println("Failed to load l1 core library!")
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "Failed to load l1 core library!")
 		os.Exit(1)
 	}
 
 	if docFlag {
-		fmt.Println(lisp.ShortDocStr(&globals))
+		sd, err := lisp.ShortDocStr(interp.Env())
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(sd)
 		os.Exit(0)
 	}
 	if longDocFlag {
-		ld, err := lisp.LongDocStr(&globals)
+		ld, err := lisp.LongDocStr(interp.Env())
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -94,7 +190,7 @@ func main() {
 		os.Exit(0)
 	}
 	if evalExpr != "" {
-		err = lisp.LexParseEval(evalExpr, &globals)
+		_, err = interp.Eval(evalExpr)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -105,7 +201,7 @@ func main() {
 	files := flag.Args()
 	if len(files) > 0 {
 		for _, file := range files {
-			err := lisp.LoadFile(&globals, file)
+			_, err := interp.EvalFile(file)
 			if err != nil {
 				fmt.Printf("ERROR:\n%v\n", err)
 				os.Exit(1)
@@ -113,5 +209,5 @@ func main() {
 		}
 		return
 	}
-	repl(&globals)
+	repl(interp)
 }